@@ -0,0 +1,321 @@
+// Package backtest runs trading strategies against recorded order books.
+package backtest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+	"github.com/jacoduplessis/crypto/record"
+)
+
+// Strategy is called once for every recorded batch. It inspects the books
+// through the context and issues orders and transfers, which are filled
+// against the books of that moment.
+type Strategy func(c *Context)
+
+// Balances holds asset balances per exchange slug.
+type Balances map[string]map[*exchange.Asset]float64
+
+// Get returns the balance of asset at an exchange.
+func (b Balances) Get(slug string, asset *exchange.Asset) float64 {
+	return b[slug][asset]
+}
+
+func (b Balances) add(slug string, asset *exchange.Asset, amount float64) {
+	if b[slug] == nil {
+		b[slug] = map[*exchange.Asset]float64{}
+	}
+	b[slug][asset] += amount
+}
+
+func (b Balances) copy() Balances {
+	c := Balances{}
+	for slug, assets := range b {
+		for asset, amount := range assets {
+			c.add(slug, asset, amount)
+		}
+	}
+	return c
+}
+
+// Backtest describes a single run.
+type Backtest struct {
+	Player   *record.Player
+	Strategy Strategy
+
+	// Balances are the starting balances.
+	Balances Balances
+
+	// WithdrawalFees are charged, in the transferred asset, when an asset
+	// leaves an exchange.
+	WithdrawalFees Balances
+
+	// TransferDelays is how long an asset takes to arrive at the receiving
+	// exchange. Assets not listed arrive at the next step.
+	TransferDelays map[*exchange.Asset]time.Duration
+
+	// Numeraire is the asset P&L is measured in.
+	Numeraire *exchange.Asset
+}
+
+// Fill is a filled order.
+type Fill struct {
+	Time     time.Time
+	Exchange string
+	Pair     *exchange.Pair
+	Type     exchange.OrderType
+	Volume   float64
+	Price    float64
+	Value    float64
+	Fee      float64
+}
+
+// Transfer is an asset movement between exchanges.
+type Transfer struct {
+	Time    time.Time
+	Arrival time.Time
+	Asset   *exchange.Asset
+	From    string
+	To      string
+	Amount  float64
+	Fee     float64
+}
+
+// Result summarises a run. Values are in the numeraire.
+type Result struct {
+	Start       time.Time
+	End         time.Time
+	StartValue  float64
+	EndValue    float64
+	PnL         float64
+	MaxDrawdown float64
+	// MaxDrawdownPct is the largest fall from a peak, relative to the peak.
+	MaxDrawdownPct float64
+	Trades         int
+	Fills          []*Fill
+	Transfers      []*Transfer
+	Balances       Balances
+}
+
+// Context is handed to the strategy at every step. Balances must only be
+// changed through Submit and Transfer.
+type Context struct {
+	Time     time.Time
+	Books    []*exchange.OrderBook
+	Balances Balances
+
+	run *run
+}
+
+type run struct {
+	bt       *Backtest
+	balances Balances
+	pending  []*Transfer
+	prices   map[*exchange.Asset]float64
+	result   *Result
+}
+
+// Book returns the book for a pair on an exchange, or nil.
+func (c *Context) Book(slug, pairCode string) *exchange.OrderBook {
+	for _, ob := range c.Books {
+		if ob.Exchange.Meta().Slug == slug && ob.Pair.Code == pairCode {
+			return ob
+		}
+	}
+	return nil
+}
+
+// Submit fills route requests in order against the current books. It stops
+// at the first request that cannot be filled, either for lack of depth or of
+// balance, and returns its error.
+func (c *Context) Submit(requests ...*exchange.RouteRequest) error {
+
+	for _, r := range requests {
+		if err := c.fill(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Context) fill(r *exchange.RouteRequest) error {
+
+	if r.Exchange == nil {
+		return fmt.Errorf("Route request for %s has no exchange", r.Pair.Code)
+	}
+	slug := r.Exchange.Meta().Slug
+	ob := c.Book(slug, r.Pair.Code)
+	if ob == nil {
+		return fmt.Errorf("No book for %s on %s at %s", r.Pair.Code, slug, c.Time)
+	}
+
+	t := &exchange.Trade{OrderBook: ob.Prepare(), Amount: r.Volume, Type: r.Type, Pair: ob.Pair}
+	res, err := t.Simulate()
+	if err != nil {
+		return err
+	}
+
+	b := c.run.balances
+	base, quote := ob.Pair.Base, ob.Pair.Quote
+
+	if r.Type == exchange.BUY {
+		cost := res.Gross + res.Fee
+		if b.Get(slug, quote) < cost {
+			return fmt.Errorf("Insufficient %s on %s: have %f, need %f", quote.Slug, slug, b.Get(slug, quote), cost)
+		}
+		b.add(slug, quote, -cost)
+		b.add(slug, base, r.Volume)
+	} else {
+		if b.Get(slug, base) < r.Volume {
+			return fmt.Errorf("Insufficient %s on %s: have %f, need %f", base.Slug, slug, b.Get(slug, base), r.Volume)
+		}
+		b.add(slug, base, -r.Volume)
+		b.add(slug, quote, res.Nett)
+	}
+
+	out := c.run.result
+	out.Trades++
+	out.Fills = append(out.Fills, &Fill{
+		Time:     c.Time,
+		Exchange: slug,
+		Pair:     ob.Pair,
+		Type:     r.Type,
+		Volume:   r.Volume,
+		Price:    res.GrossUnit,
+		Value:    res.Gross,
+		Fee:      res.Fee,
+	})
+	return nil
+}
+
+// Transfer withdraws amount of asset from one exchange and deposits it, less
+// the withdrawal fee, at another once the transfer delay has passed.
+func (c *Context) Transfer(asset *exchange.Asset, amount float64, from, to string) error {
+
+	b := c.run.balances
+	if b.Get(from, asset) < amount {
+		return fmt.Errorf("Insufficient %s on %s: have %f, need %f", asset.Slug, from, b.Get(from, asset), amount)
+	}
+	fee := c.run.bt.WithdrawalFees.Get(from, asset)
+	if fee >= amount {
+		return fmt.Errorf("Transfer of %f %s is less than the %f withdrawal fee", amount, asset.Slug, fee)
+	}
+	b.add(from, asset, -amount)
+
+	t := &Transfer{
+		Time:    c.Time,
+		Arrival: c.Time.Add(c.run.bt.TransferDelays[asset]),
+		Asset:   asset,
+		From:    from,
+		To:      to,
+		Amount:  amount - fee,
+		Fee:     fee,
+	}
+	c.run.pending = append(c.run.pending, t)
+	c.run.result.Transfers = append(c.run.result.Transfers, t)
+	return nil
+}
+
+// Run steps through every batch the player holds, from its current clock
+// time, and returns the result.
+func (bt *Backtest) Run() (*Result, error) {
+
+	if bt.Numeraire == nil {
+		return nil, fmt.Errorf("Backtest needs a numeraire")
+	}
+
+	r := &run{
+		bt:       bt,
+		balances: bt.Balances.copy(),
+		prices:   map[*exchange.Asset]float64{bt.Numeraire: 1},
+		result:   &Result{},
+	}
+
+	var peak float64
+	first := true
+	for {
+		now := bt.Player.Now()
+		books := bt.Player.OrderBooks()
+		r.arrive(now)
+		r.updatePrices(books)
+
+		if first {
+			r.result.Start = now
+			r.result.StartValue = r.value()
+			peak = r.result.StartValue
+			first = false
+		}
+
+		c := &Context{Time: now, Books: books, Balances: r.balances, run: r}
+		bt.Strategy(c)
+
+		value := r.value()
+		if value > peak {
+			peak = value
+		}
+		dd := peak - value
+		if dd > r.result.MaxDrawdown {
+			r.result.MaxDrawdown = dd
+		}
+		if peak > 0 && dd/peak > r.result.MaxDrawdownPct {
+			r.result.MaxDrawdownPct = dd / peak
+		}
+		r.result.End = now
+		r.result.EndValue = value
+
+		if !bt.Player.Step() {
+			break
+		}
+	}
+
+	r.result.PnL = r.result.EndValue - r.result.StartValue
+	r.result.Balances = r.balances
+	return r.result, nil
+}
+
+func (r *run) arrive(now time.Time) {
+
+	var pending []*Transfer
+	for _, t := range r.pending {
+		if t.Arrival.After(now) {
+			pending = append(pending, t)
+			continue
+		}
+		r.balances.add(t.To, t.Asset, t.Amount)
+	}
+	r.pending = pending
+}
+
+// updatePrices records the mid price, in the numeraire, of every asset
+// directly quoted against it. Prices of assets without a book this step are
+// kept from earlier steps.
+func (r *run) updatePrices(books []*exchange.OrderBook) {
+
+	for _, ob := range books {
+		if len(ob.Bids) == 0 || len(ob.Asks) == 0 {
+			continue
+		}
+		mid := (ob.Bids[0][0] + ob.Asks[0][0]) / 2
+		switch r.bt.Numeraire {
+		case ob.Pair.Quote:
+			r.prices[ob.Pair.Base] = mid
+		case ob.Pair.Base:
+			r.prices[ob.Pair.Quote] = 1 / mid
+		}
+	}
+}
+
+func (r *run) value() float64 {
+
+	var v float64
+	for _, assets := range r.balances {
+		for asset, amount := range assets {
+			v += amount * r.prices[asset]
+		}
+	}
+	for _, t := range r.pending {
+		v += t.Amount * r.prices[t.Asset]
+	}
+	return v
+}
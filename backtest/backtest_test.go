@@ -0,0 +1,73 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+	"github.com/jacoduplessis/crypto/record"
+)
+
+func TestPremium(t *testing.T) {
+
+	t0 := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+
+	var snapshots []*record.Snapshot
+	for _, tm := range []time.Time{t0, t1} {
+		snapshots = append(snapshots,
+			&record.Snapshot{Time: tm, Exchange: "kraken", Pair: "XXBTZEUR", Bids: [][2]float64{{4990, 10}}, Asks: [][2]float64{{5000, 10}}},
+			&record.Snapshot{Time: tm, Exchange: "fnb", Pair: "EURZAR", Bids: [][2]float64{{14.9, 1e6}}, Asks: [][2]float64{{15, 1e6}}},
+			&record.Snapshot{Time: tm, Exchange: "luno", Pair: "XBTZAR", Bids: [][2]float64{{80000, 10}}, Asks: [][2]float64{{81000, 10}}},
+		)
+	}
+	p := record.NewPlayer(snapshots, &exchange.Kraken{}, &exchange.FNB{}, &exchange.Luno{})
+
+	bt := &Backtest{
+		Player:         p,
+		Balances:       Balances{"kraken": {exchange.Euro: 5000}},
+		WithdrawalFees: Balances{"kraken": {exchange.Bitcoin: 0.001}},
+		TransferDelays: map[*exchange.Asset]time.Duration{exchange.Bitcoin: 30 * time.Minute},
+		Numeraire:      exchange.Rand,
+		Strategy: func(c *Context) {
+			kraken := c.Book("kraken", "XXBTZEUR")
+			luno := c.Book("luno", "XBTZAR")
+			if c.Balances.Get("kraken", exchange.Euro) > 0 {
+				err := c.Submit(&exchange.RouteRequest{Pair: kraken.Pair, Volume: 1, Type: exchange.BUY, Exchange: kraken.Exchange})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := c.Transfer(exchange.Bitcoin, 1, "kraken", "luno"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if v := c.Balances.Get("luno", exchange.Bitcoin); v > 0 {
+				err := c.Submit(&exchange.RouteRequest{Pair: luno.Pair, Volume: v, Type: exchange.SELL, Exchange: luno.Exchange})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		},
+	}
+
+	res, err := bt.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+	if res.Trades != 2 || len(res.Transfers) != 1 {
+		t.Errorf("Expected 2 trades and 1 transfer, got %d and %d", res.Trades, len(res.Transfers))
+	}
+	if !near(res.StartValue, 74750) || !near(res.EndValue, 79920) || !near(res.PnL, 5170) {
+		t.Errorf("Unexpected values: start %f, end %f, pnl %f", res.StartValue, res.EndValue, res.PnL)
+	}
+	if !near(res.MaxDrawdown, 499.5) {
+		t.Errorf("Expected drawdown of 499.5, got %f", res.MaxDrawdown)
+	}
+	if !near(res.Balances.Get("luno", exchange.Rand), 79920) || res.Balances.Get("kraken", exchange.Euro) != 0 {
+		t.Errorf("Unexpected balances %v", res.Balances)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+)
+
+type bookRecord struct {
+	Exchange string      `json:"exchange"`
+	Pair     string      `json:"pair"`
+	Bids     int         `json:"bids"`
+	Asks     int         `json:"asks"`
+	BestBid  *float64    `json:"best_bid"`
+	BestAsk  *float64    `json:"best_ask"`
+	Spread   *float64    `json:"spread"`
+	Levels   *bookLevels `json:"levels,omitempty"`
+}
+
+type bookLevels struct {
+	Bids [][2]float64 `json:"bids"`
+	Asks [][2]float64 `json:"asks"`
+}
+
+func books(args []string) error {
+
+	var f filter
+	fs := flag.NewFlagSet("books", flag.ExitOnError)
+	f.register(fs)
+	depth := fs.Int("depth", 0, "include this many levels per side in JSON output")
+	fs.Parse(args)
+
+	if err := checkFormat(f.format); err != nil {
+		return err
+	}
+
+	selected, err := f.selected()
+	if err != nil {
+		return err
+	}
+
+	t := &table{Header: []string{"EXCHANGE", "PAIR", "BIDS", "BEST BID", "BEST ASK", "ASKS", "SPREAD"}}
+	records := []*bookRecord{}
+
+	for _, ob := range fetch(newClient(list(selected)), selected) {
+		r := &bookRecord{
+			Exchange: ob.Exchange.Meta().Slug,
+			Pair:     ob.Pair.Code,
+			Bids:     len(ob.Bids),
+			Asks:     len(ob.Asks),
+		}
+		row := []string{r.Exchange, r.Pair, strconv.Itoa(r.Bids), "-", "-", strconv.Itoa(r.Asks), "-"}
+
+		// empty books are listed rather than indexed into
+		if len(ob.Bids) > 0 {
+			r.BestBid = &ob.Bids[0][0]
+			row[3] = num(*r.BestBid)
+		}
+		if len(ob.Asks) > 0 {
+			r.BestAsk = &ob.Asks[0][0]
+			row[4] = num(*r.BestAsk)
+		}
+		if r.BestBid != nil && r.BestAsk != nil {
+			spread := *r.BestAsk - *r.BestBid
+			r.Spread = &spread
+			row[6] = num(spread)
+		}
+		if *depth > 0 {
+			r.Levels = &bookLevels{Bids: head(ob.Bids, *depth), Asks: head(ob.Asks, *depth)}
+		}
+
+		records = append(records, r)
+		t.Rows = append(t.Rows, row)
+	}
+	t.Records = records
+
+	return t.write(os.Stdout, f.format)
+}
+
+func head(levels [][2]float64, n int) [][2]float64 {
+	if len(levels) < n {
+		return levels
+	}
+	return levels[:n]
+}
@@ -0,0 +1,274 @@
+// Command crypto prints order books, trade quotes, routes and the ZAR
+// premium, or serves them over HTTP.
+//
+// Usage:
+//
+//	crypto books   [-exchange luno,kraken] [-pair XBTZAR] [-format table|json|csv]
+//	crypto quote   -exchange luno -pair XBTZAR -amount 1 [-side buy|sell] [-quote]
+//	crypto route   -legs kraken:XXBTZEUR,luno:XBTZAR -asset eur -amount 1000 [-format table|json|csv]
+//	crypto premium [-format table|json|csv]
+//	crypto serve   [-addr :8080] [-interval 10s] [-exchange luno,kraken] [-origins https://dash.example.com] [-discover]
+//
+// Every command takes -config with the path of a config file describing the
+// exchanges to use; see package config. Cloudflare clearance
+// cookies are kept in the user's cache directory under crypto/cookies.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacoduplessis/crypto/config"
+	"github.com/jacoduplessis/crypto/exchange"
+	"github.com/jacoduplessis/crypto/scraper"
+)
+
+var commands = map[string]func(args []string) error{
+	"books":   books,
+	"quote":   quote,
+	"route":   route,
+	"premium": premium,
+	"serve":   serve,
+}
+
+func main() {
+
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "crypto:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: crypto <command> [flags]\n\ncommands: %s\n", strings.Join(names, ", "))
+}
+
+// newClient returns a client keeping within the rate limits of exchanges,
+// whose hosts are those configured.
+func newClient(exchanges []exchange.Exchange) http.Client {
+	return http.Client{Transport: exchange.NewScheduler(exchanges...).Transport(newTransport())}
+}
+
+// newTransport returns a scraper transport keeping clearance cookies in the
+// user's cache directory, so they survive restarts.
+func newTransport() *scraper.Transport {
+
+	dir, err := os.UserCacheDir()
+	if err == nil {
+		var jar *scraper.Jar
+		jar, err = scraper.NewJar(filepath.Join(dir, "crypto", "cookies"))
+		if err == nil {
+			return scraper.NewTransportWithJar(http.DefaultTransport, jar)
+		}
+	}
+	log.Printf("keeping cookies in memory: %v", err)
+	return scraper.NewTransport(http.DefaultTransport)
+}
+
+// filter holds the flags shared by all commands.
+type filter struct {
+	config    string
+	exchanges string
+	pairs     string
+	format    string
+}
+
+func (f *filter) register(fs *flag.FlagSet) {
+	f.registerConfig(fs)
+	fs.StringVar(&f.exchanges, "exchange", "", "comma separated exchange slugs (default all)")
+	fs.StringVar(&f.pairs, "pair", "", "comma separated pair codes (default all)")
+	f.registerFormat(fs)
+}
+
+func (f *filter) registerConfig(fs *flag.FlagSet) {
+	fs.StringVar(&f.config, "config", "", "config file (default all exchanges with default settings)")
+}
+
+// load returns the config file, or nil if there is none.
+func (f *filter) load() (*config.Config, error) {
+
+	if f.config == "" {
+		return nil, nil
+	}
+	return config.Load(f.config)
+}
+
+func (f *filter) registerFormat(fs *flag.FlagSet) {
+	fs.StringVar(&f.format, "format", "table", "output format: table, json or csv")
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// available returns the exchanges of the config file by slug, or every
+// registered exchange with default options if there is none.
+func (f *filter) available() (map[string]exchange.Exchange, error) {
+
+	cfg, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	exchanges := exchange.All()
+	if cfg != nil {
+		if exchanges, err = cfg.Build(); err != nil {
+			return nil, err
+		}
+	}
+	available := map[string]exchange.Exchange{}
+	for _, e := range exchanges {
+		available[e.Meta().Slug] = e
+	}
+	return available, nil
+}
+
+// selected returns the exchanges and pairs matching the filter.
+func (f *filter) selected() (map[exchange.Exchange][]*exchange.Pair, error) {
+
+	available, err := f.available()
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := split(f.exchanges)
+	if slugs == nil {
+		for slug := range available {
+			slugs = append(slugs, slug)
+		}
+		sort.Strings(slugs)
+	}
+
+	wanted := map[string]bool{}
+	for _, code := range split(f.pairs) {
+		wanted[strings.ToUpper(code)] = true
+	}
+
+	selected := map[exchange.Exchange][]*exchange.Pair{}
+	for _, slug := range slugs {
+		e, ok := available[slug]
+		if !ok {
+			return nil, fmt.Errorf("unknown or disabled exchange %q", slug)
+		}
+		for _, p := range e.Meta().Pairs {
+			if len(wanted) == 0 || wanted[strings.ToUpper(p.Code)] {
+				selected[e] = append(selected[e], p)
+			}
+		}
+	}
+	return selected, nil
+}
+
+// list returns the selected exchanges, sorted by slug.
+func list(selected map[exchange.Exchange][]*exchange.Pair) []exchange.Exchange {
+
+	var exchanges []exchange.Exchange
+	for e := range selected {
+		exchanges = append(exchanges, e)
+	}
+	sort.Slice(exchanges, func(i, j int) bool { return exchanges[i].Meta().Slug < exchanges[j].Meta().Slug })
+	return exchanges
+}
+
+// retries overrides exchange.DefaultRetryPolicy for exchanges whose rate
+// limit recovers slowly.
+var retries = map[string]*exchange.RetryPolicy{
+	"kraken": {Attempts: 3, Base: 3 * time.Second, Max: 15 * time.Second},
+}
+
+func newFetcher(client http.Client) *exchange.Fetcher {
+	return &exchange.Fetcher{Client: client, Retry: exchange.DefaultRetryPolicy, Retries: retries}
+}
+
+// fetch gets the selected books concurrently. Failed fetches are reported on
+// stderr and left out, so one unavailable exchange doesn't hide the rest.
+func fetch(client http.Client, selected map[exchange.Exchange][]*exchange.Pair) []*exchange.OrderBook {
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		books []*exchange.OrderBook
+	)
+	fetcher := newFetcher(client)
+	for e, pairs := range selected {
+		if _, ok := e.(exchange.BookBatcher); ok {
+			wg.Add(1)
+			go func(e exchange.Exchange, pairs []*exchange.Pair) {
+				defer wg.Done()
+				obs, err := fetcher.GetOrderBookBatch(context.Background(), e, pairs)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "crypto: %s: %v\n", e.Meta().Slug, err)
+					return
+				}
+				books = append(books, obs...)
+			}(e, pairs)
+			continue
+		}
+		for _, p := range pairs {
+			wg.Add(1)
+			go func(e exchange.Exchange, p *exchange.Pair) {
+				defer wg.Done()
+				ob, err := fetcher.GetOrderBook(e, p)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "crypto: %s %s: %v\n", e.Meta().Slug, p.Code, err)
+					return
+				}
+				ob.Exchange = e
+				books = append(books, ob)
+			}(e, p)
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(books, func(i, j int) bool {
+		si, sj := books[i].Exchange.Meta().Slug, books[j].Exchange.Meta().Slug
+		if si != sj {
+			return si < sj
+		}
+		return books[i].Pair.Code < books[j].Pair.Code
+	})
+	return books
+}
+
+func parseSide(s string) (exchange.OrderType, error) {
+	switch strings.ToLower(s) {
+	case "buy":
+		return exchange.BUY, nil
+	case "sell":
+		return exchange.SELL, nil
+	}
+	return exchange.BUY, fmt.Errorf("side must be buy or sell, not %q", s)
+}
+
+func sideName(t exchange.OrderType) string {
+	if t == exchange.BUY {
+		return "buy"
+	}
+	return "sell"
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jacoduplessis/crypto/exchange"
+	"github.com/jacoduplessis/crypto/fixture"
+)
+
+func TestSelected(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "crypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := filepath.Join(dir, "crypto.json")
+	err = ioutil.WriteFile(cfg, []byte(`{"exchanges": {"luno": {"pairs": ["XBTZAR"]}, "ecb": {}, "kraken": {"enabled": false}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		f    filter
+		want map[string][]string
+		err  string
+	}{
+		{"exchange and pair", filter{exchanges: "luno", pairs: "xbtzar"}, map[string][]string{"luno": {"XBTZAR"}}, ""},
+		{"pair on several", filter{exchanges: "ecb,fnb", pairs: "EURZAR"}, map[string][]string{"ecb": {"EURZAR"}, "fnb": {"EURZAR"}}, ""},
+		{"no match", filter{exchanges: "ecb", pairs: "XBTZAR"}, map[string][]string{}, ""},
+		{"config", filter{config: cfg}, map[string][]string{"ecb": {"EURZAR", "EURUSD", "EURGBP"}, "luno": {"XBTZAR"}}, ""},
+		{"disabled", filter{config: cfg, exchanges: "kraken"}, nil, `unknown or disabled exchange "kraken"`},
+		{"unknown", filter{exchanges: "bitstamp"}, nil, `unknown or disabled exchange "bitstamp"`},
+		{"missing config", filter{config: filepath.Join(dir, "missing.json")}, nil, "no such file"},
+	} {
+		selected, err := c.f.selected()
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got %v, want an error containing %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		got := map[string][]string{}
+		for e, pairs := range selected {
+			for _, p := range pairs {
+				got[e.Meta().Slug] = append(got[e.Meta().Slug], p.Code)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+
+		var slugs []string
+		for _, e := range list(selected) {
+			slugs = append(slugs, e.Meta().Slug)
+		}
+		if !sort.StringsAreSorted(slugs) {
+			t.Errorf("%s: list is not sorted: %v", c.name, slugs)
+		}
+	}
+}
+
+// replayed returns the exchanges answered from their fixtures in
+// exchange/testdata/http, by slug.
+func replayed(t *testing.T, slugs ...string) map[string]exchange.Exchange {
+
+	t.Helper()
+	available := map[string]exchange.Exchange{}
+	for _, slug := range slugs {
+		tr := &fixture.Transport{Dir: filepath.Join("..", "..", "exchange", "testdata", "http", slug)}
+		e, err := exchange.New(slug, exchange.Options{Client: &http.Client{Transport: tr}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		available[slug] = e
+	}
+	return available
+}
+
+func TestBuildRoute(t *testing.T) {
+
+	// the cookie jar of the command's transport stays out of the user's cache
+	dir, err := ioutil.TempDir("", "crypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", dir)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	available := replayed(t, "ecb", "kraken", "luno")
+	for _, c := range []struct {
+		legs  string
+		start *exchange.Asset
+		pairs []string
+		err   string
+	}{
+		{"kraken:XXBTZEUR,luno:XBTZAR", exchange.Euro, []string{"XXBTZEUR", "XBTZAR"}, ""},
+		{"luno:xbtzar,kraken:xxbtzeur,ecb:EURZAR", exchange.Rand, []string{"XBTZAR", "XXBTZEUR", "EURZAR"}, ""},
+		{"", nil, nil, "-legs is required"},
+		{"luno", nil, nil, `leg "luno" must be exchange:pair`},
+		{"fnb:EURZAR", nil, nil, `unknown or disabled exchange "fnb"`},
+		{"luno:DOGEZAR", nil, nil, `luno has no pair "DOGEZAR"`},
+	} {
+		r, err := buildRoute(available, c.legs)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%q: got %v, want %q", c.legs, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.legs, err)
+			continue
+		}
+		var pairs []string
+		for _, l := range r.Legs {
+			pairs = append(pairs, l.Pair.Code)
+			if l.OrderBook == nil || len(l.OrderBook.Bids) == 0 || len(l.OrderBook.Asks) == 0 {
+				t.Errorf("%q: leg %s has no book", c.legs, l.Pair.Code)
+			}
+		}
+		if !reflect.DeepEqual(pairs, c.pairs) {
+			t.Errorf("%q: legs %v, want %v", c.legs, pairs, c.pairs)
+		}
+		res, err := r.Simulate(c.start, 100)
+		if err != nil || !(res.Amount > 0) || len(res.Requests) != len(c.pairs) {
+			t.Errorf("%q: unexpected result %+v, %v", c.legs, res, err)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestFlags(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "crypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", dir)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	// bad flags are refused before anything is fetched
+	defer func(tr http.RoundTripper) { http.DefaultTransport = tr }(http.DefaultTransport)
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected request for %s", r.URL)
+		return nil, fmt.Errorf("no network")
+	})
+
+	for _, c := range []struct {
+		command string
+		args    []string
+		err     string
+	}{
+		{"books", []string{"-exchange", "luno", "-format", "xml"}, `unknown format "xml"`},
+		{"quote", []string{"-exchange", "luno", "-amount", "1", "-format", "xml"}, `unknown format "xml"`},
+		{"quote", []string{"-exchange", "luno", "-amount", "1", "-side", "hold"}, `side must be buy or sell, not "hold"`},
+		{"route", []string{"-legs", "luno:XBTZAR", "-asset", "zar", "-amount", "1", "-format", "xml"}, `unknown format "xml"`},
+		{"route", []string{"-legs", "luno:XBTZAR,luno", "-asset", "zar", "-amount", "1"}, `leg "luno" must be exchange:pair`},
+		{"premium", []string{"-format", "xml"}, `unknown format "xml"`},
+	} {
+		err := commands[c.command](c.args)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s %v: got %v, want %q", c.command, c.args, err, c.err)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+
+	type record struct {
+		Pair string  `json:"pair"`
+		Bid  float64 `json:"bid"`
+	}
+	tb := &table{
+		Header:  []string{"PAIR", "BID"},
+		Rows:    [][]string{{"XBTZAR", num(150900)}, {"EURZAR", fixed(15.871, 2)}},
+		Records: []record{{"XBTZAR", 150900}, {"EURZAR", 15.871}},
+	}
+
+	for _, c := range []struct {
+		format string
+		want   string
+		err    string
+	}{
+		{"table", "    PAIR     BID\n  XBTZAR  150900\n  EURZAR   15.87\n", ""},
+		{"", "    PAIR     BID\n  XBTZAR  150900\n  EURZAR   15.87\n", ""},
+		{"csv", "PAIR,BID\nXBTZAR,150900\nEURZAR,15.87\n", ""},
+		{"json", "[\n  {\n    \"pair\": \"XBTZAR\",\n    \"bid\": 150900\n  },\n  {\n    \"pair\": \"EURZAR\",\n    \"bid\": 15.871\n  }\n]\n", ""},
+		{"xml", "", `unknown format "xml"`},
+	} {
+		var b bytes.Buffer
+		err := tb.write(&b, c.format)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%q: got %v, want %q", c.format, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.format, err)
+		}
+		if b.String() != c.want {
+			t.Errorf("%q: got\n%q\nwant\n%q", c.format, b.String(), c.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// table is command output. Records is what the JSON format prints; Header
+// and Rows are used for the table and CSV formats.
+type table struct {
+	Header  []string
+	Rows    [][]string
+	Records interface{}
+}
+
+func (t *table) write(w io.Writer, format string) error {
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t.Records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(t.Header)
+		cw.WriteAll(t.Rows)
+		return cw.Error()
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, strings.Join(t.Header, "\t")+"\t")
+		for _, r := range t.Rows {
+			fmt.Fprintln(tw, strings.Join(r, "\t")+"\t")
+		}
+		return tw.Flush()
+	}
+	return checkFormat(format)
+}
+
+// checkFormat returns an error if write doesn't know format, so commands can
+// refuse it before fetching anything.
+func checkFormat(format string) error {
+
+	switch format {
+	case "json", "csv", "table", "":
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func num(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func fixed(f float64, prec int) string {
+	return strconv.FormatFloat(f, 'f', prec, 64)
+}
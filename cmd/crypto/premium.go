@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+type premiumRecord struct {
+	Asset    string  `json:"asset"`
+	Exchange string  `json:"exchange"`
+	Pair     string  `json:"pair"`
+	Local    float64 `json:"local"`
+	Offshore string  `json:"offshore"`
+	Foreign  float64 `json:"foreign"`
+	FX       float64 `json:"fx"`
+	Premium  float64 `json:"premium"`
+}
+
+// premium compares the rand price of each crypto asset on local exchanges
+// with its euro price offshore converted at the bank rate. Mid prices are
+// used throughout.
+func premium(args []string) error {
+
+	var f filter
+	fs := flag.NewFlagSet("premium", flag.ExitOnError)
+	f.register(fs)
+	fs.Parse(args)
+
+	if err := checkFormat(f.format); err != nil {
+		return err
+	}
+
+	// the filters pick local books; everything is fetched since the
+	// offshore and forex books are always needed
+	local, err := f.selected()
+	if err != nil {
+		return err
+	}
+	all, err := (&filter{config: f.config}).selected()
+	if err != nil {
+		return err
+	}
+	books := fetch(newClient(list(all)), all)
+
+	wanted := map[string]bool{}
+	for e, pairs := range local {
+		for _, p := range pairs {
+			wanted[e.Meta().Slug+":"+p.Code] = true
+		}
+	}
+
+	// bank transfer rates; notes pairs such as fnb's EURZAR-NOTES are left out
+	var fx *exchange.OrderBook
+	for _, ob := range books {
+		if ob.Pair.Code == "EURZAR" && ob.Pair.Base == exchange.Euro && ob.Pair.Quote == exchange.Rand && mid(ob) > 0 {
+			fx = ob
+		}
+	}
+	if fx == nil {
+		return fmt.Errorf("no EURZAR rate available")
+	}
+
+	t := &table{Header: []string{"ASSET", "EXCHANGE", "PAIR", "LOCAL", "OFFSHORE", "FOREIGN", "EURZAR", "PREMIUM"}}
+	records := []*premiumRecord{}
+
+	for _, lob := range books {
+		if lob.Pair.Quote != exchange.Rand || lob.Pair.Base == exchange.Euro || mid(lob) == 0 {
+			continue
+		}
+		if !wanted[lob.Exchange.Meta().Slug+":"+lob.Pair.Code] {
+			continue
+		}
+		for _, fob := range books {
+			if fob.Pair.Base != lob.Pair.Base || fob.Pair.Quote != exchange.Euro || mid(fob) == 0 {
+				continue
+			}
+			r := &premiumRecord{
+				Asset:    lob.Pair.Base.Code,
+				Exchange: lob.Exchange.Meta().Slug,
+				Pair:     lob.Pair.Code,
+				Local:    mid(lob),
+				Offshore: fob.Exchange.Meta().Slug,
+				Foreign:  mid(fob),
+				FX:       mid(fx),
+			}
+			r.Premium = r.Local/(r.Foreign*r.FX) - 1
+			records = append(records, r)
+			t.Rows = append(t.Rows, []string{strings.ToUpper(r.Asset), r.Exchange, r.Pair, fixed(r.Local, 2),
+				r.Offshore, fixed(r.Foreign, 2), fixed(r.FX, 4), fixed(r.Premium*100, 2) + "%"})
+		}
+	}
+	t.Records = records
+
+	return t.write(os.Stdout, f.format)
+}
+
+// mid returns the mid price of a book, or zero if either side is empty.
+func mid(ob *exchange.OrderBook) float64 {
+	if len(ob.Bids) == 0 || len(ob.Asks) == 0 {
+		return 0
+	}
+	return (ob.Bids[0][0] + ob.Asks[0][0]) / 2
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+type quoteRecord struct {
+	Exchange string  `json:"exchange"`
+	Pair     string  `json:"pair"`
+	Side     string  `json:"side"`
+	Amount   float64 `json:"amount"`
+	Asset    string  `json:"asset"`
+	Gross    float64 `json:"gross"`
+	Fee      float64 `json:"fee"`
+	Nett     float64 `json:"nett"`
+	Unit     float64 `json:"unit"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func quote(args []string) error {
+
+	var f filter
+	fs := flag.NewFlagSet("quote", flag.ExitOnError)
+	f.register(fs)
+	amount := fs.Float64("amount", 0, "amount to trade")
+	side := fs.String("side", "buy", "buy or sell")
+	inQuote := fs.Bool("quote", false, "amount is in the quote asset rather than the base asset")
+	fs.Parse(args)
+
+	if err := checkFormat(f.format); err != nil {
+		return err
+	}
+
+	if *amount <= 0 {
+		return fmt.Errorf("-amount must be positive")
+	}
+	ot, err := parseSide(*side)
+	if err != nil {
+		return err
+	}
+
+	selected, err := f.selected()
+	if err != nil {
+		return err
+	}
+
+	t := &table{Header: []string{"EXCHANGE", "PAIR", "SIDE", "AMOUNT", "GROSS", "FEE", "NETT", "UNIT"}}
+	records := []*quoteRecord{}
+
+	for _, ob := range fetch(newClient(list(selected)), selected) {
+		r := &quoteRecord{
+			Exchange: ob.Exchange.Meta().Slug,
+			Pair:     ob.Pair.Code,
+			Side:     sideName(ot),
+			Amount:   *amount,
+			Asset:    ob.Pair.Base.Code,
+		}
+		if *inQuote {
+			r.Asset = ob.Pair.Quote.Code
+		}
+
+		trade := &exchange.Trade{OrderBook: ob.Prepare(), Amount: *amount, Type: ot, Quote: *inQuote, Pair: ob.Pair}
+		res, err := trade.Simulate()
+		if err != nil {
+			r.Error = err.Error()
+			t.Rows = append(t.Rows, []string{r.Exchange, r.Pair, r.Side, num(r.Amount) + " " + r.Asset, r.Error, "", "", ""})
+		} else {
+			r.Gross, r.Fee, r.Nett, r.Unit = res.Gross, res.Fee, res.Nett, res.GrossUnit
+			t.Rows = append(t.Rows, []string{r.Exchange, r.Pair, r.Side, num(r.Amount) + " " + r.Asset,
+				fixed(r.Gross, 8), fixed(r.Fee, 8), fixed(r.Nett, 8), fixed(r.Unit, 8)})
+		}
+		records = append(records, r)
+	}
+	t.Records = records
+
+	return t.write(os.Stdout, f.format)
+}
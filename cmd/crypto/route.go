@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+type routeRecord struct {
+	Asset       string           `json:"asset"`
+	Amount      float64          `json:"amount"`
+	Result      float64          `json:"result"`
+	ResultAsset string           `json:"result_asset"`
+	Requests    []*requestRecord `json:"requests"`
+	Description string           `json:"description"`
+}
+
+type requestRecord struct {
+	Exchange string  `json:"exchange"`
+	Pair     string  `json:"pair"`
+	Side     string  `json:"side"`
+	Volume   float64 `json:"volume"`
+}
+
+func route(args []string) error {
+
+	var f filter
+	fs := flag.NewFlagSet("route", flag.ExitOnError)
+	f.registerConfig(fs)
+	f.registerFormat(fs)
+	legs := fs.String("legs", "", "comma separated exchange:pair legs, e.g. kraken:XXBTZEUR,luno:XBTZAR")
+	asset := fs.String("asset", "", "asset code to start with")
+	amount := fs.Float64("amount", 0, "amount of the starting asset")
+	fs.Parse(args)
+
+	if err := checkFormat(f.format); err != nil {
+		return err
+	}
+
+	start := exchange.AssetByCode(*asset)
+	if start == nil {
+		return fmt.Errorf("unknown asset %q", *asset)
+	}
+	if *amount <= 0 {
+		return fmt.Errorf("-amount must be positive")
+	}
+
+	available, err := f.available()
+	if err != nil {
+		return err
+	}
+	r, err := buildRoute(available, *legs)
+	if err != nil {
+		return err
+	}
+	res, err := r.Simulate(start, *amount)
+	if err != nil {
+		return err
+	}
+
+	rec := &routeRecord{
+		Asset:       start.Code,
+		Amount:      *amount,
+		Result:      res.Amount,
+		ResultAsset: res.Asset.Code,
+		Description: strings.TrimSpace(res.Description),
+	}
+	t := &table{Header: []string{"STEP", "EXCHANGE", "PAIR", "SIDE", "VOLUME"}, Records: rec}
+	for i, req := range res.Requests {
+		rr := &requestRecord{
+			Exchange: req.Exchange.Meta().Slug,
+			Pair:     req.Pair.Code,
+			Side:     sideName(req.Type),
+			Volume:   req.Volume,
+		}
+		rec.Requests = append(rec.Requests, rr)
+		t.Rows = append(t.Rows, []string{strconv.Itoa(i + 1), rr.Exchange, rr.Pair, rr.Side, fixed(rr.Volume, 8)})
+	}
+	t.Rows = append(t.Rows, []string{"result", "", "", "", fixed(res.Amount, 8) + " " + res.Asset.Code})
+
+	return t.write(os.Stdout, f.format)
+}
+
+// buildRoute fetches the book for every exchange:pair leg, from the
+// available exchanges by slug.
+func buildRoute(available map[string]exchange.Exchange, legs string) (*exchange.Route, error) {
+
+	type leg struct {
+		e    exchange.Exchange
+		pair *exchange.Pair
+	}
+	var parsed []leg
+	var exchanges []exchange.Exchange
+	for _, ref := range split(legs) {
+		parts := strings.SplitN(ref, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("leg %q must be exchange:pair", ref)
+		}
+		e, ok := available[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown or disabled exchange %q", parts[0])
+		}
+		pair := exchange.FindPair(e, parts[1])
+		if pair == nil {
+			return nil, fmt.Errorf("%s has no pair %q", parts[0], parts[1])
+		}
+		parsed = append(parsed, leg{e, pair})
+		exchanges = append(exchanges, e)
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("-legs is required")
+	}
+
+	fetcher := newFetcher(newClient(exchanges))
+	r := &exchange.Route{}
+	for _, l := range parsed {
+		ob, err := fetcher.GetOrderBook(l.e, l.pair)
+		if err != nil {
+			return nil, err
+		}
+		r.Legs = append(r.Legs, &exchange.RouteLeg{Pair: l.pair, OrderBook: ob.Prepare(), Exchange: l.e})
+	}
+	return r, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+	"github.com/jacoduplessis/crypto/metrics"
+	"github.com/jacoduplessis/crypto/server"
+)
+
+func serve(args []string) error {
+
+	var f filter
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	f.registerConfig(fs)
+	fs.StringVar(&f.exchanges, "exchange", "", "comma separated exchange slugs (default all)")
+	addr := fs.String("addr", ":8080", "listen address")
+	interval := fs.Duration("interval", 0, "book refresh interval (default from config, or 10s)")
+	origins := fs.String("origins", "", "comma separated origins whose pages may open /ws, or * for any (default the server's own)")
+	discover := fs.Bool("discover", false, "fetch the pairs of Kraken, Luno and ICE from their APIs every hour")
+	fs.Parse(args)
+
+	cfg, err := f.load()
+	if err != nil {
+		return err
+	}
+	var intervals map[string]time.Duration
+	if cfg != nil {
+		intervals = cfg.Intervals()
+		if *interval == 0 {
+			*interval = cfg.DefaultInterval(0)
+		}
+	}
+	if *interval == 0 {
+		*interval = 10 * time.Second
+	}
+
+	selected, err := f.selected()
+	if err != nil {
+		return err
+	}
+	exchanges := list(selected)
+
+	registry := metrics.NewTextRegistry()
+	collector := metrics.NewCollector(registry)
+
+	transport := newTransport()
+	transport.Challenged = collector.Challenge
+
+	scheduler := exchange.NewScheduler(exchanges...)
+	client := http.Client{Transport: scheduler.Transport(transport)}
+	fetcher := newFetcher(client)
+	fetcher.Observer = collector
+	if *discover {
+		fetcher.Markets = &exchange.MarketCache{Fetcher: newFetcher(client), TTL: time.Hour}
+	}
+	cache := &server.Cache{Fetcher: fetcher, Exchanges: exchanges, Interval: *interval, Intervals: intervals}
+	go cache.Run(context.Background())
+
+	s := &server.Server{Cache: cache, Metrics: registry, Scheduler: scheduler, Origins: split(*origins)}
+	log.Printf("serving on %s", *addr)
+	return http.ListenAndServe(*addr, s.Handler())
+}
@@ -0,0 +1,464 @@
+// Package config loads a declarative description of the exchanges to use
+// from a JSON or YAML file:
+//
+//	{
+//	  "interval": "10s",
+//	  "exchanges": {
+//	    "luno": {
+//	      "api_key": "env:LUNO_API_KEY",
+//	      "api_secret": "env:LUNO_API_SECRET",
+//	      "pairs": ["XBTZAR"],
+//	      "fees": {"XBTZAR": {"taker": 0.001}}
+//	    },
+//	    "kraken": {"credentials": "keystore:kraken", "interval": "30s", "fees": {"*": {"taker": 0.0026}}},
+//	    "ice": {"enabled": false, "credentials": "file:/etc/crypto/ice.json"},
+//	    "alt": {"base_url": "http://localhost:8081"},
+//	    "ecb": {"spread": 0.015}
+//	  },
+//	  "keystore": "/etc/crypto/keys.json",
+//	  "keystore_passphrase": "env:CRYPTO_PASSPHRASE"
+//	}
+//
+// The same config can be written in YAML, in a file ending in .yaml or
+// .yml:
+//
+//	interval: 10s
+//	exchanges:
+//	  luno:
+//	    api_key: env:LUNO_API_KEY
+//	    api_secret: env:LUNO_API_SECRET
+//	    pairs: [XBTZAR]
+//	  kraken:
+//	    credentials: keystore:kraken
+//	    fees: {"*": {taker: 0.0026}}
+//
+// Exchanges are keyed by slug. API keys and secrets of the form env:NAME are
+// read from the environment. Alternatively credentials names a provider from
+// package credentials: file:PATH, keystore:ENTRY or env:KEYVAR,SECRETVAR.
+// Validation errors name the offending key, such as exchanges.kraken.pairs[1],
+// and so do unknown keys, such as exchanges.luno.secret. TOML is not
+// supported.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jacoduplessis/crypto/credentials"
+	"github.com/jacoduplessis/crypto/exchange"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the top level of a config file.
+type Config struct {
+	// Interval is the default refresh interval, as a Go duration.
+	Interval  string               `json:"interval"`
+	Exchanges map[string]*Exchange `json:"exchanges"`
+	// Keystore is the path of a credentials.Keystore, unlocked with
+	// KeystorePassphrase, usually an env:NAME reference.
+	Keystore           string `json:"keystore"`
+	KeystorePassphrase string `json:"keystore_passphrase"`
+
+	keystore *credentials.Keystore
+}
+
+// Exchange configures one exchange.
+type Exchange struct {
+	// Enabled defaults to true.
+	Enabled     *bool                   `json:"enabled"`
+	APIKey      string                  `json:"api_key"`
+	APISecret   string                  `json:"api_secret"`
+	Credentials string                  `json:"credentials"`
+	BaseURL     string                  `json:"base_url"`
+	Pairs       []string                `json:"pairs"`
+	Fees        map[string]exchange.Fee `json:"fees"`
+	// Spread replaces the spread of rate providers such as ecb.
+	Spread   *float64 `json:"spread"`
+	Interval string   `json:"interval"`
+}
+
+func (e *Exchange) enabled() bool {
+	return e.Enabled == nil || *e.Enabled
+}
+
+// Error is a validation error at a key of the config.
+type Error struct {
+	Key string
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Key == "" {
+		return "config: " + e.Err.Error()
+	}
+	return "config: " + e.Key + ": " + e.Err.Error()
+}
+
+func errorf(key, format string, args ...interface{}) error {
+	return &Error{Key: key, Err: fmt.Errorf(format, args...)}
+}
+
+// Load reads and validates a config file, as YAML if its name ends in .yaml
+// or .yml and as JSON otherwise.
+func Load(path string) (*Config, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(f)
+	}
+	return Parse(f)
+}
+
+// ParseYAML reads and validates a config written in YAML, with the keys of
+// the JSON form. It is converted to JSON and read by Parse, so both are
+// validated alike.
+func ParseYAML(r io.Reader) (*Config, error) {
+
+	var v interface{}
+	if err := yaml.NewDecoder(r).Decode(&v); err != nil && err != io.EOF {
+		return nil, &Error{Err: err}
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, &Error{Err: err}
+	}
+	return Parse(bytes.NewReader(b))
+}
+
+// Parse reads and validates a config. Unknown keys are errors.
+func Parse(r io.Reader) (*Config, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	c := &Config{}
+	if err := dec.Decode(c); err != nil {
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &te) {
+			return nil, errorf(te.Field, "expected %s, got %s", te.Type, te.Value)
+		}
+		// the decoder doesn't say where an unknown key is, so look for it
+		var v interface{}
+		if strings.HasPrefix(err.Error(), "json: unknown field ") && json.Unmarshal(b, &v) == nil {
+			if key := unknownKey(v, reflect.TypeOf(c), ""); key != "" {
+				return nil, errorf(key, "unknown key")
+			}
+		}
+		return nil, &Error{Err: err}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// unknownKey returns the path of the first key in v, a config decoded into
+// generic maps, that the type t has no field for, or "" if there is none.
+// Keys are compared case-insensitively, as the decoder does.
+func unknownKey(v interface{}, t reflect.Type, key string) string {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || (t.Kind() != reflect.Struct && t.Kind() != reflect.Map) {
+		return ""
+	}
+
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		path := k
+		if key != "" {
+			path = key + "." + k
+		}
+		if t.Kind() == reflect.Map {
+			if found := unknownKey(m[k], t.Elem(), path); found != "" {
+				return found
+			}
+			continue
+		}
+		field, ok := fieldByTag(t, k)
+		if !ok {
+			return path
+		}
+		if found := unknownKey(m[k], field.Type, path); found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+// fieldByTag returns the field of struct type t whose JSON name is name,
+// ignoring case.
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag != "" && tag != "-" && strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Validate checks the config against the registered exchanges and the
+// environment. The first problem found is returned as an *Error.
+func (c *Config) Validate() error {
+
+	if err := validateInterval("interval", c.Interval); err != nil {
+		return err
+	}
+
+	for _, slug := range c.slugs() {
+		ec := c.Exchanges[slug]
+		key := "exchanges." + slug
+		if ec == nil {
+			return errorf(key, "must be an object")
+		}
+
+		// pairs such as fnb's notes pairs are only listed when named
+		e, err := exchange.New(slug, exchange.Options{Pairs: ec.Pairs})
+		if err != nil {
+			return errorf(key, "unknown exchange, expected one of %s", strings.Join(exchange.Slugs(), ", "))
+		}
+		if err := validateInterval(key+".interval", ec.Interval); err != nil {
+			return err
+		}
+		if ec.BaseURL != "" {
+			u, err := url.Parse(ec.BaseURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errorf(key+".base_url", "%q is not an http(s) URL", ec.BaseURL)
+			}
+		}
+		for i, code := range ec.Pairs {
+			if exchange.FindPair(e, code) == nil {
+				return errorf(fmt.Sprintf("%s.pairs[%d]", key, i), "%s has no pair %q", slug, code)
+			}
+		}
+		for code, fee := range ec.Fees {
+			fkey := key + ".fees." + code
+			if code != "*" && exchange.FindPair(e, code) == nil {
+				return errorf(fkey, "%s has no pair %q", slug, code)
+			}
+			if fee.Taker != nil && (*fee.Taker < 0 || *fee.Taker >= 1) {
+				return errorf(fkey+".taker", "fee must be a fraction between 0 and 1")
+			}
+			if fee.Maker != nil && (*fee.Maker <= -1 || *fee.Maker >= 1) {
+				return errorf(fkey+".maker", "fee must be a fraction between -1 and 1")
+			}
+		}
+		if ec.Spread != nil && (*ec.Spread < 0 || *ec.Spread >= 1) {
+			return errorf(key+".spread", "spread must be a fraction between 0 and 1")
+		}
+		if !ec.enabled() {
+			continue
+		}
+		if ec.Credentials != "" {
+			if ec.APIKey != "" || ec.APISecret != "" {
+				return errorf(key+".credentials", "cannot be combined with api_key and api_secret")
+			}
+			if _, err := c.provider(key+".credentials", ec.Credentials, false); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := resolve(key+".api_key", ec.APIKey); err != nil {
+			return err
+		}
+		if _, err := resolve(key+".api_secret", ec.APISecret); err != nil {
+			return err
+		}
+	}
+	if c.Keystore != "" {
+		if _, err := resolve("keystore_passphrase", c.KeystorePassphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// provider returns the credentials provider named by ref. The keystore is
+// only opened if open is set.
+func (c *Config) provider(key, ref string, open bool) (credentials.Provider, error) {
+
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errorf(key, "%q must be file:PATH, keystore:ENTRY or env:KEYVAR,SECRETVAR", ref)
+	}
+
+	switch parts[0] {
+	case "file":
+		p := &credentials.File{Path: parts[1]}
+		if _, err := p.Credentials(); err != nil {
+			return nil, &Error{Key: key, Err: err}
+		}
+		return p, nil
+	case "env":
+		vars := strings.Split(parts[1], ",")
+		if len(vars) != 2 {
+			return nil, errorf(key, "%q must name a key and a secret variable", ref)
+		}
+		p := credentials.Env{Key: vars[0], Secret: vars[1]}
+		if _, err := p.Credentials(); err != nil {
+			return nil, &Error{Key: key, Err: err}
+		}
+		return p, nil
+	case "keystore":
+		if c.Keystore == "" {
+			return nil, errorf(key, "keystore is not configured")
+		}
+		if !open {
+			return nil, nil
+		}
+		if c.keystore == nil {
+			passphrase, err := resolve("keystore_passphrase", c.KeystorePassphrase)
+			if err != nil {
+				return nil, err
+			}
+			ks, err := credentials.OpenKeystore(c.Keystore, []byte(passphrase))
+			if err != nil {
+				return nil, &Error{Key: "keystore", Err: err}
+			}
+			c.keystore = ks
+		}
+		p := c.keystore.Provider(parts[1])
+		if _, err := p.Credentials(); err != nil {
+			return nil, &Error{Key: key, Err: err}
+		}
+		return p, nil
+	}
+	return nil, errorf(key, "unknown credentials provider %q", parts[0])
+}
+
+// OpenedKeystore returns the keystore once Build has opened it, so that its
+// entries can be rotated at runtime.
+func (c *Config) OpenedKeystore() *credentials.Keystore {
+	return c.keystore
+}
+
+func validateInterval(key, s string) error {
+
+	if s == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return errorf(key, "%q is not a duration", s)
+	}
+	if d <= 0 {
+		return errorf(key, "must be positive")
+	}
+	return nil
+}
+
+// resolve returns a credential, reading env:NAME references from the
+// environment.
+func resolve(key, v string) (string, error) {
+
+	if !strings.HasPrefix(v, "env:") {
+		return v, nil
+	}
+	name := strings.TrimPrefix(v, "env:")
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errorf(key, "environment variable %s is not set", name)
+	}
+	return s, nil
+}
+
+func (c *Config) slugs() []string {
+
+	var slugs []string
+	for slug := range c.Exchanges {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+// Build creates the enabled exchanges, sorted by slug.
+func (c *Config) Build() ([]exchange.Exchange, error) {
+
+	var exchanges []exchange.Exchange
+	for _, slug := range c.slugs() {
+		ec := c.Exchanges[slug]
+		if !ec.enabled() {
+			continue
+		}
+		key := "exchanges." + slug
+		o := exchange.Options{BaseURL: ec.BaseURL, Pairs: ec.Pairs, Fees: ec.Fees, Spread: ec.Spread}
+		if ec.Credentials != "" {
+			p, err := c.provider(key+".credentials", ec.Credentials, true)
+			if err != nil {
+				return nil, err
+			}
+			o.Credentials = p
+		} else {
+			apiKey, err := resolve(key+".api_key", ec.APIKey)
+			if err != nil {
+				return nil, err
+			}
+			apiSecret, err := resolve(key+".api_secret", ec.APISecret)
+			if err != nil {
+				return nil, err
+			}
+			o.APIKey, o.APISecret = credentials.Secret(apiKey), credentials.Secret(apiSecret)
+		}
+		e, err := exchange.New(slug, o)
+		if err != nil {
+			return nil, errorf(key, "%v", err)
+		}
+		exchanges = append(exchanges, e)
+	}
+	return exchanges, nil
+}
+
+// DefaultInterval returns the top level interval, or def if it isn't set.
+func (c *Config) DefaultInterval(def time.Duration) time.Duration {
+
+	if d, err := time.ParseDuration(c.Interval); err == nil {
+		return d
+	}
+	return def
+}
+
+// Intervals returns the interval overrides of enabled exchanges by slug.
+func (c *Config) Intervals() map[string]time.Duration {
+
+	intervals := map[string]time.Duration{}
+	for slug, ec := range c.Exchanges {
+		if d, err := time.ParseDuration(ec.Interval); err == nil && ec.enabled() {
+			intervals[slug] = d
+		}
+	}
+	return intervals
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+func TestConfig(t *testing.T) {
+
+	os.Setenv("CONFIG_TEST_KEY", "key")
+	defer os.Unsetenv("CONFIG_TEST_KEY")
+
+	c, err := Parse(strings.NewReader(`{
+		"interval": "5s",
+		"exchanges": {
+			"luno": {
+				"api_key": "env:CONFIG_TEST_KEY",
+				"api_secret": "secret",
+				"base_url": "http://localhost:8081/api/1/",
+				"pairs": ["xbtzar"],
+				"fees": {"*": {"taker": 0.02}, "XBTZAR": {"maker": 0.001}}
+			},
+			"kraken": {"interval": "30s"},
+			"ice": {"enabled": false, "api_key": "env:CONFIG_TEST_UNSET"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exchanges, err := c.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 2 || exchanges[0].Meta().Slug != "kraken" || exchanges[1].Meta().Slug != "luno" {
+		t.Fatalf("Expected kraken and luno, got %v", exchanges)
+	}
+	luno := exchanges[1].(*exchange.Luno)
+	if luno.APIKey != "key" || luno.APISecret != "secret" {
+		t.Errorf("Credentials not resolved: %q %q", luno.APIKey, luno.APISecret)
+	}
+	m := luno.Meta()
+	if m.API != "http://localhost:8081/api/1/" {
+		t.Errorf("Base URL not overridden: %s", m.API)
+	}
+	if len(m.Pairs) != 1 || m.Pairs[0].TakerFee != 0.02 || m.Pairs[0].MakerFee != 0.001 {
+		t.Errorf("Pairs not filtered or fees not applied: %+v", m.Pairs)
+	}
+	if c.DefaultInterval(0) != 5*time.Second || c.Intervals()["kraken"] != 30*time.Second || len(c.Intervals()) != 1 {
+		t.Errorf("Unexpected intervals %s %v", c.DefaultInterval(0), c.Intervals())
+	}
+
+	c, err = Parse(strings.NewReader(`{"exchanges": {"ecb": {"spread": 0.015}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exchanges, err = c.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if ecb := exchanges[0].(*exchange.ECB); ecb.Spread == nil || *ecb.Spread != 0.015 {
+		t.Errorf("Spread not applied: %v", ecb.Spread)
+	}
+
+	for config, key := range map[string]string{
+		`{"interval": "soon"}`:                                                "interval",
+		`{"exchanges": {"bitstamp": {}}}`:                                     "exchanges.bitstamp",
+		`{"exchanges": {"kraken": {"pairs": ["XXBTZEUR", "DOGE"]}}}`:          "exchanges.kraken.pairs[1]",
+		`{"exchanges": {"kraken": {"fees": {"*": {"taker": 2}}}}}`:            "exchanges.kraken.fees.*.taker",
+		`{"exchanges": {"ecb": {"spread": -0.1}}}`:                            "exchanges.ecb.spread",
+		`{"exchanges": {"fnb": {"pairs": ["USDZAR-NOTES", "BTCZAR"]}}}`:       "exchanges.fnb.pairs[1]",
+		`{"exchanges": {"luno": {"api_key": "env:CONFIG_TEST_UNSET"}}}`:       "exchanges.luno.api_key",
+		`{"exchanges": {"luno": {"base_url": "localhost"}}}`:                  "exchanges.luno.base_url",
+		`{"exchanges": {"luno": {"pairs": "XBTZAR"}}}`:                        "exchanges.luno.pairs",
+		`{"exchanges": {"luno": {"secret": "x"}}}`:                            "exchanges.luno.secret",
+		`{"exchanges": {"luno": {"fees": {"*": {"taker": 0, "rebate": 0}}}}}`: "exchanges.luno.fees.*.rebate",
+		`{"exchanges": {"luno": {"API_KEY": "x"}}, "intervals": "5s"}`:        "intervals",
+	} {
+		_, err := Parse(strings.NewReader(config))
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Expected an *Error for %s, got %v", config, err)
+			continue
+		}
+		if e.Key != key {
+			t.Errorf("Expected error at %q for %s, got %v", key, config, err)
+		}
+	}
+}
+
+func TestYAML(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "crypto.yml")
+	ioutil.WriteFile(path, []byte(`
+interval: 5s
+exchanges:
+  luno:
+    api_key: key
+    pairs: [xbtzar]
+    fees: {"*": {taker: 0.02}}
+  kraken:
+    interval: 30s
+  ice:
+    enabled: false
+`), 0600)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchanges, err := c.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 2 || exchanges[1].Meta().Slug != "luno" {
+		t.Fatalf("Expected kraken and luno, got %v", exchanges)
+	}
+	if m := exchanges[1].Meta(); len(m.Pairs) != 1 || m.Pairs[0].TakerFee != 0.02 {
+		t.Errorf("Pairs not filtered or fees not applied: %+v", m.Pairs)
+	}
+	if c.DefaultInterval(0) != 5*time.Second || c.Intervals()["kraken"] != 30*time.Second {
+		t.Errorf("Unexpected intervals %s %v", c.DefaultInterval(0), c.Intervals())
+	}
+
+	for config, key := range map[string]string{
+		"exchanges:\n  kraken:\n    pairs: [XXBTZEUR, DOGE]\n": "exchanges.kraken.pairs[1]",
+		"exchanges:\n  luno:\n    secret: x\n":                 "exchanges.luno.secret",
+		"exchanges: [luno\n":                                   "",
+		"exchanges:\n  1: {}\n":                                "exchanges.1",
+	} {
+		_, err := ParseYAML(strings.NewReader(config))
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Expected an *Error for %q, got %v", config, err)
+			continue
+		}
+		if e.Key != key {
+			t.Errorf("Expected error at %q for %q, got %v", key, config, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package credentials provides API keys to exchanges without letting them
+// leak into logs.
+//
+// A Secret prints as [redacted] with every fmt verb and in JSON; convert it
+// to a string only where the value is sent. A Provider is asked for the
+// current credentials on every authenticated request, so keys can be
+// rotated at runtime: Static has a Rotate method, Env and File read their
+// source again, and a Keystore entry can be replaced with Set.
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const redacted = "[redacted]"
+
+// Secret is a string that is never printed.
+type Secret string
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `credentials.Secret("` + redacted + `")`
+}
+
+// Format redacts s for every verb, including %x and %d.
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('#') {
+		io.WriteString(f, s.GoString())
+		return
+	}
+	io.WriteString(f, redacted)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+// Credentials are an API key and secret.
+type Credentials struct {
+	Key    Secret
+	Secret Secret
+}
+
+// Provider returns the current credentials. Implementations must be safe for
+// concurrent use.
+type Provider interface {
+	Credentials() (Credentials, error)
+}
+
+// Static holds credentials in memory.
+type Static struct {
+	mu sync.RWMutex
+	c  Credentials
+}
+
+// NewStatic returns a provider of the given key and secret.
+func NewStatic(key, secret Secret) *Static {
+	return &Static{c: Credentials{key, secret}}
+}
+
+func (s *Static) Credentials() (Credentials, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.c, nil
+}
+
+func (s *Static) String() string {
+	return "credentials.Static{" + redacted + "}"
+}
+
+func (s *Static) GoString() string {
+	return s.String()
+}
+
+// Rotate replaces the credentials.
+func (s *Static) Rotate(key, secret Secret) {
+	s.mu.Lock()
+	s.c = Credentials{key, secret}
+	s.mu.Unlock()
+}
+
+// Env reads credentials from environment variables on every call.
+type Env struct {
+	Key    string
+	Secret string
+}
+
+func (e Env) Credentials() (Credentials, error) {
+
+	key, ok := os.LookupEnv(e.Key)
+	if !ok {
+		return Credentials{}, fmt.Errorf("Environment variable %s is not set", e.Key)
+	}
+	secret, ok := os.LookupEnv(e.Secret)
+	if !ok {
+		return Credentials{}, fmt.Errorf("Environment variable %s is not set", e.Secret)
+	}
+	return Credentials{Secret(key), Secret(secret)}, nil
+}
+
+// File reads credentials from a JSON file of the form
+// {"key": "...", "secret": "..."}. The file must not be accessible to group
+// or others. It is read again whenever its modification time changes.
+type File struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	c       Credentials
+}
+
+func (f *File) String() string {
+	return "credentials.File{" + f.Path + "}"
+}
+
+func (f *File) GoString() string {
+	return f.String()
+}
+
+func (f *File) Credentials() (Credentials, error) {
+
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return Credentials{}, fmt.Errorf("%s is accessible to other users (mode %s), expected 0600", f.Path, fi.Mode().Perm())
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fi.ModTime().Equal(f.modTime) {
+		return f.c, nil
+	}
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var d struct {
+		Key    string
+		Secret string
+	}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return Credentials{}, fmt.Errorf("%s: %v", f.Path, err)
+	}
+	f.c = Credentials{Secret(d.Key), Secret(d.Secret)}
+	f.modTime = fi.ModTime()
+	return f.c, nil
+}
@@ -0,0 +1,137 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+
+	c := Credentials{Key: "public-key", Secret: "private-secret"}
+	out := []string{
+		fmt.Sprint(c),
+		fmt.Sprintf("%v %+v %#v %s %q %x %d", c, c, c, c.Secret, c.Secret, c.Secret, c.Secret),
+		fmt.Sprintf("%+v %#v", NewStatic("public-key", "private-secret"), NewStatic("public-key", "private-secret")),
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = append(out, string(b))
+
+	for _, s := range out {
+		if strings.Contains(s, "public-key") || strings.Contains(s, "private-secret") {
+			t.Errorf("Secret leaked in %s", s)
+		}
+		if !strings.Contains(s, redacted) {
+			t.Errorf("Expected %s in %s", redacted, s)
+		}
+	}
+	if string(c.Secret) != "private-secret" {
+		t.Error("Expected the value to be available as a string")
+	}
+}
+
+func TestFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "luno.json")
+	if err := ioutil.WriteFile(path, []byte(`{"key": "k", "secret": "s"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := &File{Path: path}
+	if _, err := f.Credentials(); err == nil {
+		t.Error("Expected a world readable file to be refused")
+	}
+
+	os.Chmod(path, 0600)
+	c, err := f.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Key != "k" || c.Secret != "s" {
+		t.Errorf("Unexpected credentials %q %q", string(c.Key), string(c.Secret))
+	}
+}
+
+func TestKeystore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// keystores written before the key derivation moved to x/crypto open
+	old := filepath.Join(dir, "old.json")
+	ioutil.WriteFile(old, []byte(`{"version":1,"kdf":"pbkdf2-sha256","iterations":600000,"salt":"MDEyMzQ1Njc4OWFiY2RlZg==",`+
+		`"nonce":"MDEyMzQ1Njc4OWFi","data":"lXlC5cWEW5uBOAKP5T4uYF3bKyVY74+3Q5eoUkp0pEWTHX1STHftAMsJaB+OwBt4GA=="}`), 0600)
+	ks, err := OpenKeystore(old, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := ks.Provider("luno").Credentials(); err != nil || c.Key != "k" || c.Secret != "s" {
+		t.Errorf("Unexpected credentials %v, %v", c, err)
+	}
+
+	// a file can't weaken the key derivation or ask for endless work
+	for _, c := range []struct {
+		header string
+		err    string
+	}{
+		{`"version":1,"kdf":"pbkdf2-sha256","iterations":2`, "iteration count 2 outside"},
+		{`"version":1,"kdf":"pbkdf2-sha256","iterations":1000000000`, "iteration count 1000000000 outside"},
+		{`"version":1,"kdf":"pbkdf2-sha1","iterations":600000`, `unsupported key derivation "pbkdf2-sha1"`},
+		{`"version":2,"kdf":"pbkdf2-sha256","iterations":600000`, "unsupported keystore version 2"},
+	} {
+		path := filepath.Join(dir, "tampered.json")
+		ioutil.WriteFile(path, []byte(`{`+c.header+`,"salt":"MDEyMzQ1Njc4OWFiY2RlZg==","nonce":"MDEyMzQ1Njc4OWFi","data":""}`), 0600)
+		if _, err := OpenKeystore(path, []byte("passphrase")); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, want an error containing %q", c.header, err, c.err)
+		}
+	}
+
+	path := filepath.Join(dir, "keys.json")
+	ks = CreateKeystore(path, []byte("correct horse"))
+	ks.Set("kraken", Credentials{"k1", "s1"})
+	if err := ks.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadFile(path); strings.Contains(string(b), `"s1"`) {
+		t.Error("Secret stored in plain text")
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v %v", fi.Mode(), err)
+	}
+
+	if _, err := OpenKeystore(path, []byte("wrong")); err == nil {
+		t.Error("Expected the wrong passphrase to fail")
+	}
+	ks, err = OpenKeystore(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := ks.Provider("kraken")
+	if c, err := p.Credentials(); err != nil || c.Key != "k1" {
+		t.Errorf("Unexpected credentials %v %v", c, err)
+	}
+
+	ks.Set("kraken", Credentials{"k2", "s2"})
+	if c, _ := p.Credentials(); string(c.Secret) != "s2" {
+		t.Error("Expected the provider to see the rotated secret")
+	}
+	if _, err := ks.Provider("luno").Credentials(); err == nil {
+		t.Error("Expected a missing entry to fail")
+	}
+}
@@ -0,0 +1,207 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// keystoreIterations is the PBKDF2-SHA256 work factor for new keystores.
+// Every keystore has been written with it, so fewer iterations in a file
+// mean it was tampered with to weaken the key.
+const keystoreIterations = 600000
+
+// maxKeystoreIterations bounds the work a keystore file can ask for, about
+// a minute of key derivation.
+const maxKeystoreIterations = 100 * keystoreIterations
+
+// keystoreKDF is the only key derivation keystores use.
+const keystoreKDF = "pbkdf2-sha256"
+
+// keystoreFile is the on-disk format. The plaintext of Data is a JSON map
+// of entry name to key and secret.
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+type keystoreEntry struct {
+	Key    string `json:"key"`
+	Secret string `json:"secret"`
+}
+
+// Keystore is a file of named credentials encrypted with AES-256-GCM under
+// a key derived from a passphrase.
+type Keystore struct {
+	path       string
+	passphrase []byte
+
+	mu      sync.RWMutex
+	entries map[string]keystoreEntry
+}
+
+// CreateKeystore returns an empty keystore that will be written to path by
+// Save. An existing file is only replaced on Save.
+func CreateKeystore(path string, passphrase []byte) *Keystore {
+	return &Keystore{path: path, passphrase: passphrase, entries: map[string]keystoreEntry{}}
+}
+
+// OpenKeystore decrypts the keystore at path.
+func OpenKeystore(path string, passphrase []byte) (*Keystore, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keystoreFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if f.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported keystore version %d", path, f.Version)
+	}
+	if f.KDF != keystoreKDF {
+		return nil, fmt.Errorf("%s: unsupported key derivation %q", path, f.KDF)
+	}
+
+	gcm, err := newGCM(passphrase, f.Salt, f.Iterations)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	plain, err := gcm.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: wrong passphrase or corrupt keystore", path)
+	}
+
+	ks := &Keystore{path: path, passphrase: passphrase}
+	if err := json.Unmarshal(plain, &ks.entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return ks, nil
+}
+
+func (ks *Keystore) String() string {
+	return "credentials.Keystore{" + ks.path + "}"
+}
+
+func (ks *Keystore) GoString() string {
+	return ks.String()
+}
+
+// Names returns the names of all entries, sorted.
+func (ks *Keystore) Names() []string {
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var names []string
+	for name := range ks.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set adds or replaces an entry. Providers of the entry see the new
+// credentials immediately; call Save to persist them.
+func (ks *Keystore) Set(name string, c Credentials) {
+	ks.mu.Lock()
+	ks.entries[name] = keystoreEntry{string(c.Key), string(c.Secret)}
+	ks.mu.Unlock()
+}
+
+// Delete removes an entry.
+func (ks *Keystore) Delete(name string) {
+	ks.mu.Lock()
+	delete(ks.entries, name)
+	ks.mu.Unlock()
+}
+
+// Provider returns a provider of the named entry.
+func (ks *Keystore) Provider(name string) Provider {
+	return keystoreProvider{ks, name}
+}
+
+type keystoreProvider struct {
+	ks   *Keystore
+	name string
+}
+
+func (p keystoreProvider) String() string {
+	return "credentials.Keystore{" + p.ks.path + "}[" + p.name + "]"
+}
+
+func (p keystoreProvider) Credentials() (Credentials, error) {
+
+	p.ks.mu.RLock()
+	defer p.ks.mu.RUnlock()
+
+	e, ok := p.ks.entries[p.name]
+	if !ok {
+		return Credentials{}, fmt.Errorf("No entry %q in keystore %s", p.name, p.ks.path)
+	}
+	return Credentials{Secret(e.Key), Secret(e.Secret)}, nil
+}
+
+// Save encrypts the keystore with a fresh salt and nonce and writes it,
+// readable only by the owner.
+func (ks *Keystore) Save() error {
+
+	ks.mu.RLock()
+	plain, err := json.Marshal(ks.entries)
+	ks.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	f := keystoreFile{Version: 1, KDF: keystoreKDF, Iterations: keystoreIterations, Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(ks.passphrase, f.Salt, f.Iterations)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Data = gcm.Seal(nil, f.Nonce, plain, nil)
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a failed save keeps the old one
+	tmp := ks.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+func newGCM(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+
+	if iterations < keystoreIterations || iterations > maxKeystoreIterations {
+		return nil, fmt.Errorf("Keystore iteration count %d outside %d to %d", iterations, keystoreIterations, maxKeystoreIterations)
+	}
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
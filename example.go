@@ -25,7 +25,15 @@ func main() {
 	}
 
 	for _, ob := range books {
-		fmt.Println(ob.Exchange.Meta().Name, ob.Pair.Code, len(ob.Bids), ob.Bids[:1], len(ob.Asks), ob.Asks[:1])
+		fmt.Println(ob.Exchange.Meta().Name, ob.Pair.Code, len(ob.Bids), top(ob.Bids), len(ob.Asks), top(ob.Asks))
 	}
 
 }
+
+// top returns the best level, or nothing for an empty side.
+func top(entries [][2]float64) [][2]float64 {
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[:1]
+}
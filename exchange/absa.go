@@ -0,0 +1,91 @@
+package exchange
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/jacoduplessis/crypto/scraper"
+)
+
+// Absa quotes the bank's rand transfer rates, read from the table on its
+// exchange rates page with a BankTable. The table's selector and columns
+// haven't been checked against the live page, so Absa is not registered:
+// create it directly, and expect parse errors if the page differs.
+type Absa struct {
+	Endpoint
+}
+
+// AbsaFee is the default taker fee of Absa's pairs, an estimate of the
+// commission the bank charges on the amount exchanged. Its minimum and
+// maximum charges are not modelled.
+const AbsaFee = 0.0055
+
+// absaCurrencies are the currencies of the pairs listed.
+var absaCurrencies = []string{"USD", "EUR", "GBP", "AUD", "CAD", "CHF", "JPY"}
+
+func (absa *Absa) Meta() *Meta {
+
+	var pairs []*Pair
+	for _, code := range absaCurrencies {
+		p := fxPair(Currency(code), Rand)
+		p.TakerFee = AbsaFee
+		pairs = append(pairs, p)
+	}
+	return &Meta{
+		Name:      "Absa",
+		Slug:      "absa",
+		API:       absa.api("https://www.absa.co.za/"),
+		Pairs:     absa.pairs(pairs),
+		RateLimit: &RateLimit{Burst: 2, Rate: 1},
+	}
+}
+
+// absaLocale is how Absa prints rates.
+var absaLocale = scraper.Locale{Decimal: ".", Thousands: ",", Prefixes: []string{"R"}}
+
+// table describes the rates table, which lists the currency name and code
+// and then the bank's buying and selling rates.
+func (absa *Absa) table() *BankTable {
+	return &BankTable{
+		URL:    Build(absa, "rates-and-fees/exchange-rates", nil),
+		Quote:  Rand,
+		Table:  "table.exchange-rates",
+		Code:   1,
+		Bid:    2,
+		Ask:    3,
+		Locale: absaLocale,
+	}
+}
+
+func (absa *Absa) GetRatesRequest() (*http.Request, error) {
+	return absa.table().GetRatesRequest()
+}
+
+func (absa *Absa) ParseRatesResponse(body io.Reader) ([]*Rate, error) {
+	return absa.table().ParseRatesResponse(body)
+}
+
+func (absa *Absa) GetOrderBookRequest(string) (*http.Request, error) {
+	return absa.GetRatesRequest()
+}
+
+// ParseOrderBookResponse returns the USDZAR book.
+func (absa *Absa) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
+	return absa.ParsePairOrderBookResponse(body, fxPair(Currency("USD"), Rand))
+}
+
+// ParsePairOrderBookResponse returns the book of pair. The bank's rates
+// already have a spread, so none is added unless Options.Spread is set.
+func (absa *Absa) ParsePairOrderBookResponse(body io.Reader, pair *Pair) (*OrderBook, error) {
+	return fxOrderBook(absa, body, pair, Transfer, absa.spread(0))
+}
+
+func (absa *Absa) GetOrderBooksRequest([]*Pair) (*http.Request, error) {
+	return absa.GetRatesRequest()
+}
+
+// ParseOrderBooksResponse returns the books of pairs from one reading of
+// the page.
+func (absa *Absa) ParseOrderBooksResponse(body io.Reader, pairs []*Pair) ([]*OrderBook, error) {
+	return fxOrderBooks(absa, body, pairs, func(*Pair) Channel { return Transfer }, absa.spread(0))
+}
@@ -3,13 +3,19 @@ package exchange
 import (
 	"io"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/jacoduplessis/crypto/scraper"
 )
 
 type AltCoinTrader struct {
+	Endpoint
+}
+
+func init() {
+	Register(func(o Options) Exchange {
+		return &AltCoinTrader{o.endpoint()}
+	})
 }
 
 func (alt *AltCoinTrader) Meta() *Meta {
@@ -17,11 +23,11 @@ func (alt *AltCoinTrader) Meta() *Meta {
 	return &Meta{
 		Name: "AltCoinTrader",
 		Slug: "alt",
-		API:  "https://www.altcointrader.co.za",
-		Pairs: []*Pair{
+		API:  alt.api("https://www.altcointrader.co.za"),
+		Pairs: alt.pairs([]*Pair{
 			{Base: Bitcoin, Quote: Rand, Code: "/"},
 			{Base: Ripple, Quote: Rand, Code: "/xrp"},
-		},
+		}),
 	}
 }
 
@@ -31,53 +37,64 @@ func (alt *AltCoinTrader) GetOrderBookRequest(pairCode string) (*http.Request, e
 	return http.NewRequest("GET", u, nil)
 }
 
+// altLocale is how AltCoinTrader prints prices and volumes.
+var altLocale = scraper.Locale{Decimal: ".", Thousands: ",", Prefixes: []string{"R"}}
+
+// altSpec reads one side of the order book, whose rows and cells are told
+// apart by class: orderUdSell rows with orderUdSPr and orderUdSAm cells
+// for asks, and orderUdBuy rows with orderUdBPr and orderUdBAm for bids.
+// Either side may be empty; ParseOrderBookResponse fails if both are.
+func altSpec(side, row, price, volume string) *scraper.Spec {
+
+	return &scraper.Spec{
+		Name: "alt " + side,
+		Rows: "tr." + row,
+		Fields: []scraper.Field{
+			{Name: "price", Selector: "." + price, Number: true, Positive: true},
+			{Name: "volume", Selector: "." + volume, Number: true, Positive: true},
+		},
+		Locale:  altLocale,
+		MinRows: 0,
+		MaxRows: 500,
+	}
+}
+
+var (
+	altAsks = altSpec("asks", "orderUdSell", "orderUdSPr", "orderUdSAm")
+	altBids = altSpec("bids", "orderUdBuy", "orderUdBPr", "orderUdBAm")
+)
+
 func (alt *AltCoinTrader) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
 
 	doc, err := goquery.NewDocumentFromReader(body)
-
 	if err != nil {
 		return nil, err
 	}
 
-	var bids [][2]float64
-	var asks [][2]float64
-
-	doc.Find("tr.orderUdSell").Each(func(i int, s *goquery.Selection) {
-		priceString := strings.TrimSpace(s.Find(".orderUdSPr").Text())
-		volumeString := strings.TrimSpace(s.Find(".orderUdSAm").Text())
-
-		price, err := strconv.ParseFloat(priceString, 64)
-		if err != nil {
-			return
-		}
-		volume, err := strconv.ParseFloat(volumeString, 64)
-		if err != nil {
-			return
-		}
-
-		asks = append(asks, [2]float64{price, volume})
-
-	})
-
-	doc.Find("tr.orderUdBuy").Each(func(i int, s *goquery.Selection) {
-		priceString := strings.TrimSpace(s.Find(".orderUdBPr").Text())
-		volumeString := strings.TrimSpace(s.Find(".orderUdBAm").Text())
-
-		price, err := strconv.ParseFloat(priceString, 64)
-		if err != nil {
-			return
-		}
-		volume, err := strconv.ParseFloat(volumeString, 64)
-		if err != nil {
-			return
-		}
-
-		bids = append(bids, [2]float64{price, volume})
-
-	})
+	asks, err := altAsks.Scrape(doc.Selection)
+	if err != nil {
+		return nil, err
+	}
+	bids, err := altBids.Scrape(doc.Selection)
+	if err != nil {
+		return nil, err
+	}
+	// a one-sided book happens, an empty one means the layout changed
+	if len(asks) == 0 && len(bids) == 0 {
+		return nil, &scraper.CountError{Spec: "alt orders", Count: 0, Min: 1}
+	}
 
 	return &OrderBook{
-		Bids: bids,
-		Asks: asks,
+		Bids: altLevels(bids),
+		Asks: altLevels(asks),
 	}, nil
 }
+
+func altLevels(rows []*scraper.Row) [][2]float64 {
+
+	levels := make([][2]float64, len(rows))
+	for i, r := range rows {
+		levels[i] = [2]float64{r.Float("price"), r.Float("volume")}
+	}
+	return levels
+}
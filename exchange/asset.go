@@ -1,5 +1,13 @@
 package exchange
 
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
 type Pair struct {
 	Base          *Asset
 	Quote         *Asset
@@ -9,21 +17,44 @@ type Pair struct {
 	MakerFee      float64
 }
 
+// Asset is a currency. Assets are compared by pointer, so each asset has a
+// single canonical value, registered with RegisterAsset.
 type Asset struct {
 	Slug   string
 	Name   string
 	Code   string
 	Symbol string
+	// Decimals is the precision amounts of the asset are kept in.
+	Decimals int
+	// Networks are the chains the asset can be moved on, if it is crypto.
+	Networks []*Network
+	Fiat     bool
+}
+
+// Network is a chain or transfer network an asset can be deposited and
+// withdrawn on. The same asset on different networks, such as a token on
+// two chains, is one Asset with several Networks.
+type Network struct {
+	Slug string
+	Name string
+	// Tag names the memo that must accompany deposits to a shared address,
+	// such as XRP's destination tag, or is empty.
+	Tag string
 }
 
-var Bitcoin = &Asset{"bitcoin", "Bitcoin", "xbt", "฿"}
-var Ether = &Asset{"ether", "Ether", "eth", "Ξ"}
-var Litecoin = &Asset{"litecoin", "Litecoin", "ltc", "Ł"}
-var Bitcoincash = &Asset{"bitcoincash", "BitcoinCash", "bch", "฿"}
-var Ripple = &Asset{"ripple", "Ripple", "xrp", "Ʀ"}
+var Bitcoin = &Asset{Slug: "bitcoin", Name: "Bitcoin", Code: "xbt", Symbol: "฿", Decimals: 8,
+	Networks: []*Network{{Slug: "bitcoin", Name: "Bitcoin"}}}
+var Ether = &Asset{Slug: "ether", Name: "Ether", Code: "eth", Symbol: "Ξ", Decimals: 18,
+	Networks: []*Network{{Slug: "ethereum", Name: "Ethereum"}}}
+var Litecoin = &Asset{Slug: "litecoin", Name: "Litecoin", Code: "ltc", Symbol: "Ł", Decimals: 8,
+	Networks: []*Network{{Slug: "litecoin", Name: "Litecoin"}}}
+var Bitcoincash = &Asset{Slug: "bitcoincash", Name: "BitcoinCash", Code: "bch", Symbol: "฿", Decimals: 8,
+	Networks: []*Network{{Slug: "bitcoincash", Name: "Bitcoin Cash"}}}
+var Ripple = &Asset{Slug: "ripple", Name: "Ripple", Code: "xrp", Symbol: "Ʀ", Decimals: 6,
+	Networks: []*Network{{Slug: "ripple", Name: "XRP Ledger", Tag: "destination tag"}}}
 
-var Euro = &Asset{"euro", "Euro", "eur", "€"}
-var Rand = &Asset{"rand", "Rand", "zar", "R"}
+var Euro = &Asset{Slug: "euro", Name: "Euro", Code: "eur", Symbol: "€", Decimals: 2, Fiat: true}
+var Rand = &Asset{Slug: "rand", Name: "Rand", Code: "zar", Symbol: "R", Decimals: 2, Fiat: true}
 
 func GetAllCrypto() []*Asset {
 
@@ -42,3 +73,123 @@ func GetAllFiat() []*Asset {
 		Rand,
 	}
 }
+
+// Network returns the network of a with the given slug, or nil.
+func (a *Asset) Network(slug string) *Network {
+
+	for _, n := range a.Networks {
+		if n.Slug == slug {
+			return n
+		}
+	}
+	return nil
+}
+
+// Round rounds v to the precision of a.
+func (a *Asset) Round(v float64) float64 {
+
+	p := math.Pow10(a.Decimals)
+	return math.Round(v*p) / p
+}
+
+var (
+	assetsMu sync.RWMutex
+	assets   = map[string]*Asset{}
+	// aliases maps venue, usually an exchange slug, to upper case symbol to
+	// asset. The empty venue holds the codes and aliases used everywhere.
+	aliases = map[string]map[string]*Asset{}
+)
+
+// builtins registers the assets above and the ISO currencies while package
+// variables are initialised, before any init function, since exchanges look
+// up currencies as they register.
+var builtins = registerBuiltins()
+
+func registerBuiltins() bool {
+
+	for _, a := range append(append(GetAllCrypto(), GetAllFiat()...), currencies()...) {
+		if err := RegisterAsset(a); err != nil {
+			panic(err)
+		}
+	}
+	RegisterAlias("", "BTC", Bitcoin)
+	return true
+}
+
+// RegisterAsset adds an asset to the registry under its slug and code. It
+// fails if either is taken.
+func RegisterAsset(a *Asset) error {
+
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+
+	if _, ok := assets[a.Slug]; ok {
+		return fmt.Errorf("Asset %q is already registered", a.Slug)
+	}
+	if b := aliases[""][strings.ToUpper(a.Code)]; b != nil {
+		return fmt.Errorf("Code %q is already used by %s", a.Code, b.Slug)
+	}
+	assets[a.Slug] = a
+	registerAlias("", a.Code, a)
+	return nil
+}
+
+// RegisterAlias makes symbol refer to a on a venue, such as an exchange
+// slug, or on every venue if venue is empty. Symbols ignore case.
+func RegisterAlias(venue, symbol string, a *Asset) {
+
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+	registerAlias(venue, symbol, a)
+}
+
+func registerAlias(venue, symbol string, a *Asset) {
+
+	if aliases[venue] == nil {
+		aliases[venue] = map[string]*Asset{}
+	}
+	aliases[venue][strings.ToUpper(symbol)] = a
+}
+
+// Assets returns every registered asset, sorted by slug.
+func Assets() []*Asset {
+
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+
+	var all []*Asset
+	for _, a := range assets {
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Slug < all[j].Slug })
+	return all
+}
+
+// AssetBySlug returns the registered asset with the given slug, or nil.
+func AssetBySlug(slug string) *Asset {
+
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	return assets[slug]
+}
+
+// LookupAsset returns the asset a venue calls symbol: the venue's aliases
+// are tried first, then the codes and aliases used everywhere. It returns
+// nil for unknown symbols.
+func LookupAsset(venue, symbol string) *Asset {
+
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+
+	symbol = strings.ToUpper(symbol)
+	if a := aliases[venue][symbol]; a != nil {
+		return a
+	}
+	return aliases[""][symbol]
+}
+
+// AssetByCode returns the known asset with the given code or common alias,
+// ignoring case, or nil.
+func AssetByCode(code string) *Asset {
+	return LookupAsset("", code)
+}
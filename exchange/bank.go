@@ -0,0 +1,122 @@
+package exchange
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jacoduplessis/crypto/scraper"
+)
+
+// BankTable reads rates from a bank's published HTML rate table, one
+// currency per row. Banks lay out their pages differently, so the table and
+// columns are configured; wrap it in an FX to use it as an exchange.
+//
+//	bank := &exchange.FX{
+//		Name: "Bank", Slug: "bank", API: "https://bank.example/",
+//		Provider: &exchange.BankTable{
+//			URL:   "https://bank.example/forex",
+//			Quote: exchange.Rand,
+//			Table: "table.rates",
+//			Code:  0, Bid: 2, Ask: 3,
+//		},
+//		Pairs:  []*exchange.Pair{{Base: exchange.Currency("USD"), Quote: exchange.Rand, Code: "USDZAR", TakerFee: 0.0055}},
+//		Spread: 0.01,
+//	}
+type BankTable struct {
+	URL string
+	// Quote is the currency the rates are in.
+	Quote *Asset
+	// Table selects the table, the first one if empty.
+	Table string
+	// Code, Bid and Ask are the zero based columns of the currency code,
+	// the bank's buying rate and its selling rate.
+	Code, Bid, Ask int
+	// Locale is how the page prints rates.
+	Locale scraper.Locale
+	// Channel is what the rates apply to, Transfer if empty.
+	Channel Channel
+}
+
+func (b *BankTable) GetRatesRequest() (*http.Request, error) {
+	return http.NewRequest("GET", b.URL, nil)
+}
+
+// ParseRatesResponse reads every row with a known currency code, skipping
+// headings and notes.
+func (b *BankTable) ParseRatesResponse(body io.Reader) ([]*Rate, error) {
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, err
+	}
+	table := doc.Find("table").First()
+	if b.Table != "" {
+		table = doc.Find(b.Table).First()
+	}
+	ch := b.Channel
+	if ch == "" {
+		ch = Transfer
+	}
+
+	spec := rateSpec(b.URL, b.Locale, b.Code, []rateColumn{{"bid", b.Bid, false}, {"ask", b.Ask, false}})
+	rows, err := spec.Scrape(table)
+	if err != nil {
+		return nil, err
+	}
+	var rates []*Rate
+	now := time.Now()
+	for _, row := range rows {
+		if r, ok := rowRate(row, b.Quote, ch, "bid", "ask", now); ok {
+			rates = append(rates, r)
+		}
+	}
+	return rates, nil
+}
+
+// currencyCode matches the code column of rate tables, leaving out
+// headings and notes.
+var currencyCode = regexp.MustCompile(`^[A-Za-z]{3}$`)
+
+type rateColumn struct {
+	name     string
+	column   int
+	optional bool
+}
+
+// rateSpec reads a table with a currency per row. Columns are zero based.
+func rateSpec(name string, locale scraper.Locale, code int, columns []rateColumn) *scraper.Spec {
+
+	s := &scraper.Spec{
+		Name:    name,
+		Rows:    "tr",
+		Locale:  locale,
+		Fields:  []scraper.Field{{Name: "code", Selector: nthCell(code), Match: currencyCode}},
+		MinRows: 1,
+		MaxRows: 200,
+	}
+	for _, c := range columns {
+		s.Fields = append(s.Fields, scraper.Field{Name: c.name, Selector: nthCell(c.column), Number: true, Positive: true, Optional: c.optional})
+	}
+	return s
+}
+
+func nthCell(column int) string {
+	return fmt.Sprintf("td:nth-of-type(%d)", column+1)
+}
+
+// rowRate returns the rate of a row of a known currency with both the bid
+// and ask fields.
+func rowRate(row *scraper.Row, quote *Asset, ch Channel, bid, ask string, t time.Time) (*Rate, bool) {
+
+	base := Currency(row.Text["code"])
+	b, okb := row.Numbers[bid]
+	a, oka := row.Numbers[ask]
+	if base == nil || !okb || !oka {
+		return nil, false
+	}
+	return &Rate{Base: base, Quote: quote, Bid: b, Ask: a, Channel: ch, Time: t}, true
+}
@@ -0,0 +1,105 @@
+package exchange
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ECB publishes the European Central Bank's daily euro reference rates.
+// Reference rates have no spread and nobody deals at them, so books are
+// widened by ECBSpread, or by Options.Spread, to price them like a bank.
+type ECB struct {
+	Endpoint
+}
+
+// ECBSpread is the default spread of ECB books, about what banks charge
+// on top of the reference rate for transfers.
+const ECBSpread = 0.02
+
+func init() {
+	Register(func(o Options) Exchange {
+		return &ECB{Endpoint: o.endpoint()}
+	})
+}
+
+func (ecb *ECB) Meta() *Meta {
+	return &Meta{
+		Name: "ECB",
+		Slug: "ecb",
+		API:  ecb.api("https://www.ecb.europa.eu/"),
+		Pairs: ecb.pairs([]*Pair{
+			fxPair(Euro, Rand),
+			fxPair(Euro, Currency("usd")),
+			fxPair(Euro, Currency("gbp")),
+		}),
+	}
+}
+
+func (ecb *ECB) GetRatesRequest() (*http.Request, error) {
+	return http.NewRequest("GET", Build(ecb, "stats/eurofxref/eurofxref-daily.xml", nil), nil)
+}
+
+// ParseRatesResponse reads the euro reference rates, each the price of one
+// euro in another currency.
+func (ecb *ECB) ParseRatesResponse(body io.Reader) ([]*Rate, error) {
+
+	var d struct {
+		Cube struct {
+			Cube []struct {
+				Time string `xml:"time,attr"`
+				Cube []struct {
+					Currency string  `xml:"currency,attr"`
+					Rate     float64 `xml:"rate,attr"`
+				} `xml:"Cube"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	}
+	if err := xml.NewDecoder(body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	var rates []*Rate
+	for _, day := range d.Cube.Cube {
+		t, err := time.Parse("2006-01-02", day.Time)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range day.Cube {
+			quote := Currency(c.Currency)
+			if quote == nil {
+				continue
+			}
+			rates = append(rates, &Rate{Base: Euro, Quote: quote, Bid: c.Rate, Ask: c.Rate, Channel: Transfer, Time: t})
+		}
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("No rates in ECB response")
+	}
+	return rates, nil
+}
+
+func (ecb *ECB) GetOrderBookRequest(string) (*http.Request, error) {
+	return ecb.GetRatesRequest()
+}
+
+// ParseOrderBookResponse returns the EURZAR book.
+func (ecb *ECB) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
+	return ecb.ParsePairOrderBookResponse(body, fxPair(Euro, Rand))
+}
+
+func (ecb *ECB) ParsePairOrderBookResponse(body io.Reader, pair *Pair) (*OrderBook, error) {
+	return fxOrderBook(ecb, body, pair, Transfer, ecb.spread(ECBSpread))
+}
+
+func (ecb *ECB) GetOrderBooksRequest([]*Pair) (*http.Request, error) {
+	return ecb.GetRatesRequest()
+}
+
+// ParseOrderBooksResponse returns the books of pairs from one reading of
+// the rates.
+func (ecb *ECB) ParseOrderBooksResponse(body io.Reader, pairs []*Pair) ([]*OrderBook, error) {
+	return fxOrderBooks(ecb, body, pairs, func(*Pair) Channel { return Transfer }, ecb.spread(ECBSpread))
+}
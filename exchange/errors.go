@@ -0,0 +1,181 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacoduplessis/crypto/scraper"
+)
+
+// ErrorKind classifies the ways a request to an exchange can fail.
+type ErrorKind int
+
+const (
+	// TransportError means no response was received.
+	TransportError ErrorKind = iota + 1
+	// StatusError is an unexpected HTTP status.
+	StatusError
+	// APIError is an error reported by the exchange in the response body.
+	APIError
+	// ParseError is a response that could not be understood.
+	ParseError
+	// RateLimited means the exchange refused the request for exceeding its
+	// request budget.
+	RateLimited
+	// Maintenance means the exchange is down or too busy to serve requests.
+	Maintenance
+	// Challenged means an anti-bot challenge that couldn't be solved was
+	// served instead of the response.
+	Challenged
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case TransportError:
+		return "transport error"
+	case StatusError:
+		return "HTTP status error"
+	case APIError:
+		return "API error"
+	case ParseError:
+		return "parse error"
+	case RateLimited:
+		return "rate limited"
+	case Maintenance:
+		return "maintenance"
+	case Challenged:
+		return "challenged"
+	}
+	return "unknown error"
+}
+
+// Error is returned by fetches and private calls. Use errors.As to get at
+// it, or KindOf for just the kind.
+type Error struct {
+	Kind ErrorKind
+	// Exchange is the slug of the exchange, when known.
+	Exchange string
+	// StatusCode is the HTTP status, zero if there was no response.
+	StatusCode int
+	// RetryAfter is how long the exchange asked to be left alone, if it said.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+
+	var b strings.Builder
+	if e.Exchange != "" {
+		b.WriteString(e.Exchange + ": ")
+	}
+	b.WriteString(e.Kind.String())
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (HTTP %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the same request might succeed later.
+func (e *Error) Temporary() bool {
+
+	switch e.Kind {
+	case TransportError, RateLimited, Maintenance:
+		return true
+	case StatusError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// KindOf returns the kind of err, or zero if it is not an *Error.
+func KindOf(err error) ErrorKind {
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return 0
+}
+
+// classify wraps err as an *Error of the given kind unless it already is
+// one, and fills in the exchange.
+func classify(err error, kind ErrorKind, slug string) error {
+
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Kind: kind, Err: err}
+		err = e
+	}
+	if e.Exchange == "" {
+		e.Exchange = slug
+	}
+	return err
+}
+
+// transportError classifies a failure to get a response: a challenge the
+// scraper transport couldn't solve, or a transport error.
+func transportError(err error) *Error {
+
+	var c *scraper.ChallengeError
+	if errors.As(err, &c) {
+		return &Error{Kind: Challenged, StatusCode: c.StatusCode, Err: err}
+	}
+	return &Error{Kind: TransportError, Err: err}
+}
+
+// statusError returns nil for a 2xx response and otherwise classifies the
+// status. body, which may be a prefix of the response body, is kept as the
+// message.
+func statusError(resp *http.Response, body []byte) error {
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	e := &Error{Kind: StatusError, StatusCode: resp.StatusCode}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		e.Kind = RateLimited
+	case http.StatusServiceUnavailable:
+		e.Kind = Maintenance
+	}
+	e.RetryAfter = retryAfter(resp.Header.Get("Retry-After"))
+
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = resp.Status
+	}
+	e.Err = errors.New(msg)
+	return e
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date.
+func retryAfter(v string) time.Duration {
+
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
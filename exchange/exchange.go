@@ -48,71 +48,22 @@ type RequestConfig struct {
 }
 
 type Meta struct {
-	Name  string
-	Slug  string
-	Pairs []*Pair
-	API   string
-	URL   string
+	Name      string
+	Slug      string
+	Pairs     []*Pair
+	API       string
+	URL       string
+	RateLimit *RateLimit
 }
 
 // GetOrderBook fetches a single trading pair on an exchange.
 func GetOrderBook(client http.Client, exc Exchange, pair *Pair) (*OrderBook, error) {
-
-	req, err := exc.GetOrderBookRequest(pair.Code)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	ob, err := exc.ParseOrderBookResponse(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	ob.Pair = pair
-	return ob, nil
+	return (&Fetcher{Client: client}).GetOrderBook(exc, pair)
 }
 
 // GetOrderBooks fetches all trading pairs for the provided exchanges concurrently.
 func GetOrderBooks(client http.Client, exchanges ...Exchange) ([]*OrderBook, error) {
-	var obs []*OrderBook
-	numPairs := 0
-	exchangePairs := map[Exchange][]*Pair{}
-	for _, e := range exchanges {
-		p := e.Meta().Pairs
-		numPairs += len(p)
-		exchangePairs[e] = p
-	}
-	results := make(chan *OrderBook, numPairs)
-	errors := make(chan error, numPairs)
-
-	for exchange, pairs := range exchangePairs {
-
-		for _, pair := range pairs {
-			go func(e Exchange, p *Pair) {
-				ob, err := GetOrderBook(client, e, p)
-				if err != nil {
-					errors <- err
-					return
-				}
-				ob.Exchange = e
-				results <- ob
-			}(exchange, pair)
-		}
-
-	}
-
-	for i := 0; i < numPairs; i++ {
-		select {
-		case err := <-errors:
-			return nil, err
-		case ob := <-results:
-			obs = append(obs, ob)
-		}
-	}
-	return obs, nil
+	return (&Fetcher{Client: client}).GetOrderBooks(exchanges...)
 }
 
 // Build is a helper function to build a complete URL for an exchange.
@@ -142,6 +93,36 @@ func (ob *OrderBook) Prepare() *PreparedOrderBook {
 	}
 }
 
+// Validate returns what is wrong with a parsed book: no orders at all,
+// prices out of order, volumes that aren't positive or a crossed top of
+// book. One side may be empty, as on a thin market with nobody selling.
+func (ob *OrderBook) Validate() error {
+
+	if len(ob.Bids) == 0 && len(ob.Asks) == 0 {
+		return fmt.Errorf("Book has no bids or asks")
+	}
+	for i, e := range ob.Bids {
+		if !(e[0] > 0) || !(e[1] > 0) {
+			return fmt.Errorf("Bid %d is %v at %v", i, e[1], e[0])
+		}
+		if i > 0 && e[0] > ob.Bids[i-1][0] {
+			return fmt.Errorf("Bid %d at %v is above bid %d at %v", i, e[0], i-1, ob.Bids[i-1][0])
+		}
+	}
+	for i, e := range ob.Asks {
+		if !(e[0] > 0) || !(e[1] > 0) {
+			return fmt.Errorf("Ask %d is %v at %v", i, e[1], e[0])
+		}
+		if i > 0 && e[0] < ob.Asks[i-1][0] {
+			return fmt.Errorf("Ask %d at %v is below ask %d at %v", i, e[0], i-1, ob.Asks[i-1][0])
+		}
+	}
+	if len(ob.Bids) > 0 && len(ob.Asks) > 0 && ob.Bids[0][0] > ob.Asks[0][0] {
+		return fmt.Errorf("Best bid %v is above best ask %v", ob.Bids[0][0], ob.Asks[0][0])
+	}
+	return nil
+}
+
 func prepareEntries(entries [][2]float64) [][5]float64 {
 
 	var (
@@ -192,9 +173,10 @@ type RouteLeg struct {
 }
 
 type RouteRequest struct {
-	Pair   *Pair
-	Volume float64
-	Type   OrderType
+	Pair     *Pair
+	Volume   float64
+	Type     OrderType
+	Exchange Exchange
 }
 
 type RouteResult struct {
@@ -301,19 +283,20 @@ func (r *Route) Simulate(asset *Asset, amount float64) (*RouteResult, error) {
 			return nil, fmt.Errorf("Invalid asset \"%s\" for simulation: must be \"%s\" or \"%s\"", asset.Slug, leg.Pair.Base.Slug, leg.Pair.Quote.Slug)
 		}
 
-		t := &Trade{Pair: leg.Pair, OrderBook: leg.OrderBook, Type: ot}
+		// buying spends the quote asset, so the amount is a quote value
+		t := &Trade{Pair: leg.Pair, OrderBook: leg.OrderBook, Type: ot, Amount: amount, Quote: ot == BUY}
 		res, err := t.Simulate()
 		if err != nil {
 			return nil, err
 		}
 		if ot == BUY {
 			description += fmt.Sprintf("\nTRADE: buy %.4f %s on %s for %.4f %s (%.4f fee)",
-				res.Gross, res.Asset.Slug, leg.Exchange.Meta().Slug, amount, asset, res.Fee)
-			requests = append(requests, &RouteRequest{Pair: leg.Pair, Volume: res.Gross, Type: BUY})
+				res.Gross, res.Asset.Slug, leg.Exchange.Meta().Slug, amount, asset.Slug, res.Fee)
+			requests = append(requests, &RouteRequest{Pair: leg.Pair, Volume: res.Gross, Type: BUY, Exchange: leg.Exchange})
 		} else {
-			description += fmt.Sprintf("\nTRADE: sell %.f %s on %s for %.4f %s (%.4f fee)",
-				amount, asset, leg.Exchange.Meta().Slug, res.Gross, res.Asset.Slug, res.Fee)
-			requests = append(requests, &RouteRequest{Pair: leg.Pair, Volume: amount, Type: SELL})
+			description += fmt.Sprintf("\nTRADE: sell %.4f %s on %s for %.4f %s (%.4f fee)",
+				amount, asset.Slug, leg.Exchange.Meta().Slug, res.Gross, res.Asset.Slug, res.Fee)
+			requests = append(requests, &RouteRequest{Pair: leg.Pair, Volume: amount, Type: SELL, Exchange: leg.Exchange})
 		}
 
 		amount = res.Nett
@@ -329,15 +312,15 @@ func (r *Route) Simulate(asset *Asset, amount float64) (*RouteResult, error) {
 				withdrawalFee := 0.0 // TODO: implement exchange.calculate_withdrawal_fee(asset, amount)
 				depositFee := 0.0    // TODO: implement nextExchange.calculate_deposit_fee(asset, amount - withdrawal_fee)
 
-				description += fmt.Sprintf("FEE: %.4f %s withdrawal fee at %s", withdrawalFee, asset, leg.Exchange.Meta().Slug)
-				description += fmt.Sprintf("FEE: %.4f %s deposit fee at %s", depositFee, asset, nextExchange.Meta().Slug)
+				description += fmt.Sprintf("\nFEE: %.4f %s withdrawal fee at %s", withdrawalFee, asset.Slug, leg.Exchange.Meta().Slug)
+				description += fmt.Sprintf("\nFEE: %.4f %s deposit fee at %s", depositFee, asset.Slug, nextExchange.Meta().Slug)
 
 				amount = amount - withdrawalFee - depositFee
-				description += fmt.Sprintf("HOLDING: %.4f %s", amount, asset)
+				description += fmt.Sprintf("\nHOLDING: %.4f %s", amount, asset.Slug)
 			}
 
 		} else {
-			description += "DONE"
+			description += "\nDONE"
 		}
 	}
 
@@ -1,8 +1,24 @@
 package exchange
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jacoduplessis/crypto/credentials"
+	"github.com/jacoduplessis/crypto/fixture"
+	"github.com/jacoduplessis/crypto/scraper"
 )
 
 func TestTrade(t *testing.T) {
@@ -52,3 +68,966 @@ func TestTrade(t *testing.T) {
 	}
 
 }
+
+func TestRoute(t *testing.T) {
+
+	xbtzar := &Pair{Base: Bitcoin, Quote: Rand, Code: "XBTZAR", TakerFee: 0.01}
+	xbteur := &Pair{Base: Bitcoin, Quote: Euro, Code: "XBTEUR"}
+	buy := (&OrderBook{Asks: [][2]float64{{100, 1}, {200, 10}}}).Prepare()
+	sell := (&OrderBook{Bids: [][2]float64{{10, 10}}}).Prepare()
+	r := &Route{Legs: []*RouteLeg{
+		{Pair: xbtzar, OrderBook: buy, Exchange: &Luno{}},
+		{Pair: xbteur, OrderBook: sell, Exchange: &Kraken{}},
+	}}
+
+	// 300 rand buys 2 bitcoin, less a 1% fee, sold for 10 euro each
+	res, err := r.Simulate(Rand, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Asset != Euro || math.Abs(res.Amount-19.8) > 1e-9 {
+		t.Errorf("Expected 19.8 euro, got %v %s", res.Amount, res.Asset.Slug)
+	}
+	if len(res.Requests) != 2 || res.Requests[0].Volume != 2 || math.Abs(res.Requests[1].Volume-1.98) > 1e-9 {
+		t.Errorf("Unexpected requests %+v %+v", res.Requests[0], res.Requests[1])
+	}
+	for _, line := range []string{
+		"TRADE: buy 2.0000 bitcoin on luno for 300.0000 rand (0.0200 fee)",
+		"TRADE: sell 1.9800 bitcoin on kraken for 19.8000 euro (0.0000 fee)",
+		"FEE: 0.0000 bitcoin withdrawal fee at luno",
+	} {
+		if !strings.Contains(res.Description, line+"\n") {
+			t.Errorf("Expected %q in description:\n%s", line, res.Description)
+		}
+	}
+
+	if _, err := r.Simulate(Ether, 1); err == nil {
+		t.Error("Expected an error for an asset not in the first pair")
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	for _, c := range []struct {
+		ob  OrderBook
+		err string
+	}{
+		{OrderBook{Bids: [][2]float64{{10, 1}}, Asks: [][2]float64{{11, 1}}}, ""},
+		{OrderBook{Bids: [][2]float64{{10, 1}, {9, 2}}}, ""},
+		{OrderBook{Asks: [][2]float64{{11, 1}}}, ""},
+		{OrderBook{}, "Book has no bids or asks"},
+		{OrderBook{Bids: [][2]float64{{12, 1}}, Asks: [][2]float64{{11, 1}}}, "Best bid 12 is above best ask 11"},
+		{OrderBook{Bids: [][2]float64{{10, 1}, {11, 1}}}, "Bid 1 at 11 is above bid 0 at 10"},
+	} {
+		err := c.ob.Validate()
+		if got := fmt.Sprint(err); c.err == "" && err != nil || c.err != "" && got != c.err {
+			t.Errorf("Validate(%v) = %v, want %q", c.ob, err, c.err)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestPaper(t *testing.T) {
+
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := `{"bids": [{"price": "90", "volume": "1"}], "asks": [{"price": "100", "volume": "10"}]}`
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+
+	p := NewPaper(&Luno{}, map[*Asset]float64{Rand: 1000})
+	pair := &Pair{Base: Bitcoin, Quote: Rand, Code: "XBTZAR", TakerFee: 0.01}
+
+	var _ Trader = p
+
+	o, err := p.PlaceOrder(client, &OrderRequest{Pair: pair, Type: BUY, Volume: 500, Quote: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Filled || o.Volume != 5 || o.Fee != 0.05 || o.FeeAsset != Bitcoin {
+		t.Errorf("Unexpected order %+v", o)
+	}
+
+	if _, err := p.PlaceOrder(client, &OrderRequest{Pair: pair, Type: SELL, Volume: 5}); err == nil {
+		t.Error("Expected selling more than the balance to fail")
+	}
+
+	if _, err := p.PlaceOrder(client, &OrderRequest{Pair: pair, Type: SELL, Volume: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	balances, _ := p.GetBalances(client)
+	got := map[*Asset]float64{}
+	for _, b := range balances {
+		got[b.Asset] = b.Available
+	}
+	if math.Abs(got[Bitcoin]-4.45) > 1e-9 || math.Abs(got[Rand]-544.55) > 1e-9 {
+		t.Errorf("Unexpected balances %v", got)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+
+	s := NewScheduler(&Luno{})
+	s.SetLimit("luno", RateLimit{Burst: 2, Rate: 20})
+
+	var calls int
+	client := http.Client{Transport: s.Transport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("{}")), Request: r}, nil
+	}))}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := GetOrderBook(client, &Luno{}, &Pair{Code: "XBTZAR"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two requests fit the burst, the other two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected requests over budget to be delayed, took %s", elapsed)
+	}
+	if calls != 4 {
+		t.Errorf("Expected 4 calls, got %d", calls)
+	}
+	if r, ok := s.Remaining("luno"); !ok || r > 0.5 {
+		t.Errorf("Expected no budget left, got %f", r)
+	}
+	if _, ok := s.Remaining("ice"); ok {
+		t.Error("Expected ICE to be unlimited")
+	}
+
+	// an exchange with its own client still waits on the scheduler
+	s.SetLimit("luno", RateLimit{Burst: 2, Rate: 20})
+	calls = 0
+	own := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("{}")), Request: r}, nil
+	})}
+	client = http.Client{Transport: s.Transport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("Expected the exchange's own client to be used for %s", r.URL)
+		return nil, fmt.Errorf("wrong client")
+	}))}
+	luno := &Luno{Endpoint: Endpoint{Client: own}}
+
+	start = time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := GetOrderBook(client, luno, &Pair{Code: "XBTZAR"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected the own client's requests over budget to be delayed, took %s", elapsed)
+	}
+	if calls != 4 {
+		t.Errorf("Expected 4 calls on the own client, got %d", calls)
+	}
+	if r, _ := s.Remaining("luno"); r > 0.5 {
+		t.Errorf("Expected the own client to use the budget, got %f left", r)
+	}
+}
+
+func TestRetry(t *testing.T) {
+
+	responses := []struct {
+		status int
+		body   string
+	}{
+		{429, `{"error":["EAPI:Rate limit exceeded"]}`},
+		{200, `{"error":["EAPI:Rate limit exceeded"]}`},
+		{502, `Bad Gateway`},
+		{200, `{"error":[],"result":{"XXBTZEUR":{"asks":[["101","1"]],"bids":[["100","2"]]}}}`},
+	}
+	var calls int
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp := responses[calls]
+		calls++
+		return &http.Response{StatusCode: resp.status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(resp.body)), Request: r}, nil
+	})}
+
+	pair := &Pair{Code: "XXBTZEUR"}
+	f := &Fetcher{Client: client, Retries: map[string]*RetryPolicy{"kraken": {Attempts: 4, Base: time.Millisecond}}}
+	ob, err := f.GetOrderBook(&Kraken{}, pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 || ob.Bids[0][0] != 100 {
+		t.Errorf("Expected success on the 4th call, got %d calls and %v", calls, ob.Bids)
+	}
+
+	// without a policy the first failure is returned, classified
+	calls = 0
+	_, err = (&Fetcher{Client: client}).GetOrderBook(&Kraken{}, pair)
+	if KindOf(err) != RateLimited || calls != 1 {
+		t.Errorf("Expected a single rate limited call, got %v after %d calls", err, calls)
+	}
+	calls = 1
+	_, err = (&Fetcher{Client: client}).GetOrderBook(&Kraken{}, pair)
+	if e, ok := err.(*Error); !ok || e.Kind != RateLimited || e.Exchange != "kraken" || e.StatusCode != 0 {
+		t.Errorf("Expected Kraken's error array to be rate limited, got %#v", err)
+	}
+
+	// parse errors are not retried
+	responses = append(responses, struct {
+		status int
+		body   string
+	}{200, `{`})
+	calls = 4
+	_, err = f.GetOrderBook(&Kraken{}, pair)
+	if KindOf(err) != ParseError || calls != 5 {
+		t.Errorf("Expected one parse error, got %v after %d calls", err, calls)
+	}
+
+	resp := &http.Response{StatusCode: 503, Header: http.Header{"Retry-After": {"7"}}}
+	if d := DefaultRetryPolicy.Backoff(1, statusError(resp, nil)); d != 7*time.Second {
+		t.Errorf("Expected Retry-After to be honoured, got %s", d)
+	}
+	resp.Header.Set("Retry-After", "3600")
+	if d := DefaultRetryPolicy.Backoff(1, statusError(resp, nil)); d != DefaultMaxRetryAfter {
+		t.Errorf("Expected an hour's Retry-After to be capped, got %s", d)
+	}
+
+	// a cancelled fetch stops waiting to retry
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	calls = 0
+	start := time.Now()
+	_, err = (&Fetcher{Client: client, Retry: &RetryPolicy{Attempts: 4, Base: time.Hour, Max: time.Hour}}).GetOrderBookContext(ctx, &Kraken{}, pair)
+	if KindOf(err) != RateLimited || calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("Expected the first error once cancelled, got %v after %d calls in %s", err, calls, time.Since(start))
+	}
+
+	// unsolvable challenges are errors, not empty books, and not retried
+	calls = 0
+	client = http.Client{Transport: scraper.NewTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		body := `<html><script>window._cf_chl_opt={cType: 'managed'};</script></html>`
+		return &http.Response{StatusCode: 403, Header: http.Header{"Server": {"cloudflare"}}, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+	}))}
+	f = &Fetcher{Client: client, Retry: &RetryPolicy{Attempts: 3, Base: time.Millisecond}}
+	_, err = f.GetOrderBook(&AltCoinTrader{}, &Pair{Code: "/"})
+	if e, ok := err.(*Error); !ok || e.Kind != Challenged || e.StatusCode != 403 || e.Exchange != "alt" || calls != 1 {
+		t.Errorf("Expected a single challenged error, got %v after %d calls", err, calls)
+	}
+}
+
+func TestTickers(t *testing.T) {
+
+	bodies := map[string]string{
+		"/0/public/Ticker": `{"error":[],"result":{
+			"XETHZEUR":{"a":["201","1","1.0"],"b":["200","1","1.0"],"c":["200.5","0.1"],"v":["10","20"]},
+			"XXBTZEUR":{"a":["3001","1","1.0"],"b":["3000","1","1.0"],"c":["3000.5","0.1"],"v":["1","2"]}}}`,
+		"/api/1/tickers": `{"tickers":[
+			{"pair":"ETHXBT","timestamp":1500000000000,"bid":"0.07","ask":"0.08","last_trade":"0.075","rolling_24_hour_volume":"5"},
+			{"pair":"XBTZAR","timestamp":1500000000000,"bid":"40000","ask":"40100","last_trade":"40050","rolling_24_hour_volume":"50"}]}`,
+	}
+	var queries []string
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		queries = append(queries, r.URL.Query().Get("pair"))
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(bodies[r.URL.Path])), Request: r}, nil
+	})}
+	f := &Fetcher{Client: client}
+
+	kraken := &Kraken{}
+	pairs := []*Pair{{Code: "XXBTZEUR"}, {Code: "XETHZEUR"}}
+	tickers, err := f.GetTickers(context.Background(), kraken, pairs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries[0] != "XXBTZEUR,XETHZEUR" {
+		t.Errorf("Expected one request for both pairs, got %q", queries)
+	}
+	if tickers[0].Pair != pairs[0] || tickers[0].Bid != 3000 || tickers[1].Pair != pairs[1] || tickers[1].Volume != 20 {
+		t.Errorf("Kraken tickers not matched to pairs: %+v %+v", tickers[0], tickers[1])
+	}
+	if _, err := f.GetTickers(context.Background(), kraken, &Pair{Code: "XLTCZEUR"}); err == nil {
+		t.Error("Expected an error for a pair missing from the response")
+	}
+
+	luno := &Luno{}
+	tickers, err = f.GetTickers(context.Background(), luno)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tickers[0].Pair.Code != "XBTZAR" || tickers[0].Ask != 40100 || tickers[1].Last != 0.075 || tickers[1].Exchange != luno {
+		t.Errorf("Luno tickers not matched to pairs: %+v %+v", tickers[0], tickers[1])
+	}
+
+	// a cancelled fetch stops waiting to retry
+	limited := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 429, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+	})}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = (&Fetcher{Client: limited, Retry: &RetryPolicy{Attempts: 4, Base: time.Hour, Max: time.Hour}}).GetTickers(ctx, kraken, pairs...)
+	if KindOf(err) != RateLimited || time.Since(start) > time.Second {
+		t.Errorf("Expected the first error once cancelled, got %v in %s", err, time.Since(start))
+	}
+}
+
+func TestRegistry(t *testing.T) {
+
+	if !reflect.DeepEqual(Slugs(), []string{"alt", "ecb", "fnb", "ice", "kraken", "luno"}) {
+		t.Errorf("Unexpected slugs %v", Slugs())
+	}
+
+	e, p, err := Lookup("kraken:xxbtzeur")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Meta().Slug != "kraken" || p.Code != "XXBTZEUR" || p.Base != Bitcoin {
+		t.Errorf("Unexpected lookup result %s %+v", e.Meta().Slug, p)
+	}
+	for _, ref := range []string{"kraken", "nope:XXBTZEUR", "kraken:XBTZAR"} {
+		if _, _, err := Lookup(ref); err == nil {
+			t.Errorf("Expected %q to fail", ref)
+		}
+	}
+
+	var calls int
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if r.URL.Host != "mock.test" {
+			t.Errorf("Expected the base URL override, got %s", r.URL)
+		}
+		if strings.HasSuffix(r.URL.Path, "/balance") {
+			return &http.Response{StatusCode: 401, Body: ioutil.NopCloser(strings.NewReader(`{"error":"Unauthorized"}`)), Request: r}, nil
+		}
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(`{"bids":[],"asks":[]}`)), Request: r}, nil
+	})}
+	luno, err := New("luno", Options{APIKey: "key", BaseURL: "http://mock.test/api/1/", Client: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if luno.(*Luno).APIKey != "key" {
+		t.Error("Expected credentials to be set")
+	}
+	if _, err := GetOrderBook(http.Client{}, luno, FindPair(luno, "XBTZAR")); err != nil || calls != 1 {
+		t.Errorf("Expected the exchange's client to be used, got %v after %d calls", err, calls)
+	}
+	// private requests use it too, and their errors name the exchange
+	_, err = luno.(Trader).GetBalances(http.Client{})
+	if e, ok := err.(*Error); !ok || e.Exchange != "luno" || e.StatusCode != 401 || calls != 2 {
+		t.Errorf("Expected the exchange's client for trading, got %#v after %d calls", err, calls)
+	}
+
+	var slugs []string
+	for _, l := range Listings(Rand, Bitcoin) {
+		slugs = append(slugs, l.Exchange.Meta().Slug+":"+l.Pair.Code)
+	}
+	if !reflect.DeepEqual(slugs, []string{"alt:/", "ice:3", "luno:XBTZAR"}) {
+		t.Errorf("Unexpected listings %v", slugs)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+
+	static := credentials.NewStatic("key1", "secret1")
+	luno := &Luno{APIKey: "unused", APISecret: "unused-secret", Credentials: static}
+
+	req, err := luno.GetBalancesRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, pass, _ := req.BasicAuth(); user != "key1" || pass != "secret1" {
+		t.Errorf("Expected the provider's credentials, got %s %s", user, pass)
+	}
+
+	static.Rotate("key2", "secret2")
+	req, _ = luno.GetBalancesRequest()
+	if user, pass, _ := req.BasicAuth(); user != "key2" || pass != "secret2" {
+		t.Errorf("Expected rotated credentials, got %s %s", user, pass)
+	}
+
+	for _, s := range []string{fmt.Sprintf("%+v", luno), fmt.Sprintf("%#v", luno), fmt.Sprintf("%v", &Kraken{APISecret: "unused-secret"})} {
+		if strings.Contains(s, "secret") {
+			t.Errorf("Secret leaked in %s", s)
+		}
+	}
+}
+
+func TestMarkets(t *testing.T) {
+
+	bodies := map[string]string{
+		"/0/public/AssetPairs": `{"error":[],"result":{
+			"XXBTZEUR":{"altname":"XBTEUR","base":"XXBT","quote":"ZEUR","pair_decimals":1,"lot_decimals":8,
+				"fees":[[0,0.26],[50000,0.24]],"fees_maker":[[0,0.16],[50000,0.14]],"ordermin":"0.0001","tick_size":"0.1"},
+			"XXBTZEUR.d":{"base":"XXBT","quote":"ZEUR"},
+			"ADAEUR":{"base":"ADA","quote":"ZEUR","pair_decimals":6,"lot_decimals":8},
+			"XETHXXBT":{"base":"XETH","quote":"XXBT","pair_decimals":5,"lot_decimals":8}}}`,
+		"/api/exchange/1/markets": `{"markets":[
+			{"market_id":"XBTZAR","trading_status":"ACTIVE","base_currency":"XBT","counter_currency":"ZAR",
+				"min_volume":"0.0005","max_volume":"100.0","volume_scale":4,"min_price":"100","max_price":"10000000","price_scale":0},
+			{"market_id":"USDCZAR","trading_status":"ACTIVE","base_currency":"USDC","counter_currency":"ZAR","volume_scale":2,"price_scale":2},
+			{"market_id":"XRPZAR","trading_status":"POST_ONLY","base_currency":"XRP","counter_currency":"ZAR","volume_scale":0,"price_scale":2},
+			{"market_id":"ETHZAR","trading_status":"DISABLED","base_currency":"ETH","counter_currency":"ZAR"}]}`,
+		"/api/v1/pair/list": `{"errors":false,"response":{"entities":[{"pair_id":3,"pair_name":"btc/zar"},{"pair_id":99,"pair_name":"doge/zar"}]}}`,
+	}
+	var calls int
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(bodies[r.URL.Path])), Request: r}, nil
+	})}
+	f := &Fetcher{Client: client}
+
+	markets, err := f.GetMarkets(&Kraken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markets) != 2 {
+		t.Fatalf("Expected 2 markets, got %d", len(markets))
+	}
+	m := markets[0]
+	if m.Pair.Code != "XETHXXBT" || m.Pair.Base != Ether || m.Pair.Quote != Bitcoin || math.Abs(m.TickSize-0.00001) > 1e-12 {
+		t.Errorf("Unexpected market %+v %+v", m, m.Pair)
+	}
+	m = markets[1]
+	if m.Pair.Base != Bitcoin || m.Pair.Quote != Euro || m.TickSize != 0.1 || m.LotSize != 1e-8 || m.MinOrder != 0.0001 ||
+		m.Pair.TakerFee != 0.0026 || m.Pair.MakerFee != 0.0016 {
+		t.Errorf("Unexpected market %+v %+v", m, m.Pair)
+	}
+
+	markets, err = f.GetMarkets(&ICE{})
+	if err != nil || len(markets) != 1 || markets[0].Pair.Code != "3" || markets[0].Pair.Base != Bitcoin {
+		t.Errorf("Unexpected ICE markets %v %v", markets, err)
+	}
+	if _, err := f.GetMarkets(&AltCoinTrader{}); err == nil {
+		t.Error("Expected AltcoinTrader discovery to be unsupported")
+	}
+
+	cache := &MarketCache{Fetcher: f, TTL: time.Minute}
+	f.Markets = cache
+	calls = 0
+	luno := &Luno{Endpoint: Endpoint{Fees: map[string]Fee{"*": {Taker: new(float64)}}}}
+	for i := 0; i < 2; i++ {
+		pairs := f.Pairs(luno)
+		if len(pairs) != 2 || pairs[0].Code != "XBTZAR" || pairs[1].Base != Ripple {
+			t.Errorf("Unexpected Luno pairs %+v", pairs)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected markets to be cached, got %d calls", calls)
+	}
+	m, err = cache.Market(luno, "XBTZAR")
+	if err != nil || m.TickSize != 1 || m.LotSize != 0.0001 || m.MinOrder != 0.0005 {
+		t.Errorf("Unexpected Luno market %+v %v", m, err)
+	}
+	if pairs := f.Pairs(&AltCoinTrader{}); len(pairs) != 2 || pairs[0].Code != "/" {
+		t.Errorf("Expected AltcoinTrader to fall back to its hard-coded pairs, got %v", pairs)
+	}
+
+	// a slow exchange doesn't hold up discovery on the others
+	stalled, done := make(chan bool), make(chan bool)
+	slow := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/0/public/AssetPairs" {
+			stalled <- true
+			<-done
+		}
+		return client.Transport.RoundTrip(r)
+	})}
+	cache = &MarketCache{Fetcher: &Fetcher{Client: slow}, TTL: time.Minute}
+	go cache.Markets(&Kraken{})
+	<-stalled
+	if markets, err := cache.Markets(&ICE{}); err != nil || len(markets) != 1 {
+		t.Errorf("Unexpected ICE markets %v %v", markets, err)
+	}
+	close(done)
+}
+
+// unregisterAsset removes an asset registered by a test, and its aliases.
+func unregisterAsset(a *Asset) {
+
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+	delete(assets, a.Slug)
+	for _, symbols := range aliases {
+		for symbol, b := range symbols {
+			if b == a {
+				delete(symbols, symbol)
+			}
+		}
+	}
+}
+
+func TestAssets(t *testing.T) {
+
+	for _, c := range []struct {
+		venue, symbol string
+		want          *Asset
+	}{
+		{"kraken", "XXBT", Bitcoin},
+		{"kraken", "xbt", Bitcoin},
+		{"kraken", "ZEUR", Euro},
+		{"luno", "XBT", Bitcoin},
+		{"ice", "btc", Bitcoin},
+		{"luno", "ZEUR", nil},
+		{"", "DOGE", nil},
+	} {
+		if got := LookupAsset(c.venue, c.symbol); got != c.want {
+			t.Errorf("LookupAsset(%q, %q) = %v, want %v", c.venue, c.symbol, got, c.want)
+		}
+	}
+
+	// currencies are a fixed list, so unknown codes don't become assets
+	if usd := Currency("usd"); usd == nil || usd.Decimals != 2 || Currency("JPY").Decimals != 0 || Currency("eur") != Euro {
+		t.Error("Expected ISO currencies to be registered")
+	}
+	if Currency("XYZ") != nil || AssetBySlug("xyz") != nil || Currency("xbt") != nil {
+		t.Error("Expected unknown and crypto codes not to be currencies")
+	}
+
+	test := &Asset{Slug: "testcoin", Name: "Testcoin", Code: "tst", Decimals: 4, Networks: []*Network{
+		{Slug: "erc20", Name: "Ethereum"},
+		{Slug: "stellar", Name: "Stellar", Tag: "memo"},
+	}}
+	if err := RegisterAsset(test); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unregisterAsset(test) })
+	if err := RegisterAsset(&Asset{Slug: "other", Code: "TST"}); err == nil {
+		t.Error("Expected a duplicate code to fail")
+	}
+	RegisterAlias("kraken", "XTST", test)
+
+	if AssetBySlug("testcoin") != test || LookupAsset("kraken", "xtst") != test || LookupAsset("luno", "XTST") != nil {
+		t.Error("Registered asset not found")
+	}
+	// Kraken's prefixed codes resolve without an alias of their own
+	if krakenAsset("ZTST") != test || krakenAsset("XTST") != test || krakenAsset("ZZZZ") != nil {
+		t.Error("Expected Kraken's prefixed codes to resolve")
+	}
+	if test.Network("stellar").Tag != "memo" || Ripple.Network("ripple").Tag == "" || test.Network("bitcoin") != nil {
+		t.Error("Unexpected networks")
+	}
+	if test.Round(1.23456) != 1.2346 || Rand.Round(10.005001) != 10.01 {
+		t.Errorf("Unexpected rounding %v %v", test.Round(1.23456), Rand.Round(10.005001))
+	}
+}
+
+func TestFX(t *testing.T) {
+
+	ecbXML := `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<Cube>
+		<Cube time="2019-05-10">
+			<Cube currency="USD" rate="1.1245"/>
+			<Cube currency="ZAR" rate="16.0213"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+	fnbHTML := `<table>
+		<tr><th>Currency</th><th>Code</th><th>Selling</th><th>Buying</th></tr>
+		<tr><td>US Dollar</td><td>USD</td><td>14.5000</td><td>14.1000</td></tr>
+		<tr><td>Euro</td><td>EUR</td><td>16.3000</td><td>15.8000</td></tr>
+		<tr><td colspan="4">Rates are indicative</td></tr>
+	</table>`
+	bankHTML := `<table class="nav"><tr><td>x</td></tr></table>
+	<table class="rates">
+		<tr><td>USD</td><td>Dollar</td><td>R 14,05</td><td>R 14,55</td></tr>
+		<tr><td>GBP</td><td>Pound</td><td>18,20</td><td>18,90</td></tr>
+	</table>`
+
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := map[string]string{"www.ecb.europa.eu": ecbXML, "www.fnb.co.za": fnbHTML, "bank.example": bankHTML}[r.URL.Host]
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+	f := &Fetcher{Client: client}
+
+	spread := 0.01
+	ecb := &ECB{Endpoint{Spread: &spread}}
+	rates, err := GetRates(client, ecb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates[0].Quote != Currency("usd") || rates[1].Quote != Rand || rates[1].Bid != 16.0213 {
+		t.Fatalf("Unexpected ECB rates %+v", rates)
+	}
+	ob, err := f.GetOrderBook(ecb, fxPair(Euro, Currency("USD")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(ob.Bids[0][0]-1.1245*0.995) > 1e-9 || math.Abs(ob.Asks[0][0]-1.1245*1.005) > 1e-9 {
+		t.Errorf("Unexpected ECB book %v %v", ob.Bids, ob.Asks)
+	}
+	ob, err = f.GetOrderBook(&ECB{}, fxPair(Euro, Currency("USD")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(ob.Asks[0][0]-ob.Bids[0][0]-1.1245*ECBSpread) > 1e-9 {
+		t.Errorf("Expected the default spread, got %v %v", ob.Bids, ob.Asks)
+	}
+	if _, err := f.GetOrderBook(ecb, fxPair(Euro, Currency("GBP"))); KindOf(err) != ParseError {
+		t.Errorf("Expected a missing rate to be a parse error, got %v", err)
+	}
+
+	fnb := &FNB{}
+	ob, err = f.GetOrderBook(fnb, fnb.Meta().Pairs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ob.Bids[0][0] != 15.8 || ob.Asks[0][0] != 16.3 {
+		t.Errorf("Expected EUR row regardless of order, got %v %v", ob.Bids, ob.Asks)
+	}
+	usd := fxPair(Currency("USD"), Rand)
+	if ob, err := f.GetOrderBook(fnb, usd); err != nil || ob.Bids[0][0] != 14.1 {
+		t.Errorf("Unexpected FNB USD book %v %v", ob, err)
+	}
+
+	usd.TakerFee = 0.005
+	bank := &FX{
+		Name: "Bank", Slug: "bank", API: "https://bank.example/",
+		Provider: &BankTable{URL: "https://bank.example/forex", Quote: Rand, Table: "table.rates", Code: 0, Bid: 2, Ask: 3,
+			Locale: scraper.Locale{Decimal: ",", Prefixes: []string{"R"}}},
+		Pairs: []*Pair{usd},
+	}
+	ob, err = f.GetOrderBook(bank, usd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ob.Bids[0][0] != 14.05 || ob.Asks[0][0] != 14.55 {
+		t.Errorf("Unexpected bank book %v %v", ob.Bids, ob.Asks)
+	}
+
+	// rand to dollars at the bank, charged its fee
+	r := &Route{Legs: []*RouteLeg{{Pair: usd, OrderBook: ob.Prepare(), Exchange: bank}}}
+	res, err := r.Simulate(Rand, 14550)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Asset != Currency("USD") || math.Abs(res.Amount-995) > 1e-9 {
+		t.Errorf("Unexpected route result %v %s", res.Amount, res.Asset.Slug)
+	}
+}
+
+func TestFNB(t *testing.T) {
+
+	type rate struct {
+		code    string
+		channel Channel
+		bid     float64
+		ask     float64
+	}
+	for _, c := range []struct {
+		file  string
+		rates []rate
+	}{
+		{"rows.html", []rate{
+			{"USD", Transfer, 14.1123, 14.6012},
+			{"GBP", Transfer, 18.2210, 18.9021},
+			{"EUR", Transfer, 15.8710, 16.4104},
+		}},
+		{"transfer-notes.html", []rate{
+			{"EUR", Transfer, 15.8710, 16.4104},
+			{"EUR", Notes, 15.42, 16.95},
+			{"USD", Transfer, 14.1123, 14.6012},
+			{"USD", Notes, 13.71, 15.08},
+			{"BWP", Transfer, 1.288, 1.362},
+		}},
+		{"grouped.html", []rate{
+			{"USD", Transfer, 14.1123, 14.6012},
+			{"USD", Notes, 13.71, 15.08},
+			{"EUR", Transfer, 15.8710, 16.4104},
+			{"EUR", Notes, 15.42, 16.95},
+		}},
+	} {
+		b, err := ioutil.ReadFile("testdata/fnb/" + c.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fnb := &FNB{}
+		rates, err := fnb.ParseRatesResponse(strings.NewReader(string(b)))
+		if err != nil {
+			t.Errorf("%s: %v", c.file, err)
+			continue
+		}
+		var got []rate
+		for _, r := range rates {
+			if r.Quote != Rand {
+				t.Errorf("%s: unexpected quote %s", c.file, r.Quote.Slug)
+			}
+			got = append(got, rate{strings.ToUpper(r.Base.Code), r.Channel, r.Bid, r.Ask})
+		}
+		if !reflect.DeepEqual(got, c.rates) {
+			t.Errorf("%s: got %v, want %v", c.file, got, c.rates)
+		}
+
+		// EUR is found by code wherever its row is
+		ob, err := fnb.ParseOrderBookResponse(strings.NewReader(string(b)))
+		if err != nil || ob.Bids[0][0] != 15.871 || ob.Asks[0][0] != 16.4104 {
+			t.Errorf("%s: unexpected EURZAR book %v %v", c.file, ob, err)
+		}
+		_, err = fnb.ParsePairOrderBookResponse(strings.NewReader(string(b)), fnbPair(Euro, Notes))
+		if hasNotes := c.file != "rows.html"; (err == nil) != hasNotes {
+			t.Errorf("%s: unexpected notes error %v", c.file, err)
+		}
+	}
+
+	b, err := ioutil.ReadFile("testdata/fnb/transfer-notes.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	markets, err := (&FNB{}).ParseMarketsResponse(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	for _, m := range markets {
+		codes = append(codes, m.Pair.Code)
+	}
+	if !reflect.DeepEqual(codes, []string{"BWPZAR", "EURZAR", "EURZAR-NOTES", "USDZAR", "USDZAR-NOTES"}) {
+		t.Errorf("Unexpected FNB markets %v", codes)
+	}
+
+	// notes pairs are only listed when asked for
+	for _, p := range (&FNB{}).Meta().Pairs {
+		if fnbChannel(p) == Notes {
+			t.Errorf("Unexpected default pair %s", p.Code)
+		}
+	}
+	fnb := &FNB{Endpoint{Pairs: []string{"USDZAR", "usdzar-notes"}}}
+	if m := fnb.Meta(); len(m.Pairs) != 2 || m.Pairs[1].Code != "USDZAR-NOTES" {
+		t.Errorf("Expected USDZAR and its notes pair, got %v", m.Pairs)
+	}
+
+	// bank pairs charge the default fee unless the config overrides it
+	for _, p := range fnb.Meta().Pairs {
+		if p.TakerFee != FNBFee {
+			t.Errorf("Expected %s fee %v, got %v", p.Code, FNBFee, p.TakerFee)
+		}
+	}
+	for _, p := range (&Absa{}).Meta().Pairs {
+		if p.TakerFee != AbsaFee {
+			t.Errorf("Expected %s fee %v, got %v", p.Code, AbsaFee, p.TakerFee)
+		}
+	}
+	taker := 0.01
+	fees := &FNB{Endpoint{Pairs: []string{"USDZAR"}, Fees: map[string]Fee{"USDZAR": {Taker: &taker}}}}
+	if p := fees.Meta().Pairs[0]; p.TakerFee != 0.01 {
+		t.Errorf("Expected the configured fee, got %v", p.TakerFee)
+	}
+
+	// the page is fetched and parsed once for every pair
+	requests := 0
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewReader(b)), Request: r}, nil
+	})}
+	books, err := (&Fetcher{Client: client}).GetOrderBooks(fnb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 || len(books) != 2 {
+		t.Errorf("Expected 2 books from 1 request, got %d from %d", len(books), requests)
+	}
+	for _, ob := range books {
+		if want := map[string]float64{"USDZAR": 14.1123, "USDZAR-NOTES": 13.71}[ob.Pair.Code]; ob.Exchange != fnb || ob.Bids[0][0] != want {
+			t.Errorf("Unexpected %s book %v", ob.Pair.Code, ob.Bids)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata fixtures")
+
+// fixtureResult is what a fixture parses to, compared with its golden file.
+type fixtureResult struct {
+	Rates []fixtureRate `json:"rates,omitempty"`
+	Bids  [][2]float64  `json:"bids,omitempty"`
+	Asks  [][2]float64  `json:"asks,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+type fixtureRate struct {
+	Base    string  `json:"base"`
+	Quote   string  `json:"quote"`
+	Channel Channel `json:"channel"`
+	Bid     float64 `json:"bid"`
+	Ask     float64 `json:"ask"`
+}
+
+// TestFixtures parses every page in testdata/<slug>/ with the exchange
+// registered as slug, and compares the rates or book with the page's
+// .golden file. Run with -update after adding a fixture or changing a
+// parser, and review the diff.
+func TestFixtures(t *testing.T) {
+
+	files, err := filepath.Glob("testdata/*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if filepath.Ext(file) == ".golden" {
+			continue
+		}
+		if fi, err := os.Stat(file); err != nil || fi.IsDir() {
+			// testdata/http holds the replay fixtures
+			continue
+		}
+		slug := filepath.Base(filepath.Dir(file))
+		e, err := New(slug, Options{})
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var res fixtureResult
+		if p, ok := e.(RateProvider); ok {
+			rates, err := p.ParseRatesResponse(bytes.NewReader(b))
+			if err != nil {
+				res.Error = err.Error()
+			}
+			for _, r := range rates {
+				res.Rates = append(res.Rates, fixtureRate{r.Base.Code, r.Quote.Code, r.Channel, r.Bid, r.Ask})
+			}
+		} else {
+			ob, err := e.ParseOrderBookResponse(bytes.NewReader(b))
+			if err != nil {
+				res.Error = err.Error()
+			} else {
+				res.Bids, res.Asks = ob.Bids, ob.Asks
+			}
+		}
+		got, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, '\n')
+
+		golden := strings.TrimSuffix(file, filepath.Ext(file)) + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s: %v (run with -update to create it)", file, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: parsed to\n%s\nwant\n%s", file, got, want)
+		}
+	}
+}
+
+var record = flag.Bool("record", false, "record the responses in testdata/http from the live APIs")
+
+// TestReplay fetches through each exchange's fixtures, so the whole path
+// from request to parsed book is checked together. None of the fixtures
+// checked in were recorded: they were written by hand after each API's
+// documented format, so they show the code agrees with that format and not
+// that the live API still sends it, and the test logs each exchange with
+// no recorded response. Run with -record to replace them with real ones.
+func TestReplay(t *testing.T) {
+
+	tests := []struct {
+		slug    string
+		books   []string
+		tickers []string
+		markets bool
+	}{
+		{slug: "alt", books: []string{"/", "/xrp"}},
+		{slug: "ecb", books: []string{"EURZAR", "EURUSD"}},
+		{slug: "fnb", books: []string{"EURZAR", "USDZAR"}, markets: true},
+		{slug: "ice", books: []string{"3"}, markets: true},
+		{slug: "kraken", books: []string{"XXBTZEUR"}, tickers: []string{"XXBTZEUR", "XXRPZEUR"}, markets: true},
+		{slug: "luno", books: []string{"XBTZAR"}, tickers: []string{"XBTZAR", "ETHXBT"}, markets: true},
+	}
+	for _, test := range tests {
+		t.Run(test.slug, func(t *testing.T) {
+
+			tr := &fixture.Transport{
+				Dir:      filepath.Join("testdata", "http", test.slug),
+				Record:   *record,
+				Upstream: scraper.NewTransport(http.DefaultTransport),
+			}
+			exc, err := New(test.slug, Options{Client: &http.Client{Transport: tr}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &Fetcher{}
+
+			entries, err := tr.Entries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorded := 0
+			for _, e := range entries {
+				if e.Recorded != nil {
+					recorded++
+				}
+			}
+			if recorded == 0 {
+				t.Logf("%s: no recorded responses, only synthetic ones", test.slug)
+			}
+
+			pairs := func(codes []string) []*Pair {
+				var pairs []*Pair
+				for _, code := range codes {
+					p := findPair(exc.Meta().Pairs, code)
+					if p == nil {
+						t.Fatalf("No pair %s", code)
+					}
+					pairs = append(pairs, p)
+				}
+				return pairs
+			}
+
+			for _, p := range pairs(test.books) {
+				ob, err := f.GetOrderBook(exc, p)
+				if err != nil {
+					t.Errorf("%s: %v", p.Code, err)
+					continue
+				}
+				if err := ob.Validate(); err != nil {
+					t.Errorf("%s: %v", p.Code, err)
+				}
+			}
+
+			if len(test.tickers) > 0 {
+				want := pairs(test.tickers)
+				tickers, err := f.GetTickers(context.Background(), exc, want...)
+				if err != nil {
+					t.Errorf("tickers: %v", err)
+				}
+				for i, tk := range tickers {
+					if tk.Pair != want[i] || tk.Bid <= 0 || tk.Ask < tk.Bid {
+						t.Errorf("tickers: got %+v for %s", tk, test.tickers[i])
+					}
+				}
+			}
+
+			if test.markets {
+				markets, err := f.GetMarkets(exc)
+				if err != nil {
+					t.Errorf("markets: %v", err)
+				}
+				if len(markets) == 0 {
+					t.Errorf("markets: none found")
+				}
+			}
+		})
+	}
+}
+
+func findPair(pairs []*Pair, code string) *Pair {
+
+	for _, p := range pairs {
+		if p.Code == code {
+			return p
+		}
+	}
+	return nil
+}
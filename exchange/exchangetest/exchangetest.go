@@ -0,0 +1,567 @@
+// Package exchangetest is a conformance suite for Exchange implementations.
+// It checks what every adapter should agree on, however its venue's API
+// looks: Meta is consistent and honours the options, public requests go
+// to the API without credentials, saved responses parse into valid
+// books, malformed ones fail with a ParseError rather than being skipped,
+// and the optional interfaces behave as Fetcher expects, such as a
+// BookBatcher fetching every pair in one request.
+//
+// An adapter runs it over responses replayed with fixture.Transport,
+// recorded or written by hand:
+//
+//	func TestConformance(t *testing.T) {
+//		exchangetest.Run(t, newVenue, exchangetest.Config{Dir: "testdata/http/venue"})
+//	}
+package exchangetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jacoduplessis/crypto/exchange"
+	"github.com/jacoduplessis/crypto/fixture"
+)
+
+// Config describes the saved responses of an exchange.
+type Config struct {
+	// Dir holds the responses, replayed with a fixture.Transport.
+	Dir string
+	// Pairs are the codes of the pairs whose books are recorded, or all of
+	// Meta's pairs if empty.
+	Pairs []string
+	// Tickers are the codes of the pairs whose tickers are recorded, for
+	// exchanges implementing TickerBatcher, or Pairs if empty.
+	Tickers []string
+}
+
+// Run runs the suite on the exchanges made by newExchange, each check as a
+// subtest.
+func Run(t *testing.T, newExchange exchange.Constructor, c Config) {
+
+	for _, check := range []struct {
+		name string
+		fn   func(exchange.Constructor, Config) []error
+	}{
+		{"Meta", metaErrors},
+		{"Requests", requestErrors},
+		{"Fixtures", fixtureErrors},
+		{"Malformed", malformedErrors},
+		{"Capabilities", capabilityErrors},
+	} {
+		fn := check.fn
+		t.Run(check.name, func(t *testing.T) {
+			for _, err := range fn(newExchange, c) {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// testBase is the base URL the options checks point exchanges at.
+const testBase = "http://127.0.0.1:1/conformance/"
+
+// testOptions has credentials, which public requests must leave out. The
+// secret is base64, as Kraken's are.
+var testOptions = exchange.Options{
+	APIKey:    "conformance-key",
+	APISecret: "Y29uZm9ybWFuY2Utc2VjcmV0",
+}
+
+// errorList collects failures.
+type errorList []error
+
+func (l *errorList) add(format string, args ...interface{}) {
+	*l = append(*l, fmt.Errorf(format, args...))
+}
+
+func metaErrors(newExchange exchange.Constructor, c Config) []error {
+
+	var errs errorList
+	m := newExchange(exchange.Options{}).Meta()
+
+	if m.Name == "" {
+		errs.add("Meta has no name")
+	}
+	if m.Slug == "" || m.Slug != strings.ToLower(m.Slug) || strings.ContainsAny(m.Slug, " /") {
+		errs.add("Slug %q is not a lowercase word", m.Slug)
+	}
+	if u, err := url.Parse(m.API); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add("API %q is not an http(s) URL", m.API)
+	}
+	if l := m.RateLimit; l != nil && (l.Burst < 1 || l.Rate <= 0) {
+		errs.add("Rate limit %+v never allows a request", *l)
+	}
+	if len(m.Pairs) == 0 {
+		errs.add("Meta has no pairs")
+		return errs
+	}
+
+	codes := map[string]bool{}
+	for _, p := range m.Pairs {
+		switch {
+		case p.Code == "":
+			errs.add("Pair %v has no code", p)
+		case codes[p.Code]:
+			errs.add("Pair code %s is listed twice", p.Code)
+		}
+		codes[p.Code] = true
+		if p.Base == nil || p.Quote == nil || p.Base == p.Quote {
+			errs.add("Pair %s has assets %v and %v", p.Code, p.Base, p.Quote)
+		}
+		if p.TakerFee < 0 || p.TakerFee >= 0.1 || p.MakerFee < 0 || p.MakerFee >= 0.1 {
+			errs.add("Pair %s has fees %v and %v, which should be fractions", p.Code, p.TakerFee, p.MakerFee)
+		}
+	}
+
+	// Meta is built on every call, so it must not vary between them
+	again := newExchange(exchange.Options{}).Meta()
+	if again.Slug != m.Slug || pairCodes(again.Pairs) != pairCodes(m.Pairs) {
+		errs.add("Meta changed between calls: %s %s, then %s %s", m.Slug, pairCodes(m.Pairs), again.Slug, pairCodes(again.Pairs))
+	}
+
+	// the options every exchange embeds take effect
+	fee := 0.0042
+	first := m.Pairs[0]
+	o := exchange.Options{BaseURL: testBase, Pairs: []string{first.Code}, Fees: map[string]exchange.Fee{"*": {Taker: &fee}}}
+	om := newExchange(o).Meta()
+	if om.API != testBase {
+		errs.add("BaseURL %s was ignored for %s", testBase, om.API)
+	}
+	if len(om.Pairs) != 1 || om.Pairs[0].Code != first.Code {
+		errs.add("Pairs %s gave pairs %s", first.Code, pairCodes(om.Pairs))
+	} else if om.Pairs[0].TakerFee != fee {
+		errs.add("Fee override gave a taker fee of %v", om.Pairs[0].TakerFee)
+	}
+	if after := newExchange(exchange.Options{}).Meta(); after.Pairs[0].TakerFee != first.TakerFee {
+		errs.add("Fee override changed the default fee of %s to %v", first.Code, after.Pairs[0].TakerFee)
+	}
+	return errs
+}
+
+func pairCodes(pairs []*exchange.Pair) string {
+
+	var codes []string
+	for _, p := range pairs {
+		codes = append(codes, p.Code)
+	}
+	return strings.Join(codes, ",")
+}
+
+// pairs returns the pairs of e with codes, or all of them.
+func pairs(e exchange.Exchange, codes []string) ([]*exchange.Pair, error) {
+
+	all := e.Meta().Pairs
+	if len(codes) == 0 {
+		return all, nil
+	}
+	var pairs []*exchange.Pair
+	for _, code := range codes {
+		var found *exchange.Pair
+		for _, p := range all {
+			if p.Code == code {
+				found = p
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("No pair %s in Meta", code)
+		}
+		pairs = append(pairs, found)
+	}
+	return pairs, nil
+}
+
+func requestErrors(newExchange exchange.Constructor, c Config) []error {
+
+	var errs errorList
+	e := newExchange(testOptions)
+	api, err := url.Parse(e.Meta().API)
+	if err != nil {
+		return []error{err}
+	}
+	ps, err := pairs(e, c.Pairs)
+	if err != nil {
+		return []error{err}
+	}
+
+	check := func(name string, build func() (*http.Request, error)) {
+		req, err := build()
+		if err != nil {
+			errs.add("%s: %v", name, err)
+			return
+		}
+		if req.Method != "GET" {
+			errs.add("%s: %s request, want GET", name, req.Method)
+		}
+		// only the host is checked, as some endpoints, such as Luno's
+		// markets, sit beside the API's versioned path
+		if req.URL.Host != api.Host {
+			errs.add("%s: requests %s, outside the API at %s", name, req.URL, api)
+		}
+		if req.Header.Get("Authorization") != "" || req.Header.Get("API-Key") != "" {
+			errs.add("%s: public request sends credentials", name)
+		}
+		if again, err := build(); err == nil && again.URL.String() != req.URL.String() {
+			errs.add("%s: requests %s, then %s", name, req.URL, again.URL)
+		}
+	}
+
+	for _, p := range ps {
+		code := p.Code
+		check("Book "+code, func() (*http.Request, error) { return e.GetOrderBookRequest(code) })
+	}
+	if b, ok := e.(exchange.TickerBatcher); ok {
+		check("Tickers", func() (*http.Request, error) { return b.GetTickersRequest(ps) })
+	}
+	if b, ok := e.(exchange.BookBatcher); ok {
+		check("Books", func() (*http.Request, error) { return b.GetOrderBooksRequest(ps) })
+	}
+	if d, ok := e.(exchange.MarketDiscoverer); ok {
+		check("Markets", d.GetMarketsRequest)
+	}
+	if p, ok := e.(exchange.RateProvider); ok {
+		check("Rates", p.GetRatesRequest)
+	}
+
+	// requests follow the base URL
+	req, err := newExchange(exchange.Options{BaseURL: testBase}).GetOrderBookRequest(ps[0].Code)
+	if err != nil {
+		errs.add("Book with a base URL: %v", err)
+	} else if base, _ := url.Parse(testBase); req.URL.Host != base.Host || !strings.HasPrefix(req.URL.Path, strings.TrimSuffix(base.Path, "/")) {
+		errs.add("Book with base URL %s requests %s", testBase, req.URL)
+	}
+	return errs
+}
+
+// replay returns a client answered from the recorded responses, passed
+// through mangle if it isn't nil. It is given to Fetcher rather than in
+// Options, so exchanges ignoring Options.Client are still tested offline.
+func replay(c Config, mangle func([]byte) []byte) http.Client {
+
+	var tr http.RoundTripper = &fixture.Transport{Dir: c.Dir}
+	if mangle != nil {
+		tr = &mangler{tr, mangle}
+	}
+	return http.Client{Transport: tr}
+}
+
+func fixtureErrors(newExchange exchange.Constructor, c Config) []error {
+
+	var errs errorList
+	e := newExchange(exchange.Options{})
+	client := replay(c, nil)
+	f := &exchange.Fetcher{Client: client}
+
+	ps, err := pairs(e, c.Pairs)
+	if err != nil {
+		return []error{err}
+	}
+	books := map[*exchange.Pair]*exchange.OrderBook{}
+	for _, p := range ps {
+		ob, err := f.GetOrderBook(e, p)
+		if err != nil {
+			errs.add("Book %s: %v", p.Code, err)
+			continue
+		}
+		if err := ob.Validate(); err != nil {
+			errs.add("Book %s: %v", p.Code, err)
+		}
+		books[p] = ob
+	}
+
+	tps := ps
+	if _, ok := e.(exchange.TickerBatcher); ok && len(c.Tickers) > 0 {
+		if tps, err = pairs(e, c.Tickers); err != nil {
+			return append(errs, err)
+		}
+	}
+	tickers, err := f.GetTickers(context.Background(), e, tps...)
+	if err != nil {
+		errs.add("Tickers: %v", err)
+	} else if len(tickers) != len(tps) {
+		errs.add("Tickers: %d for %d pairs", len(tickers), len(tps))
+	} else {
+		for i, t := range tickers {
+			if t.Pair != tps[i] || t.Exchange != e {
+				errs.add("Ticker %d is for %v on %v, want %s", i, t.Pair, t.Exchange, tps[i].Code)
+			}
+			// a one-sided book gives a ticker without the missing side
+			ob := books[tps[i]]
+			if ob == nil || len(ob.Bids) > 0 && len(ob.Asks) > 0 {
+				if !(t.Bid > 0) || t.Ask < t.Bid {
+					errs.add("Ticker %s has bid %v and ask %v", tps[i].Code, t.Bid, t.Ask)
+				}
+			}
+			// tickers of exchanges without TickerBatcher come from the books
+			if _, ok := e.(exchange.TickerBatcher); !ok && ob != nil && (t.Bid != top(ob.Bids) || t.Ask != top(ob.Asks)) {
+				errs.add("Ticker %s doesn't match the top of its book", tps[i].Code)
+			}
+		}
+	}
+
+	if _, ok := e.(exchange.MarketDiscoverer); ok {
+		markets, err := f.GetMarkets(e)
+		if err != nil {
+			errs.add("Markets: %v", err)
+		}
+		if err == nil && len(markets) == 0 {
+			errs.add("Markets: none found")
+		}
+		codes := map[string]bool{}
+		for i, m := range markets {
+			if m.Pair == nil || m.Pair.Base == nil || m.Pair.Quote == nil {
+				errs.add("Market %d has no pair or assets", i)
+				continue
+			}
+			if codes[m.Pair.Code] {
+				errs.add("Market %s is listed twice", m.Pair.Code)
+			}
+			codes[m.Pair.Code] = true
+			if i > 0 && markets[i-1].Pair != nil && m.Pair.Code < markets[i-1].Pair.Code {
+				errs.add("Markets are not sorted by code: %s after %s", m.Pair.Code, markets[i-1].Pair.Code)
+			}
+		}
+	}
+
+	if p, ok := e.(exchange.RateProvider); ok {
+		rates, err := exchange.GetRates(client, p)
+		if err != nil {
+			errs.add("Rates: %v", err)
+		}
+		for _, r := range rates {
+			if r.Base == nil || r.Quote == nil || r.Channel == "" || !(r.Bid > 0) || r.Ask < r.Bid {
+				errs.add("Rate %+v is incomplete or crossed", *r)
+			}
+		}
+		for _, pair := range ps {
+			if exchange.FindRate(rates, pair, "") == nil {
+				errs.add("Pair %s has no rate", pair.Code)
+			}
+		}
+	}
+	return errs
+}
+
+// top returns the best price of a side of a book, or 0 if it is empty.
+func top(side [][2]float64) float64 {
+
+	if len(side) == 0 {
+		return 0
+	}
+	return side[0][0]
+}
+
+// malformed are ways a response can go wrong. Numbers only break the
+// numbers of a response, which a parser mustn't skip over, so they are
+// not applied to markets.
+var malformed = []struct {
+	name string
+	// types are the content types the mangling applies to, or all if nil.
+	types   []string
+	numbers bool
+	mangle  func([]byte) []byte
+}{
+	{name: "empty", mangle: func([]byte) []byte { return nil }},
+	{name: "error page", mangle: func([]byte) []byte {
+		return []byte("<html><head><title>502 Bad Gateway</title></head><body><h1>Bad Gateway</h1></body></html>")
+	}},
+	// an HTML page cut short may still hold whole rows
+	{name: "truncated", types: []string{"json", "xml"}, mangle: func(b []byte) []byte { return b[:len(b)/2] }},
+	{name: "numbers", numbers: true, mangle: func(b []byte) []byte { return digits.ReplaceAll(b, []byte("x")) }},
+}
+
+var digits = regexp.MustCompile(`[0-9]`)
+
+func malformedErrors(newExchange exchange.Constructor, c Config) []error {
+
+	var errs errorList
+	contentType, err := bookContentType(newExchange, c)
+	if err != nil {
+		return []error{err}
+	}
+
+	for _, m := range malformed {
+		if m.types != nil && !matchesType(contentType, m.types) {
+			continue
+		}
+		e := newExchange(exchange.Options{})
+		client := replay(c, m.mangle)
+		f := &exchange.Fetcher{Client: client}
+		ps, err := pairs(e, c.Pairs)
+		if err != nil {
+			return []error{err}
+		}
+
+		expect := func(what string, err error) {
+			if err == nil {
+				errs.add("%s with %s response: no error", what, m.name)
+			} else if exchange.KindOf(err) != exchange.ParseError {
+				errs.add("%s with %s response: %v, want a parse error", what, m.name, err)
+			}
+		}
+
+		_, err = f.GetOrderBook(e, ps[0])
+		expect("Book "+ps[0].Code, err)
+		if _, ok := e.(exchange.TickerBatcher); ok {
+			tps := ps
+			if len(c.Tickers) > 0 {
+				tps, _ = pairs(e, c.Tickers)
+			}
+			_, err := f.GetTickers(context.Background(), e, tps...)
+			expect("Tickers", err)
+		}
+		if _, ok := e.(exchange.MarketDiscoverer); ok && !m.numbers {
+			_, err := f.GetMarkets(e)
+			expect("Markets", err)
+		}
+		if p, ok := e.(exchange.RateProvider); ok {
+			_, err := exchange.GetRates(client, p)
+			expect("Rates", err)
+		}
+	}
+	return errs
+}
+
+// bookContentType returns the recorded content type of the first book.
+func bookContentType(newExchange exchange.Constructor, c Config) (string, error) {
+
+	e := newExchange(exchange.Options{})
+	ps, err := pairs(e, c.Pairs)
+	if err != nil {
+		return "", err
+	}
+	req, err := e.GetOrderBookRequest(ps[0].Code)
+	if err != nil {
+		return "", err
+	}
+	client := replay(c, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Content-Type"), nil
+}
+
+func matchesType(contentType string, types []string) bool {
+
+	mt, _, _ := mime.ParseMediaType(contentType)
+	for _, t := range types {
+		if strings.HasSuffix(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func capabilityErrors(newExchange exchange.Constructor, c Config) []error {
+
+	var errs errorList
+	e := newExchange(exchange.Options{})
+	client := replay(c, nil)
+	f := &exchange.Fetcher{Client: client}
+	ps, err := pairs(e, c.Pairs)
+	if err != nil {
+		return []error{err}
+	}
+
+	if _, ok := e.(exchange.MarketDiscoverer); !ok {
+		if _, err := f.GetMarkets(e); exchange.KindOf(err) != exchange.APIError {
+			errs.add("Markets without MarketDiscoverer: %v, want an API error", err)
+		}
+	}
+
+	// ParseOrderBookResponse must work even where PairParser is preferred
+	if _, ok := e.(exchange.PairParser); ok {
+		req, err := e.GetOrderBookRequest(ps[0].Code)
+		if err != nil {
+			return append(errs, err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return append(errs, err)
+		}
+		defer resp.Body.Close()
+		ob, err := e.ParseOrderBookResponse(resp.Body)
+		if err != nil {
+			errs.add("ParseOrderBookResponse of a PairParser: %v", err)
+		} else if err := ob.Validate(); err != nil {
+			errs.add("ParseOrderBookResponse of a PairParser: %v", err)
+		}
+	}
+
+	// batchers fetch every pair in one request, agreeing with single fetches
+	if _, ok := e.(exchange.BookBatcher); ok {
+		requests := 0
+		counted := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			requests++
+			return client.Transport.RoundTrip(r)
+		})}
+		obs, err := (&exchange.Fetcher{Client: counted}).GetOrderBookBatch(context.Background(), e, ps)
+		if err != nil {
+			errs.add("Batch: %v", err)
+		} else if requests != 1 {
+			errs.add("Batch of %d pairs sent %d requests", len(ps), requests)
+		}
+		for i, ob := range obs {
+			single, err := f.GetOrderBook(e, ps[i])
+			if err == nil && (ob.Pair != ps[i] || !reflect.DeepEqual(ob.Bids, single.Bids) || !reflect.DeepEqual(ob.Asks, single.Asks)) {
+				errs.add("Batch book %s differs from its single fetch", ps[i].Code)
+			}
+		}
+	}
+
+	// traders keep the credentials to private requests
+	if tr, ok := newExchange(testOptions).(exchange.Trader); ok {
+		sent := false
+		client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			sent = r.Header.Get("Authorization") != "" || r.Header.Get("API-Key") != ""
+			return &http.Response{StatusCode: 401, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil)), Request: r}, nil
+		})}
+		_, err := tr.GetBalances(client)
+		if !sent {
+			errs.add("GetBalances sent no credentials")
+		}
+		if exchange.KindOf(err) != exchange.StatusError {
+			errs.add("GetBalances answered with a 401: %v, want a status error", err)
+		}
+	}
+	return errs
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// mangler changes the bodies of responses.
+type mangler struct {
+	upstream http.RoundTripper
+	mangle   func([]byte) []byte
+}
+
+func (m *mangler) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	resp, err := m.upstream.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	b = m.mangle(b)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	return resp, nil
+}
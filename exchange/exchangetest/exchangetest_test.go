@@ -0,0 +1,157 @@
+package exchangetest
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// fixtures are the pairs with responses in testdata/http for each
+// registered exchange. Those checked in are synthetic; see TestReplay.
+var fixtures = map[string]Config{
+	"alt":    {Pairs: []string{"/", "/xrp"}},
+	"ecb":    {},
+	"fnb":    {},
+	"ice":    {Pairs: []string{"3"}},
+	"kraken": {Pairs: []string{"XXBTZEUR"}, Tickers: []string{"XXBTZEUR", "XXRPZEUR"}},
+	"luno":   {Pairs: []string{"XBTZAR"}, Tickers: []string{"XBTZAR", "ETHXBT"}},
+}
+
+// TestRegistered runs the suite on every registered exchange, so a new
+// venue can't be added without fixtures.
+func TestRegistered(t *testing.T) {
+
+	for _, slug := range exchange.Slugs() {
+		c, ok := fixtures[slug]
+		if !ok {
+			t.Errorf("No fixtures for %s: record them and add it to fixtures", slug)
+			continue
+		}
+		c.Dir = filepath.Join("..", "testdata", "http", slug)
+		slug := slug
+		t.Run(slug, func(t *testing.T) {
+			Run(t, func(o exchange.Options) exchange.Exchange {
+				e, err := exchange.New(slug, o)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return e
+			}, c)
+		})
+	}
+
+	// a book with nobody selling is valid, as AltCoinTrader's thin markets
+	// can be
+	t.Run("alt one-sided", func(t *testing.T) {
+		Run(t, func(o exchange.Options) exchange.Exchange {
+			e, err := exchange.New("alt", o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return e
+		}, Config{Dir: filepath.Join("..", "testdata", "http", "alt-one-sided"), Pairs: []string{"/"}})
+	})
+}
+
+// lenient is the kind of adapter the suite is there to catch: it ignores
+// its options, lists a pair twice and skips prices it can't parse.
+type lenient struct{}
+
+func (lenient) Meta() *exchange.Meta {
+	return &exchange.Meta{
+		Name: "Lenient",
+		Slug: "Lenient",
+		API:  "https://lenient.example/api/",
+		Pairs: []*exchange.Pair{
+			{Base: exchange.Bitcoin, Quote: exchange.Rand, Code: "BTCZAR", TakerFee: 0.25},
+			{Base: exchange.Bitcoin, Quote: exchange.Rand, Code: "BTCZAR"},
+		},
+	}
+}
+
+func (lenient) GetOrderBookRequest(code string) (*http.Request, error) {
+	return http.NewRequest("GET", "https://lenient.example/api/book?pair="+code, nil)
+}
+
+// ParseOrderBookResponse reads lines of "bid price volume" or "ask price
+// volume".
+func (lenient) ParseOrderBookResponse(body io.Reader) (*exchange.OrderBook, error) {
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	ob := &exchange.OrderBook{}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			continue
+		}
+		price, err1 := strconv.ParseFloat(f[1], 64)
+		volume, err2 := strconv.ParseFloat(f[2], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if f[0] == "bid" {
+			ob.Bids = append(ob.Bids, [2]float64{price, volume})
+		} else {
+			ob.Asks = append(ob.Asks, [2]float64{price, volume})
+		}
+	}
+	return ob, nil
+}
+
+func TestLenient(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "exchangetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	index := `[{"method": "GET", "url": "https://lenient.example/api/book?pair=BTCZAR", "status": 200, "content_type": "text/plain", "file": "book.txt"}]`
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "book.txt"), []byte("bid 99 1\nbid 100 2\nask 101 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := Config{Dir: dir, Pairs: []string{"BTCZAR"}}
+	newLenient := func(exchange.Options) exchange.Exchange { return lenient{} }
+
+	for _, test := range []struct {
+		name string
+		fn   func(exchange.Constructor, Config) []error
+		want []string
+	}{
+		{"meta", metaErrors, []string{
+			`Slug "Lenient" is not a lowercase word`,
+			"Pair BTCZAR has fees 0.25 and 0, which should be fractions",
+			"Pair code BTCZAR is listed twice",
+			"BaseURL http://127.0.0.1:1/conformance/ was ignored for https://lenient.example/api/",
+			"Pairs BTCZAR gave pairs BTCZAR,BTCZAR",
+		}},
+		{"fixtures", fixtureErrors, []string{
+			"Book BTCZAR: Bid 1 at 100 is above bid 0 at 99",
+		}},
+		{"malformed", malformedErrors, []string{
+			"Book BTCZAR with empty response: no error",
+			"Book BTCZAR with error page response: no error",
+			"Book BTCZAR with numbers response: no error",
+		}},
+	} {
+		var got []string
+		for _, err := range test.fn(newLenient, c) {
+			got = append(got, err.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
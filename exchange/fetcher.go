@@ -0,0 +1,263 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Observer is told about every order book fetch, for instance to collect
+// metrics. Implementations must be safe for concurrent use.
+type Observer interface {
+	// Fetched is called after every fetch with its duration and error.
+	Fetched(exc Exchange, pair *Pair, d time.Duration, err error)
+	// ParseFailed is called when a response could not be parsed.
+	ParseFailed(exc Exchange, pair *Pair, err error)
+	// Book is called with every book fetched successfully.
+	Book(ob *OrderBook)
+}
+
+// Fetcher fetches order books with a client. GetOrderBook and GetOrderBooks
+// use a Fetcher with just the client set.
+//
+// Failed fetches return an *Error. Temporary failures are retried according
+// to the exchange's entry in Retries, or Retry if it has none; a nil policy
+// means no retries.
+//
+// If Markets is set, GetOrderBooks fetches the discovered pairs of each
+// exchange rather than those hard-coded in Meta.
+type Fetcher struct {
+	Client   http.Client
+	Observer Observer
+	Retry    *RetryPolicy
+	Retries  map[string]*RetryPolicy
+	Markets  *MarketCache
+}
+
+// Pairs returns the pairs of exc to fetch.
+func (f *Fetcher) Pairs(exc Exchange) []*Pair {
+
+	if f.Markets != nil {
+		return f.Markets.Pairs(exc)
+	}
+	return exc.Meta().Pairs
+}
+
+// policy returns the retry policy for an exchange.
+func (f *Fetcher) policy(exc Exchange) *RetryPolicy {
+
+	if p, ok := f.Retries[exc.Meta().Slug]; ok {
+		return p
+	}
+	return f.Retry
+}
+
+// GetOrderBook fetches a single trading pair on an exchange.
+func (f *Fetcher) GetOrderBook(exc Exchange, pair *Pair) (*OrderBook, error) {
+	return f.GetOrderBookContext(context.Background(), exc, pair)
+}
+
+// GetOrderBookContext is GetOrderBook, giving up on the request and any
+// retries once ctx is done.
+func (f *Fetcher) GetOrderBookContext(ctx context.Context, exc Exchange, pair *Pair) (*OrderBook, error) {
+
+	start := time.Now()
+	var ob *OrderBook
+	err := f.policy(exc).Do(ctx, func() (err error) {
+		ob, err = f.getOrderBook(ctx, exc, pair)
+		return err
+	})
+	if f.Observer != nil {
+		f.Observer.Fetched(exc, pair, time.Since(start), err)
+		if err == nil {
+			f.Observer.Book(ob)
+		}
+	}
+	return ob, err
+}
+
+func (f *Fetcher) getOrderBook(ctx context.Context, exc Exchange, pair *Pair) (*OrderBook, error) {
+
+	req, err := exc.GetOrderBookRequest(pair.Code)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.do(exc, req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ob *OrderBook
+	if pp, ok := exc.(PairParser); ok {
+		ob, err = pp.ParsePairOrderBookResponse(resp.Body, pair)
+	} else {
+		ob, err = exc.ParseOrderBookResponse(resp.Body)
+	}
+	if err != nil {
+		err = classify(err, ParseError, exc.Meta().Slug)
+		if f.Observer != nil && KindOf(err) == ParseError {
+			f.Observer.ParseFailed(exc, pair, err)
+		}
+		return nil, err
+	}
+	ob.Pair = pair
+	ob.Exchange = exc
+	return ob, nil
+}
+
+// do sends a public request, returning an *Error for transport failures
+// and statuses other than 2xx.
+func (f *Fetcher) do(exc Exchange, req *http.Request) (*http.Response, error) {
+
+	slug := exc.Meta().Slug
+	client := clientFor(exc, f.Client)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, classify(transportError(err), TransportError, slug)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, classify(statusError(resp, b), StatusError, slug)
+	}
+	return resp, nil
+}
+
+// BookBatcher is implemented by exchanges whose response holds the books of
+// several pairs, such as a bank's rates page, so they are fetched and parsed
+// once rather than per pair.
+type BookBatcher interface {
+	GetOrderBooksRequest(pairs []*Pair) (*http.Request, error)
+	// ParseOrderBooksResponse returns a book for each of pairs, in the same
+	// order, failing if any is missing.
+	ParseOrderBooksResponse(body io.Reader, pairs []*Pair) ([]*OrderBook, error)
+}
+
+// GetOrderBookBatch fetches the books of pairs on exc, in the same order.
+// Exchanges implementing BookBatcher are asked in one request, the others
+// once per pair; the first failure is returned.
+func (f *Fetcher) GetOrderBookBatch(ctx context.Context, exc Exchange, pairs []*Pair) ([]*OrderBook, error) {
+
+	b, ok := exc.(BookBatcher)
+	if !ok {
+		obs := make([]*OrderBook, len(pairs))
+		for i, p := range pairs {
+			ob, err := f.GetOrderBookContext(ctx, exc, p)
+			if err != nil {
+				return nil, err
+			}
+			obs[i] = ob
+		}
+		return obs, nil
+	}
+
+	start := time.Now()
+	var obs []*OrderBook
+	err := f.policy(exc).Do(ctx, func() (err error) {
+		obs, err = f.getOrderBookBatch(ctx, exc, b, pairs)
+		return err
+	})
+	if f.Observer != nil {
+		for i, p := range pairs {
+			f.Observer.Fetched(exc, p, time.Since(start), err)
+			if err == nil {
+				f.Observer.Book(obs[i])
+			}
+		}
+	}
+	return obs, err
+}
+
+func (f *Fetcher) getOrderBookBatch(ctx context.Context, exc Exchange, b BookBatcher, pairs []*Pair) ([]*OrderBook, error) {
+
+	req, err := b.GetOrderBooksRequest(pairs)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.do(exc, req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	obs, err := b.ParseOrderBooksResponse(resp.Body, pairs)
+	if err == nil && len(obs) != len(pairs) {
+		err = fmt.Errorf("%d books for %d pairs", len(obs), len(pairs))
+	}
+	if err != nil {
+		err = classify(err, ParseError, exc.Meta().Slug)
+		if f.Observer != nil && KindOf(err) == ParseError {
+			for _, p := range pairs {
+				f.Observer.ParseFailed(exc, p, err)
+			}
+		}
+		return nil, err
+	}
+	for i, ob := range obs {
+		ob.Pair = pairs[i]
+		ob.Exchange = exc
+	}
+	return obs, nil
+}
+
+// GetOrderBooks fetches all trading pairs for the provided exchanges concurrently.
+func (f *Fetcher) GetOrderBooks(exchanges ...Exchange) ([]*OrderBook, error) {
+	return f.GetOrderBooksContext(context.Background(), exchanges...)
+}
+
+// GetOrderBooksContext is GetOrderBooks, giving up once ctx is done.
+func (f *Fetcher) GetOrderBooksContext(ctx context.Context, exchanges ...Exchange) ([]*OrderBook, error) {
+	var obs []*OrderBook
+	numPairs := 0
+	exchangePairs := map[Exchange][]*Pair{}
+	for _, e := range exchanges {
+		p := f.Pairs(e)
+		numPairs += len(p)
+		exchangePairs[e] = p
+	}
+	results := make(chan *OrderBook, numPairs)
+	errors := make(chan error, numPairs)
+
+	for exchange, pairs := range exchangePairs {
+
+		if _, ok := exchange.(BookBatcher); ok && len(pairs) > 0 {
+			go func(e Exchange, pairs []*Pair) {
+				obs, err := f.GetOrderBookBatch(ctx, e, pairs)
+				if err != nil {
+					errors <- err
+					return
+				}
+				for _, ob := range obs {
+					results <- ob
+				}
+			}(exchange, pairs)
+			continue
+		}
+
+		for _, pair := range pairs {
+			go func(e Exchange, p *Pair) {
+				ob, err := f.GetOrderBookContext(ctx, e, p)
+				if err != nil {
+					errors <- err
+					return
+				}
+				results <- ob
+			}(exchange, pair)
+		}
+
+	}
+
+	for i := 0; i < numPairs; i++ {
+		select {
+		case err := <-errors:
+			return nil, err
+		case ob := <-results:
+			obs = append(obs, ob)
+		}
+	}
+	return obs, nil
+}
@@ -1,26 +1,74 @@
 package exchange
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/jacoduplessis/crypto/scraper"
 )
 
+// FNB quotes the bank's rand exchange rates. Pairs such as USDZAR use the
+// transfer rates and pairs such as USDZAR-NOTES the rates for cash. The
+// bank's rates already have a spread, so books add none unless
+// Options.Spread is set, and the bank's commission is the pairs' taker
+// fee, FNBFee unless Options.Fees says otherwise.
 type FNB struct {
+	Endpoint
 }
 
+// FNBFee is the default taker fee of FNB's pairs, an estimate of the
+// commission the bank charges on the amount exchanged. Its minimum and
+// maximum charges are not modelled.
+const FNBFee = 0.0055
+
+func init() {
+	Register(func(o Options) Exchange {
+		return &FNB{o.endpoint()}
+	})
+}
+
+// fnbCurrencies are the currencies listed by default; discovery finds the
+// rest of those on the page. Not every currency has notes rates, so notes
+// pairs are only listed when Options.Pairs names them.
+var fnbCurrencies = []string{"EUR", "USD", "GBP", "AUD", "CAD", "CHF", "JPY", "CNY"}
+
+// fnbNotes is the suffix of pair codes using notes rates.
+const fnbNotes = "-NOTES"
+
 func (fnb *FNB) Meta() *Meta {
+
+	var pairs []*Pair
+	for _, code := range fnbCurrencies {
+		pairs = append(pairs, fnbPair(Currency(code), Transfer))
+	}
+	for _, code := range fnbCurrencies {
+		if p := fnbPair(Currency(code), Notes); containsFold(fnb.Endpoint.Pairs, p.Code) {
+			pairs = append(pairs, p)
+		}
+	}
 	return &Meta{
-		Name: "FNB",
-		Slug: "fnb",
-		API:  "https://www.fnb.co.za/",
-		Pairs: []*Pair{
-			{Base: Euro, Quote: Rand, Code: "EURZAR"},
-		},
+		Name:      "FNB",
+		Slug:      "fnb",
+		API:       fnb.api("https://www.fnb.co.za/"),
+		Pairs:     fnb.pairs(pairs),
+		RateLimit: &RateLimit{Burst: 2, Rate: 1},
+	}
+}
+
+func fnbPair(base *Asset, ch Channel) *Pair {
+
+	p := fxPair(base, Rand)
+	p.TakerFee = FNBFee
+	if ch == Notes {
+		p.Code += fnbNotes
 	}
+	return p
 }
 
 func (fnb *FNB) GetOrderBookRequest(string) (*http.Request, error) {
@@ -28,30 +76,188 @@ func (fnb *FNB) GetOrderBookRequest(string) (*http.Request, error) {
 	return http.NewRequest("GET", u, nil)
 }
 
+// ParseOrderBookResponse returns the EURZAR book.
 func (fnb *FNB) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
+	return fnb.ParsePairOrderBookResponse(body, fxPair(Euro, Rand))
+}
 
-	doc, err := goquery.NewDocumentFromReader(body)
+func (fnb *FNB) ParsePairOrderBookResponse(body io.Reader, pair *Pair) (*OrderBook, error) {
+	return fxOrderBook(fnb, body, pair, fnbChannel(pair), fnb.spread(0))
+}
+
+func (fnb *FNB) GetOrderBooksRequest([]*Pair) (*http.Request, error) {
+	return fnb.GetOrderBookRequest("")
+}
+
+// ParseOrderBooksResponse returns the books of pairs from one reading of
+// the page.
+func (fnb *FNB) ParseOrderBooksResponse(body io.Reader, pairs []*Pair) ([]*OrderBook, error) {
+	return fxOrderBooks(fnb, body, pairs, fnbChannel, fnb.spread(0))
+}
+
+// fnbChannel returns the rates a pair uses, given by its code.
+func fnbChannel(pair *Pair) Channel {
+
+	if strings.HasSuffix(pair.Code, fnbNotes) {
+		return Notes
+	}
+	return Transfer
+}
+
+func (fnb *FNB) GetRatesRequest() (*http.Request, error) {
+	return fnb.GetOrderBookRequest("")
+}
+
+func (fnb *FNB) GetMarketsRequest() (*http.Request, error) {
+	return fnb.GetRatesRequest()
+}
+
+// ParseMarketsResponse lists a pair for every rate on the page.
+func (fnb *FNB) ParseMarketsResponse(body io.Reader) ([]*Market, error) {
+
+	rates, err := fnb.ParseRatesResponse(body)
 	if err != nil {
 		return nil, err
 	}
+	var markets []*Market
+	for _, r := range rates {
+		markets = append(markets, &Market{Pair: fnbPair(r.Base, r.Channel), TickSize: 0.0001})
+	}
+	sortMarkets(markets)
+	return markets, nil
+}
+
+// fnbLayout holds the columns of the rates table, -1 if absent. Ask is the
+// bank's selling rate and Bid its buying rate.
+type fnbLayout struct {
+	code               int
+	ask, bid           int
+	notesAsk, notesBid int
+}
+
+// fnbParens matches asides such as "(Customer Buying)" in headings.
+var fnbParens = regexp.MustCompile(`\([^)]*\)`)
+
+// fnbColumns finds the columns from the table's headings, which may span
+// two rows with "Transfers" and "Notes" over the selling and buying
+// columns. A table without th cells is taken to have its headings in the
+// first row. It fails if the code, selling or buying column isn't found
+// rather than guess where they are.
+func fnbColumns(table *goquery.Selection) (fnbLayout, error) {
+
+	var (
+		labels []string
+		spans  []int // rows still covered by a rowspan, per column
+	)
+	table.Find("tr").EachWithBreak(func(i int, row *goquery.Selection) bool {
+		cells := row.Find("th")
+		more := true
+		if cells.Length() == 0 {
+			if i > 0 {
+				return false
+			}
+			cells, more = row.Find("td"), false
+		}
+		col := 0
+		cells.Each(func(_ int, th *goquery.Selection) {
+			for col < len(spans) && spans[col] > 0 {
+				spans[col]--
+				col++
+			}
+			colspan, err := strconv.Atoi(th.AttrOr("colspan", "1"))
+			if err != nil || colspan < 1 {
+				colspan = 1
+			}
+			rowspan, _ := strconv.Atoi(th.AttrOr("rowspan", "1"))
+			text := strings.ToLower(fnbParens.ReplaceAllString(th.Text(), ""))
+			for i := 0; i < colspan; i++ {
+				for len(labels) <= col {
+					labels = append(labels, "")
+					spans = append(spans, 0)
+				}
+				labels[col] += " " + text
+				if rowspan > 1 {
+					spans[col] = rowspan - 1
+				}
+				col++
+			}
+		})
+		for ; col < len(spans); col++ {
+			if spans[col] > 0 {
+				spans[col]--
+			}
+		}
+		return more
+	})
+
+	l := fnbLayout{-1, -1, -1, -1, -1}
+	set := func(c *int, i int) {
+		if *c == -1 {
+			*c = i
+		}
+	}
+	for i, label := range labels {
+		notes := strings.Contains(label, "note") || strings.Contains(label, "cash")
+		switch {
+		case strings.Contains(label, "code"):
+			set(&l.code, i)
+		case strings.Contains(label, "sell") && notes:
+			set(&l.notesAsk, i)
+		case strings.Contains(label, "buy") && notes:
+			set(&l.notesBid, i)
+		case strings.Contains(label, "sell"):
+			set(&l.ask, i)
+		case strings.Contains(label, "buy"):
+			set(&l.bid, i)
+		}
+	}
+	for _, c := range []struct {
+		name string
+		col  int
+	}{{"code", l.code}, {"selling", l.ask}, {"buying", l.bid}} {
+		if c.col == -1 {
+			return l, fmt.Errorf("No %s column in the fnb rates table", c.name)
+		}
+	}
+	return l, nil
+}
+
+// fnbLocale is how FNB prints rates.
+var fnbLocale = scraper.Locale{Decimal: ".", Thousands: ",", Prefixes: []string{"R"}}
+
+// ParseRatesResponse reads the rand rates of every currency on the page,
+// finding rows by currency code. Notes rates are included where the page
+// has them.
+func (fnb *FNB) ParseRatesResponse(body io.Reader) ([]*Rate, error) {
 
-	row := doc.Find("table").Eq(0).Find("tr").Eq(1)
-	cells := row.Find("td")
-	// code := cells.Eq(1).Text()
-	askString := strings.TrimSpace(cells.Eq(2).Text())
-	bidString := strings.TrimSpace(cells.Eq(3).Text())
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, err
+	}
 
-	ask, err := strconv.ParseFloat(askString, 64)
+	table := doc.Find("table").Eq(0)
+	l, err := fnbColumns(table)
 	if err != nil {
 		return nil, err
 	}
-	bid, err := strconv.ParseFloat(bidString, 64)
+	columns := []rateColumn{{"bid", l.bid, false}, {"ask", l.ask, false}}
+	if l.notesAsk != -1 && l.notesBid != -1 {
+		columns = append(columns, rateColumn{"notes_bid", l.notesBid, true}, rateColumn{"notes_ask", l.notesAsk, true})
+	}
+	rows, err := rateSpec("fnb rates", fnbLocale, l.code, columns).Scrape(table)
 	if err != nil {
 		return nil, err
 	}
 
-	return &OrderBook{
-		Asks: [][2]float64{{ask, 9999999}},
-		Bids: [][2]float64{{bid, 9999999}},
-	}, nil
+	var rates []*Rate
+	now := time.Now()
+	for _, row := range rows {
+		if r, ok := rowRate(row, Rand, Transfer, "bid", "ask", now); ok {
+			rates = append(rates, r)
+		}
+		if r, ok := rowRate(row, Rand, Notes, "notes_bid", "notes_ask", now); ok {
+			rates = append(rates, r)
+		}
+	}
+	return rates, nil
 }
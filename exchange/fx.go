@@ -0,0 +1,194 @@
+package exchange
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Channel is how foreign currency changes hands at a bank, which decides
+// the rate.
+type Channel string
+
+const (
+	// Transfer rates apply to telegraphic and electronic transfers.
+	Transfer Channel = "transfer"
+	// Notes rates apply to cash.
+	Notes Channel = "notes"
+)
+
+// Rate is a bank's or central bank's price of a foreign currency.
+type Rate struct {
+	// Base is the foreign currency and Quote the currency the rate is in.
+	Base  *Asset
+	Quote *Asset
+	// Bid is what the bank pays, in Quote, for one Base, and Ask what it
+	// charges. Reference rates have Bid equal to Ask.
+	Bid     float64
+	Ask     float64
+	Channel Channel
+	Time    time.Time
+}
+
+// RateProvider publishes foreign exchange rates.
+type RateProvider interface {
+	GetRatesRequest() (*http.Request, error)
+	ParseRatesResponse(body io.Reader) ([]*Rate, error)
+}
+
+// GetRates fetches the current rates of a provider. Failures are returned
+// as *Error, as with Fetcher.
+func GetRates(client http.Client, p RateProvider) ([]*Rate, error) {
+
+	var slug string
+	if e, ok := p.(Exchange); ok {
+		slug = e.Meta().Slug
+	}
+	req, err := p.GetRatesRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, classify(transportError(err), TransportError, slug)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, classify(statusError(resp, b), StatusError, slug)
+	}
+	rates, err := p.ParseRatesResponse(resp.Body)
+	if err != nil {
+		return nil, classify(err, ParseError, slug)
+	}
+	return rates, nil
+}
+
+// PairParser is implemented by exchanges whose responses can only be parsed
+// knowing the pair, such as a page listing many currencies. Fetcher prefers
+// it to ParseOrderBookResponse.
+type PairParser interface {
+	ParsePairOrderBookResponse(body io.Reader, pair *Pair) (*OrderBook, error)
+}
+
+// fxDepth is the volume put at a rate, as banks quote without depth.
+const fxDepth = 1e9
+
+// OrderBook returns a single level book for r. The rates are widened by
+// spread, a fraction of the mid rate split between the two sides, which
+// turns reference rates into something a bank might actually offer.
+func (r *Rate) OrderBook(spread float64) *OrderBook {
+
+	mid := (r.Bid + r.Ask) / 2
+	return &OrderBook{
+		Bids: [][2]float64{{r.Bid - mid*spread/2, fxDepth}},
+		Asks: [][2]float64{{r.Ask + mid*spread/2, fxDepth}},
+	}
+}
+
+// FindRate returns the rate for a pair on a channel, or nil. An empty
+// channel matches any.
+func FindRate(rates []*Rate, pair *Pair, ch Channel) *Rate {
+
+	for _, r := range rates {
+		if r.Base == pair.Base && r.Quote == pair.Quote && (ch == "" || r.Channel == ch) {
+			return r
+		}
+	}
+	return nil
+}
+
+// fxPair returns the pair of a rate, with a code such as USDZAR.
+func fxPair(base, quote *Asset) *Pair {
+	return &Pair{Base: base, Quote: quote, Code: strings.ToUpper(base.Code + quote.Code)}
+}
+
+// fxOrderBook parses rates and returns the book of pair.
+func fxOrderBook(p RateProvider, body io.Reader, pair *Pair, ch Channel, spread float64) (*OrderBook, error) {
+
+	obs, err := fxOrderBooks(p, body, []*Pair{pair}, func(*Pair) Channel { return ch }, spread)
+	if err != nil {
+		return nil, err
+	}
+	return obs[0], nil
+}
+
+// fxOrderBooks parses rates once and returns the book of each pair, on the
+// channel channel gives for it.
+func fxOrderBooks(p RateProvider, body io.Reader, pairs []*Pair, channel func(*Pair) Channel, spread float64) ([]*OrderBook, error) {
+
+	rates, err := p.ParseRatesResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	obs := make([]*OrderBook, len(pairs))
+	for i, pair := range pairs {
+		ch := channel(pair)
+		r := FindRate(rates, pair, ch)
+		if r == nil {
+			return nil, fmt.Errorf("No %s rate for %s", ch, pair.Code)
+		}
+		obs[i] = r.OrderBook(spread)
+	}
+	return obs, nil
+}
+
+// FX makes a RateProvider an Exchange, so its rates can be fetched as books
+// and used as legs in routes. Bank fees are charged through the pairs'
+// TakerFee.
+type FX struct {
+	Provider RateProvider
+	Name     string
+	Slug     string
+	// API is the provider's URL, used to apply rate limits.
+	API   string
+	Pairs []*Pair
+	// Channel selects the rates to use, Transfer if empty.
+	Channel Channel
+	// Spread widens the rates; see Rate.OrderBook.
+	Spread float64
+}
+
+func (fx *FX) Meta() *Meta {
+	return &Meta{Name: fx.Name, Slug: fx.Slug, API: fx.API, Pairs: fx.Pairs}
+}
+
+func (fx *FX) GetOrderBookRequest(string) (*http.Request, error) {
+	return fx.Provider.GetRatesRequest()
+}
+
+// ParseOrderBookResponse returns the book of the only pair, since the
+// response holds every rate.
+func (fx *FX) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
+
+	if len(fx.Pairs) != 1 {
+		return nil, fmt.Errorf("%s quotes %d pairs, use ParsePairOrderBookResponse", fx.Name, len(fx.Pairs))
+	}
+	return fx.ParsePairOrderBookResponse(body, fx.Pairs[0])
+}
+
+func (fx *FX) ParsePairOrderBookResponse(body io.Reader, pair *Pair) (*OrderBook, error) {
+	return fxOrderBook(fx.Provider, body, pair, fx.channel(), fx.Spread)
+}
+
+func (fx *FX) GetOrderBooksRequest([]*Pair) (*http.Request, error) {
+	return fx.Provider.GetRatesRequest()
+}
+
+func (fx *FX) ParseOrderBooksResponse(body io.Reader, pairs []*Pair) ([]*OrderBook, error) {
+
+	ch := fx.channel()
+	return fxOrderBooks(fx.Provider, body, pairs, func(*Pair) Channel { return ch }, fx.Spread)
+}
+
+// channel returns Channel, or Transfer if it isn't set.
+func (fx *FX) channel() Channel {
+
+	if fx.Channel == "" {
+		return Transfer
+	}
+	return fx.Channel
+}
@@ -2,13 +2,28 @@ package exchange
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jacoduplessis/crypto/credentials"
 )
 
 type ICE struct {
-	APIKey    string
-	APISecret string
+	APIKey    credentials.Secret
+	APISecret credentials.Secret
+	// Credentials, if set, is used instead of APIKey and APISecret and
+	// asked on every private request, so keys can be rotated.
+	Credentials credentials.Provider
+	Endpoint
+}
+
+func init() {
+	Register(func(o Options) Exchange {
+		return &ICE{APIKey: o.APIKey, APISecret: o.APISecret, Credentials: o.Credentials, Endpoint: o.endpoint()}
+	})
 }
 
 func (ice *ICE) Meta() *Meta {
@@ -16,8 +31,8 @@ func (ice *ICE) Meta() *Meta {
 	return &Meta{
 		Name: "ICE",
 		Slug: "ice",
-		API:  "https://ice3x.com/api/v1/",
-		Pairs: []*Pair{
+		API:  ice.api("https://ice3x.com/api/v1/"),
+		Pairs: ice.pairs([]*Pair{
 			{Base: Bitcoin, Quote: Rand, Code: "3"},
 			{Base: Litecoin, Quote: Rand, Code: "6"},
 			{Base: Ether, Quote: Rand, Code: "11"},
@@ -25,8 +40,49 @@ func (ice *ICE) Meta() *Meta {
 			{Base: Bitcoincash, Quote: Bitcoin, Code: "14"},
 			{Base: Bitcoincash, Quote: Rand, Code: "15"},
 			{Base: Litecoin, Quote: Bitcoin, Code: "16"},
-		},
+		}),
+	}
+}
+
+func (ice *ICE) GetMarketsRequest() (*http.Request, error) {
+	return http.NewRequest("GET", Build(ice, "pair/list", nil), nil)
+}
+
+// ParseMarketsResponse reads the pair list, whose names look like btc/zar.
+// The list gives no tick size, lot size, minimum order or fees, and ICE has
+// no other public endpoint that does, so those are left zero.
+func (ice *ICE) ParseMarketsResponse(body io.Reader) ([]*Market, error) {
+
+	var d struct {
+		Errors   bool
+		Response struct {
+			Entities []struct {
+				PairID   int    `json:"pair_id"`
+				PairName string `json:"pair_name"`
+			}
+		}
+	}
+	if err := json.NewDecoder(body).Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.Errors {
+		return nil, &Error{Kind: APIError, Exchange: "ice", Err: fmt.Errorf("ICE reported an error listing pairs")}
+	}
+
+	var markets []*Market
+	for _, e := range d.Response.Entities {
+		parts := strings.Split(e.PairName, "/")
+		if len(parts) != 2 {
+			continue
+		}
+		base, quote := LookupAsset("ice", parts[0]), LookupAsset("ice", parts[1])
+		if base == nil || quote == nil {
+			continue
+		}
+		markets = append(markets, &Market{Pair: &Pair{Base: base, Quote: quote, Code: strconv.Itoa(e.PairID)}})
 	}
+	sortMarkets(markets)
+	return markets, nil
 }
 
 func (ice *ICE) GetOrderBookRequest(pairCode string) (*http.Request, error) {
@@ -1,26 +1,58 @@
 package exchange
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/jacoduplessis/crypto/credentials"
 	"github.com/pkg/errors"
 )
 
 type Kraken struct {
-	APIKey    string
-	APISecret string
+	APIKey    credentials.Secret
+	APISecret credentials.Secret
+	// Credentials, if set, is used instead of APIKey and APISecret and
+	// asked on every private request, so keys can be rotated.
+	Credentials credentials.Provider
+	Endpoint
+
+	mu    sync.Mutex
+	nonce int64
+}
+
+func init() {
+	// Kraken prefixes older crypto codes with X and fiat codes with Z;
+	// krakenAsset strips the prefix of codes not listed here
+	for symbol, a := range map[string]*Asset{
+		"XXBT": Bitcoin, "XETH": Ether, "XLTC": Litecoin, "XXRP": Ripple, "ZEUR": Euro, "ZZAR": Rand,
+	} {
+		RegisterAlias("kraken", symbol, a)
+	}
+	Register(func(o Options) Exchange {
+		return &Kraken{APIKey: o.APIKey, APISecret: o.APISecret, Credentials: o.Credentials, Endpoint: o.endpoint()}
+	})
 }
 
 func (kr *Kraken) Meta() *Meta {
 	return &Meta{
 		Name: "Kraken",
 		Slug: "kraken",
-		API:  "https://api.kraken.com/0/",
-		Pairs: []*Pair{
+		API:  kr.api("https://api.kraken.com/0/"),
+		// the call counter allows 15 calls and decays by one every 3 seconds
+		RateLimit: &RateLimit{Burst: 15, Rate: 1.0 / 3},
+		Pairs: kr.pairs([]*Pair{
 			{Base: Bitcoin, Quote: Euro, Code: "XXBTZEUR"},
 			{Base: Ripple, Quote: Euro, Code: "XXRPZEUR"},
 			{Base: Ripple, Quote: Bitcoin, Code: "XXRPXXBT"},
@@ -30,7 +62,7 @@ func (kr *Kraken) Meta() *Meta {
 			{Base: Bitcoincash, Quote: Bitcoin, Code: "BCHXBT"},
 			{Base: Ether, Quote: Euro, Code: "XETHZEUR"},
 			{Base: Ether, Quote: Bitcoin, Code: "XETHXXBT"},
-		},
+		}),
 	}
 }
 
@@ -59,7 +91,7 @@ func (kr *Kraken) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
 		Bids [][2]string
 	}
 	var d struct {
-		Errors []string
+		Errors []string `json:"error"`
 		Result map[string]Data
 	}
 
@@ -68,14 +100,17 @@ func (kr *Kraken) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
 		return nil, err
 	}
 	if len(d.Errors) != 0 {
-		return nil, errors.New(strings.Join(d.Errors, ","))
+		return nil, krakenError(d.Errors)
 	}
 
+	// Depth takes a single pair, so anything but one result is a surprise
+	// rather than something to pick from
+	if len(d.Result) != 1 {
+		return nil, fmt.Errorf("Expected one pair in Kraken depth response, got %d", len(d.Result))
+	}
 	var data Data
-
 	for _, v := range d.Result {
 		data = v
-		break
 	}
 
 	bids, err := parse(data.Bids)
@@ -94,8 +129,310 @@ func (kr *Kraken) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
 
 }
 
+// GetOrderBookRequest asks for the book of one pair. Kraken's Depth endpoint
+// takes a single pair, so unlike tickers its books can't be batched and
+// cost one request, and one unit of the rate limit, per pair.
 func (kr *Kraken) GetOrderBookRequest(pairCode string) (*http.Request, error) {
 
 	u := Build(kr, "public/Depth", map[string]string{"pair": pairCode})
 	return http.NewRequest("GET", u, nil)
 }
+
+func (kr *Kraken) GetTickersRequest(pairs []*Pair) (*http.Request, error) {
+
+	codes := make([]string, len(pairs))
+	for i, p := range pairs {
+		codes[i] = p.Code
+	}
+	u := Build(kr, "public/Ticker", map[string]string{"pair": strings.Join(codes, ",")})
+	return http.NewRequest("GET", u, nil)
+}
+
+// ParseTickersResponse matches the result keys, which are Kraken's pair
+// names, to the pair codes.
+func (kr *Kraken) ParseTickersResponse(body io.Reader, pairs []*Pair) ([]*Ticker, error) {
+
+	var d struct {
+		Errors []string `json:"error"`
+		Result map[string]struct {
+			A []string // ask price, whole lot volume, lot volume
+			B []string // bid price, whole lot volume, lot volume
+			C []string // last trade price, lot volume
+			V []string // volume today, last 24 hours
+		}
+	}
+	if err := json.NewDecoder(body).Decode(&d); err != nil {
+		return nil, err
+	}
+	if len(d.Errors) != 0 {
+		return nil, krakenError(d.Errors)
+	}
+
+	now := time.Now()
+	found := map[string]*Ticker{}
+	for code, r := range d.Result {
+		if len(r.A) == 0 || len(r.B) == 0 || len(r.C) == 0 || len(r.V) < 2 {
+			return nil, fmt.Errorf("Incomplete Kraken ticker for %s", code)
+		}
+		t := &Ticker{Time: now}
+		for _, f := range []struct {
+			s string
+			v *float64
+		}{{r.A[0], &t.Ask}, {r.B[0], &t.Bid}, {r.C[0], &t.Last}, {r.V[1], &t.Volume}} {
+			v, err := strconv.ParseFloat(f.s, 64)
+			if err != nil {
+				return nil, err
+			}
+			*f.v = v
+		}
+		found[code] = t
+	}
+	return matchPairs(pairs, found)
+}
+
+func (kr *Kraken) GetMarketsRequest() (*http.Request, error) {
+	return http.NewRequest("GET", Build(kr, "public/AssetPairs", nil), nil)
+}
+
+// ParseMarketsResponse reads AssetPairs. Fees are the lowest volume tier.
+func (kr *Kraken) ParseMarketsResponse(body io.Reader) ([]*Market, error) {
+
+	var d struct {
+		Errors []string `json:"error"`
+		Result map[string]struct {
+			Base         string
+			Quote        string
+			PairDecimals int          `json:"pair_decimals"`
+			LotDecimals  int          `json:"lot_decimals"`
+			TickSize     string       `json:"tick_size"`
+			OrderMin     string       `json:"ordermin"`
+			Fees         [][2]float64 `json:"fees"`
+			FeesMaker    [][2]float64 `json:"fees_maker"`
+		}
+	}
+	if err := json.NewDecoder(body).Decode(&d); err != nil {
+		return nil, err
+	}
+	if len(d.Errors) != 0 {
+		return nil, krakenError(d.Errors)
+	}
+
+	var markets []*Market
+	for code, r := range d.Result {
+		// dark pool pairs have their own books
+		if strings.HasSuffix(code, ".d") {
+			continue
+		}
+		base, quote := krakenAsset(r.Base), krakenAsset(r.Quote)
+		if base == nil || quote == nil {
+			continue
+		}
+		m := &Market{
+			Pair:     &Pair{Base: base, Quote: quote, Code: code},
+			TickSize: math.Pow10(-r.PairDecimals),
+			LotSize:  math.Pow10(-r.LotDecimals),
+		}
+		if r.TickSize != "" {
+			tick, err := strconv.ParseFloat(r.TickSize, 64)
+			if err != nil {
+				return nil, err
+			}
+			m.TickSize = tick
+		}
+		if r.OrderMin != "" {
+			min, err := strconv.ParseFloat(r.OrderMin, 64)
+			if err != nil {
+				return nil, err
+			}
+			m.MinOrder = min
+		}
+		if len(r.Fees) > 0 {
+			m.Pair.TakerFee = r.Fees[0][1] / 100
+		}
+		if len(r.FeesMaker) > 0 {
+			m.Pair.MakerFee = r.FeesMaker[0][1] / 100
+		}
+		markets = append(markets, m)
+	}
+	sortMarkets(markets)
+	return markets, nil
+}
+
+// nextNonce returns a nonce larger than any handed out before, as Kraken
+// rejects reused or decreasing nonces.
+func (kr *Kraken) nextNonce() string {
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	n := time.Now().UnixNano() / 1000
+	if n <= kr.nonce {
+		n = kr.nonce + 1
+	}
+	kr.nonce = n
+	return strconv.FormatInt(n, 10)
+}
+
+// privateRequest builds a signed request for a private endpoint.
+func (kr *Kraken) privateRequest(method string, form url.Values) (*http.Request, error) {
+
+	u, err := url.Parse(Build(kr, "private/"+method, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := kr.nextNonce()
+	form.Set("nonce", nonce)
+	postData := form.Encode()
+
+	c, err := credentialsOf(kr.APIKey, kr.APISecret, kr.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(string(c.Secret))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid Kraken API secret")
+	}
+	sha := sha256.Sum256([]byte(nonce + postData))
+	mac := hmac.New(sha512.New, secret)
+	mac.Write(append([]byte(u.Path), sha[:]...))
+
+	req, err := http.NewRequest("POST", u.String(), strings.NewReader(postData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("API-Key", string(c.Key))
+	req.Header.Set("API-Sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return req, nil
+}
+
+// parsePrivateResponse decodes the result of a private call into v.
+func (kr *Kraken) parsePrivateResponse(body []byte, v interface{}) error {
+
+	var d struct {
+		Error  []string
+		Result json.RawMessage
+	}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return err
+	}
+	if len(d.Error) != 0 {
+		return krakenError(d.Error)
+	}
+	return json.Unmarshal(d.Result, v)
+}
+
+// krakenError classifies the error array Kraken returns alongside a 200.
+// Codes look like EAPI:Rate limit exceeded or EService:Unavailable.
+func krakenError(codes []string) error {
+
+	kind := APIError
+	for _, c := range codes {
+		switch {
+		case strings.HasPrefix(c, "EAPI:Rate limit"), strings.HasPrefix(c, "EOrder:Rate limit"), c == "EGeneral:Temporary lockout":
+			kind = RateLimited
+		case strings.HasPrefix(c, "EService:"):
+			kind = Maintenance
+		}
+	}
+	return &Error{Kind: kind, Exchange: "kraken", Err: errors.New(strings.Join(codes, ","))}
+}
+
+// krakenAsset returns the asset of a Kraken code. Kraken prefixes older
+// crypto codes with X and fiat codes with Z, as in XXLM and ZUSD, so codes
+// without an alias are looked up again without the prefix.
+func krakenAsset(code string) *Asset {
+
+	if a := LookupAsset("kraken", code); a != nil {
+		return a
+	}
+	if len(code) == 4 && (code[0] == 'X' || code[0] == 'Z') {
+		return LookupAsset("kraken", code[1:])
+	}
+	return nil
+}
+
+func (kr *Kraken) GetBalancesRequest() (*http.Request, error) {
+	return kr.privateRequest("Balance", url.Values{})
+}
+
+func (kr *Kraken) ParseBalancesResponse(body []byte) ([]*Balance, error) {
+
+	var result map[string]string
+	if err := kr.parsePrivateResponse(body, &result); err != nil {
+		return nil, err
+	}
+
+	var balances []*Balance
+	for code, amount := range result {
+		asset := krakenAsset(code)
+		if asset == nil {
+			continue
+		}
+		available, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, &Balance{Asset: asset, Available: available})
+	}
+	return balances, nil
+}
+
+func (kr *Kraken) GetBalances(client http.Client) ([]*Balance, error) {
+
+	req, err := kr.GetBalancesRequest()
+	if err != nil {
+		return nil, err
+	}
+	b, err := doPrivate(kr, client, req)
+	if err != nil {
+		return nil, err
+	}
+	return kr.ParseBalancesResponse(b)
+}
+
+func (kr *Kraken) PlaceOrderRequest(o *OrderRequest) (*http.Request, error) {
+
+	form := url.Values{
+		"pair":      {o.Pair.Code},
+		"type":      {"sell"},
+		"ordertype": {"market"},
+		"volume":    {strconv.FormatFloat(o.Volume, 'f', -1, 64)},
+	}
+	if o.Type == BUY {
+		form.Set("type", "buy")
+	}
+	if o.Quote {
+		form.Set("oflags", "viqc")
+	}
+	return kr.privateRequest("AddOrder", form)
+}
+
+func (kr *Kraken) ParsePlaceOrderResponse(body []byte) (string, error) {
+
+	var result struct {
+		TxID []string
+	}
+	if err := kr.parsePrivateResponse(body, &result); err != nil {
+		return "", err
+	}
+	return strings.Join(result.TxID, ","), nil
+}
+
+func (kr *Kraken) PlaceOrder(client http.Client, o *OrderRequest) (*Order, error) {
+
+	req, err := kr.PlaceOrderRequest(o)
+	if err != nil {
+		return nil, err
+	}
+	b, err := doPrivate(kr, client, req)
+	if err != nil {
+		return nil, err
+	}
+	id, err := kr.ParsePlaceOrderResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Order{ID: id, Pair: o.Pair, Type: o.Type, Time: time.Now()}, nil
+}
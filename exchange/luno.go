@@ -2,25 +2,43 @@ package exchange
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacoduplessis/crypto/credentials"
 )
 
 type Luno struct {
-	APIKey    string
-	APISecret string
+	APIKey    credentials.Secret
+	APISecret credentials.Secret
+	// Credentials, if set, is used instead of APIKey and APISecret and
+	// asked on every private request, so keys can be rotated.
+	Credentials credentials.Provider
+	Endpoint
+}
+
+func init() {
+	Register(func(o Options) Exchange {
+		return &Luno{APIKey: o.APIKey, APISecret: o.APISecret, Credentials: o.Credentials, Endpoint: o.endpoint()}
+	})
 }
 
 func (ln *Luno) Meta() *Meta {
 	return &Meta{
-		Name: "Luno",
-		Slug: "luno",
-		API:  "https://api.mybitx.com/api/1/",
-		Pairs: []*Pair{
+		Name:      "Luno",
+		Slug:      "luno",
+		API:       ln.api("https://api.mybitx.com/api/1/"),
+		RateLimit: &RateLimit{Burst: 5, Rate: 1},
+		Pairs: ln.pairs([]*Pair{
 			{Base: Bitcoin, Quote: Rand, Code: "XBTZAR"},
 			{Base: Ether, Quote: Bitcoin, Code: "ETHXBT"},
-		},
+		}),
 	}
 }
 
@@ -79,3 +97,224 @@ func (ln *Luno) ParseOrderBookResponse(body io.Reader) (*OrderBook, error) {
 		Asks: asks,
 	}, nil
 }
+
+// GetTickersRequest asks for the tickers of all pairs, as Luno's tickers
+// endpoint doesn't filter.
+func (ln *Luno) GetTickersRequest(pairs []*Pair) (*http.Request, error) {
+	return http.NewRequest("GET", Build(ln, "tickers", nil), nil)
+}
+
+func (ln *Luno) ParseTickersResponse(body io.Reader, pairs []*Pair) ([]*Ticker, error) {
+
+	var d struct {
+		Tickers []struct {
+			Pair                string
+			Timestamp           int64
+			Bid                 string
+			Ask                 string
+			LastTrade           string `json:"last_trade"`
+			Rolling24HourVolume string `json:"rolling_24_hour_volume"`
+		}
+	}
+	if err := json.NewDecoder(body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	found := map[string]*Ticker{}
+	for _, r := range d.Tickers {
+		t := &Ticker{Time: time.Unix(0, r.Timestamp*int64(time.Millisecond))}
+		for _, f := range []struct {
+			s string
+			v *float64
+		}{{r.Bid, &t.Bid}, {r.Ask, &t.Ask}, {r.LastTrade, &t.Last}, {r.Rolling24HourVolume, &t.Volume}} {
+			if f.s == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(f.s, 64)
+			if err != nil {
+				return nil, err
+			}
+			*f.v = v
+		}
+		found[r.Pair] = t
+	}
+	return matchPairs(pairs, found)
+}
+
+// GetMarketsRequest asks for Luno's markets, which give each pair's price
+// and volume scales and minimum volume. Luno's fees depend on the account's
+// trading volume and are only given to authenticated requests, so markets
+// carry none.
+func (ln *Luno) GetMarketsRequest() (*http.Request, error) {
+	return http.NewRequest("GET", Build(ln, "../exchange/1/markets", nil), nil)
+}
+
+// ParseMarketsResponse reads the markets, leaving out disabled ones.
+func (ln *Luno) ParseMarketsResponse(body io.Reader) ([]*Market, error) {
+
+	var d struct {
+		Markets []struct {
+			MarketID        string `json:"market_id"`
+			TradingStatus   string `json:"trading_status"`
+			BaseCurrency    string `json:"base_currency"`
+			CounterCurrency string `json:"counter_currency"`
+			MinVolume       string `json:"min_volume"`
+			VolumeScale     int    `json:"volume_scale"`
+			PriceScale      int    `json:"price_scale"`
+		}
+	}
+	if err := json.NewDecoder(body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	var markets []*Market
+	for _, r := range d.Markets {
+		if r.TradingStatus == "DISABLED" {
+			continue
+		}
+		base, quote := LookupAsset("luno", r.BaseCurrency), LookupAsset("luno", r.CounterCurrency)
+		if base == nil || quote == nil {
+			continue
+		}
+		m := &Market{
+			Pair:     &Pair{Base: base, Quote: quote, Code: r.MarketID},
+			TickSize: math.Pow10(-r.PriceScale),
+			LotSize:  math.Pow10(-r.VolumeScale),
+		}
+		if r.MinVolume != "" {
+			min, err := strconv.ParseFloat(r.MinVolume, 64)
+			if err != nil {
+				return nil, err
+			}
+			m.MinOrder = min
+		}
+		markets = append(markets, m)
+	}
+	sortMarkets(markets)
+	return markets, nil
+}
+
+// authorize adds the current credentials to req.
+func (ln *Luno) authorize(req *http.Request) error {
+
+	c, err := credentialsOf(ln.APIKey, ln.APISecret, ln.Credentials)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(string(c.Key), string(c.Secret))
+	return nil
+}
+
+func (ln *Luno) GetBalancesRequest() (*http.Request, error) {
+
+	req, err := http.NewRequest("GET", Build(ln, "balance", nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := ln.authorize(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (ln *Luno) ParseBalancesResponse(body []byte) ([]*Balance, error) {
+
+	var d struct {
+		Balance []struct {
+			Asset    string
+			Balance  string
+			Reserved string
+		}
+	}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return nil, err
+	}
+
+	var balances []*Balance
+	for _, b := range d.Balance {
+		asset := LookupAsset("luno", b.Asset)
+		if asset == nil {
+			continue
+		}
+		total, err := strconv.ParseFloat(b.Balance, 64)
+		if err != nil {
+			return nil, err
+		}
+		reserved, err := strconv.ParseFloat(b.Reserved, 64)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, &Balance{Asset: asset, Available: total - reserved, Reserved: reserved})
+	}
+	return balances, nil
+}
+
+func (ln *Luno) GetBalances(client http.Client) ([]*Balance, error) {
+
+	req, err := ln.GetBalancesRequest()
+	if err != nil {
+		return nil, err
+	}
+	b, err := doPrivate(ln, client, req)
+	if err != nil {
+		return nil, err
+	}
+	return ln.ParseBalancesResponse(b)
+}
+
+// PlaceOrderRequest builds a market order. Luno takes market buys in the
+// quote asset and market sells in the base asset.
+func (ln *Luno) PlaceOrderRequest(o *OrderRequest) (*http.Request, error) {
+
+	form := url.Values{"pair": {o.Pair.Code}}
+	volume := strconv.FormatFloat(o.Volume, 'f', -1, 64)
+
+	switch {
+	case o.Type == BUY && o.Quote:
+		form.Set("type", "BUY")
+		form.Set("counter_volume", volume)
+	case o.Type == SELL && !o.Quote:
+		form.Set("type", "SELL")
+		form.Set("base_volume", volume)
+	default:
+		return nil, fmt.Errorf("Luno market buys must be in the quote asset and sells in the base asset")
+	}
+
+	req, err := http.NewRequest("POST", Build(ln, "marketorder", nil), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := ln.authorize(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (ln *Luno) ParsePlaceOrderResponse(body []byte) (string, error) {
+
+	var d struct {
+		OrderID string `json:"order_id"`
+	}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return "", err
+	}
+	return d.OrderID, nil
+}
+
+func (ln *Luno) PlaceOrder(client http.Client, o *OrderRequest) (*Order, error) {
+
+	req, err := ln.PlaceOrderRequest(o)
+	if err != nil {
+		return nil, err
+	}
+	b, err := doPrivate(ln, client, req)
+	if err != nil {
+		return nil, err
+	}
+	id, err := ln.ParsePlaceOrderResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Order{ID: id, Pair: o.Pair, Type: o.Type, Time: time.Now()}, nil
+}
@@ -0,0 +1,150 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Market is a pair discovered from an exchange's API, with its trading
+// rules. Zero values mean the exchange didn't say: Kraken gives all of them,
+// Luno everything but fees, and ICE none.
+type Market struct {
+	Pair *Pair
+	// TickSize is the smallest price increment, in the quote asset.
+	TickSize float64
+	// LotSize is the smallest volume increment, in the base asset.
+	LotSize float64
+	// MinOrder is the smallest order volume, in the base asset.
+	MinOrder float64
+}
+
+// MarketDiscoverer is implemented by exchanges that can list their markets.
+// Markets whose assets are unknown are left out.
+type MarketDiscoverer interface {
+	GetMarketsRequest() (*http.Request, error)
+	ParseMarketsResponse(body io.Reader) ([]*Market, error)
+}
+
+// GetMarkets fetches the markets of an exchange implementing
+// MarketDiscoverer.
+func (f *Fetcher) GetMarkets(exc Exchange) ([]*Market, error) {
+
+	d, ok := exc.(MarketDiscoverer)
+	if !ok {
+		return nil, &Error{Kind: APIError, Exchange: exc.Meta().Slug, Err: fmt.Errorf("Market discovery not supported")}
+	}
+
+	var markets []*Market
+	err := f.policy(exc).Do(context.Background(), func() error {
+		req, err := d.GetMarketsRequest()
+		if err != nil {
+			return err
+		}
+		resp, err := f.do(exc, req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		markets, err = d.ParseMarketsResponse(resp.Body)
+		return classify(err, ParseError, exc.Meta().Slug)
+	})
+	return markets, err
+}
+
+func sortMarkets(markets []*Market) {
+	sort.Slice(markets, func(i, j int) bool { return markets[i].Pair.Code < markets[j].Pair.Code })
+}
+
+// MarketCache keeps the discovered markets of each exchange for TTL.
+type MarketCache struct {
+	Fetcher *Fetcher
+	TTL     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*marketEntry
+}
+
+// marketEntry is locked while its exchange is fetched, so that one slow
+// exchange doesn't hold up the others.
+type marketEntry struct {
+	mu      sync.Mutex
+	markets []*Market
+	fetched time.Time
+}
+
+func (c *MarketCache) entry(slug string) *marketEntry {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]*marketEntry{}
+	}
+	e, ok := c.entries[slug]
+	if !ok {
+		e = &marketEntry{}
+		c.entries[slug] = e
+	}
+	return e
+}
+
+// Markets returns the markets of exc, fetching them if they aren't cached or
+// are older than TTL. If a refresh fails the stale markets are returned with
+// the error.
+func (c *MarketCache) Markets(exc Exchange) ([]*Market, error) {
+
+	e := c.entry(exc.Meta().Slug)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.fetched.IsZero() && time.Since(e.fetched) < c.TTL {
+		return e.markets, nil
+	}
+	markets, err := c.Fetcher.GetMarkets(exc)
+	if err != nil {
+		return e.markets, err
+	}
+	e.markets, e.fetched = markets, time.Now()
+	return markets, nil
+}
+
+// Market returns the market of a pair code, or nil.
+func (c *MarketCache) Market(exc Exchange, code string) (*Market, error) {
+
+	markets, err := c.Markets(exc)
+	for _, m := range markets {
+		if m.Pair.Code == code {
+			return m, err
+		}
+	}
+	return nil, err
+}
+
+// Pairs returns the discovered pairs of exc. Exchanges without discovery,
+// or whose discovery fails with nothing cached, fall back to Meta().Pairs.
+// Allow-lists and fee overrides configured on the exchange still apply.
+func (c *MarketCache) Pairs(exc Exchange) []*Pair {
+
+	if _, ok := exc.(MarketDiscoverer); !ok {
+		return exc.Meta().Pairs
+	}
+	markets, _ := c.Markets(exc)
+	if len(markets) == 0 {
+		return exc.Meta().Pairs
+	}
+
+	pairs := make([]*Pair, len(markets))
+	for i, m := range markets {
+		p := *m.Pair
+		pairs[i] = &p
+	}
+	if e, ok := exc.(interface{ endpoint() *Endpoint }); ok {
+		pairs = e.endpoint().pairs(pairs)
+	}
+	return pairs
+}
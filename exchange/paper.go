@@ -0,0 +1,118 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Paper wraps an exchange to trade on paper. Order books are fetched from
+// the wrapped exchange as usual, but orders are filled with Trade.Simulate
+// against the live book, charged the pair's taker fee, and settled against
+// virtual balances. Paper implements Trader, so it can stand in for a live
+// exchange.
+type Paper struct {
+	Exchange
+
+	mu       sync.Mutex
+	balances map[*Asset]float64
+	orders   int
+}
+
+// NewPaper wraps e with the given starting balances.
+func NewPaper(e Exchange, balances map[*Asset]float64) *Paper {
+
+	p := &Paper{Exchange: e, balances: map[*Asset]float64{}}
+	for a, v := range balances {
+		p.balances[a] = v
+	}
+	return p
+}
+
+// Deposit adds amount of asset to the virtual balance.
+func (p *Paper) Deposit(asset *Asset, amount float64) {
+	p.mu.Lock()
+	p.balances[asset] += amount
+	p.mu.Unlock()
+}
+
+func (p *Paper) GetBalances(client http.Client) ([]*Balance, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var balances []*Balance
+	for a, v := range p.balances {
+		balances = append(balances, &Balance{Asset: a, Available: v})
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Asset.Slug < balances[j].Asset.Slug })
+	return balances, nil
+}
+
+// PlaceOrder fills o against the current book of the wrapped exchange.
+func (p *Paper) PlaceOrder(client http.Client, o *OrderRequest) (*Order, error) {
+
+	ob, err := GetOrderBook(client, p.Exchange, o.Pair)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Trade{OrderBook: ob.Prepare(), Amount: o.Volume, Type: o.Type, Quote: o.Quote, Pair: o.Pair}
+	res, err := t.Simulate()
+	if err != nil {
+		return nil, err
+	}
+
+	base, quote := o.Pair.Base, o.Pair.Quote
+
+	// the fee is charged in whichever asset the simulation solved for
+	var (
+		spend, receive          *Asset
+		spent, received, volume float64
+		value                   float64
+		feeAsset                *Asset
+	)
+	switch {
+	case o.Type == BUY && !o.Quote:
+		spend, spent = quote, res.Gross+res.Fee
+		receive, received = base, o.Volume
+		volume, value, feeAsset = o.Volume, res.Gross, quote
+	case o.Type == BUY && o.Quote:
+		spend, spent = quote, o.Volume
+		receive, received = base, res.Nett
+		volume, value, feeAsset = res.Gross, o.Volume, base
+	case o.Type == SELL && !o.Quote:
+		spend, spent = base, o.Volume
+		receive, received = quote, res.Nett
+		volume, value, feeAsset = o.Volume, res.Gross, quote
+	default:
+		spend, spent = base, res.Gross+res.Fee
+		receive, received = quote, o.Volume
+		volume, value, feeAsset = res.Gross, o.Volume, base
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.balances[spend] < spent {
+		return nil, fmt.Errorf("Insufficient %s balance: have %f, need %f", spend.Slug, p.balances[spend], spent)
+	}
+	p.balances[spend] -= spent
+	p.balances[receive] += received
+	p.orders++
+
+	return &Order{
+		ID:       "paper-" + strconv.Itoa(p.orders),
+		Pair:     o.Pair,
+		Type:     o.Type,
+		Time:     time.Now(),
+		Filled:   true,
+		Volume:   volume,
+		Value:    value,
+		Fee:      res.Fee,
+		FeeAsset: feeAsset,
+	}, nil
+}
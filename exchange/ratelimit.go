@@ -0,0 +1,151 @@
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RateLimit is an exchange's request budget, modelled as a token bucket:
+// up to Burst requests can be made at once and the budget refills at Rate
+// requests per second. Kraken's decaying call counter and Luno's requests
+// per second both fit this shape.
+type RateLimit struct {
+	Burst float64
+	Rate  float64
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// refill brings the bucket up to date.
+func (b *bucket) refill(now time.Time) {
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	}
+	if b.tokens > b.limit.Burst {
+		b.tokens = b.limit.Burst
+	}
+	b.last = now
+}
+
+// Scheduler spaces out requests so that each exchange stays within the
+// RateLimit declared in its Meta. Requests over budget are queued, in
+// arrival order, rather than failed. A Scheduler is shared by everything
+// talking to the same exchanges, usually through Transport.
+type Scheduler struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	hosts   map[string]string
+}
+
+// NewScheduler returns a scheduler for the given exchanges. Exchanges
+// without a RateLimit are not limited.
+func NewScheduler(exchanges ...Exchange) *Scheduler {
+
+	s := &Scheduler{buckets: map[string]*bucket{}, hosts: map[string]string{}}
+	for _, e := range exchanges {
+		m := e.Meta()
+		if u, err := url.Parse(m.API); err == nil {
+			s.hosts[u.Host] = m.Slug
+		}
+		if m.RateLimit != nil {
+			s.SetLimit(m.Slug, *m.RateLimit)
+		}
+	}
+	return s
+}
+
+// SetLimit overrides the limit of an exchange.
+func (s *Scheduler) SetLimit(slug string, limit RateLimit) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[slug] = &bucket{limit: limit, tokens: limit.Burst}
+}
+
+// Wait blocks until the exchange has budget for one more request, or ctx
+// is done.
+func (s *Scheduler) Wait(ctx context.Context, slug string) error {
+
+	s.mu.Lock()
+	b, ok := s.buckets[slug]
+	if !ok || b.limit.Rate <= 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	b.refill(now)
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.limit.Rate * float64(time.Second))
+	}
+	s.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reservation back to the requests queued behind
+		s.mu.Lock()
+		b.refill(time.Now())
+		b.tokens++
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Remaining returns the number of requests an exchange can make right now
+// without waiting. Negative values count the requests already queued. The
+// second result is false for exchanges that are not limited.
+func (s *Scheduler) Remaining(slug string) (float64, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[slug]
+	if !ok {
+		return 0, false
+	}
+	b.refill(time.Now())
+	return b.tokens, true
+}
+
+// Transport returns a RoundTripper that waits for budget before passing a
+// request to upstream. Requests are matched to exchanges by the host of
+// their API URL; requests to other hosts pass straight through.
+func (s *Scheduler) Transport(upstream http.RoundTripper) http.RoundTripper {
+	return &limitedTransport{s, upstream}
+}
+
+type limitedTransport struct {
+	scheduler *Scheduler
+	upstream  http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	t.scheduler.mu.Lock()
+	slug, ok := t.scheduler.hosts[r.URL.Host]
+	t.scheduler.mu.Unlock()
+
+	if ok {
+		if err := t.scheduler.Wait(r.Context(), slug); err != nil {
+			return nil, err
+		}
+	}
+	return t.upstream.RoundTrip(r)
+}
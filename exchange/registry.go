@@ -0,0 +1,246 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jacoduplessis/crypto/credentials"
+)
+
+// Options configure an exchange created with New.
+type Options struct {
+	APIKey    credentials.Secret
+	APISecret credentials.Secret
+	// Credentials, if set, is used instead of APIKey and APISecret.
+	Credentials credentials.Provider
+	// BaseURL replaces the API URL in Meta, for instance to point at a
+	// proxy or a mock server.
+	BaseURL string
+	// Client, if set, is used for this exchange's public and private
+	// requests instead of the Fetcher's client or the one passed to Trader
+	// methods. If that client's transport is a Scheduler's, requests still
+	// wait on the same scheduler.
+	Client *http.Client
+	// Pairs, if set, limits Meta().Pairs to these codes.
+	Pairs []string
+	// Fees overrides the fees of pairs by code, or of all pairs with "*".
+	Fees map[string]Fee
+	// Spread, if set, replaces the spread rate providers such as ECB widen
+	// their rates by; see Rate.OrderBook.
+	Spread *float64
+}
+
+// Fee overrides the fees of a pair. Nil fields keep the default.
+type Fee struct {
+	Taker *float64 `json:"taker"`
+	Maker *float64 `json:"maker"`
+}
+
+// Endpoint holds the settings every exchange embeds.
+type Endpoint struct {
+	BaseURL string
+	Client  *http.Client
+	Pairs   []string
+	Fees    map[string]Fee
+	Spread  *float64
+}
+
+func (o Options) endpoint() Endpoint {
+	return Endpoint{BaseURL: o.BaseURL, Client: o.Client, Pairs: o.Pairs, Fees: o.Fees, Spread: o.Spread}
+}
+
+func (e *Endpoint) endpoint() *Endpoint {
+	return e
+}
+
+// api returns BaseURL, or def if it isn't set.
+func (e *Endpoint) api(def string) string {
+
+	if e.BaseURL != "" {
+		return e.BaseURL
+	}
+	return def
+}
+
+// spread returns Spread, or def if it isn't set.
+func (e *Endpoint) spread(def float64) float64 {
+
+	if e.Spread != nil {
+		return *e.Spread
+	}
+	return def
+}
+
+// pairs applies the allow-list and fee overrides to an exchange's pairs.
+func (e *Endpoint) pairs(all []*Pair) []*Pair {
+
+	if len(e.Pairs) == 0 && len(e.Fees) == 0 {
+		return all
+	}
+
+	var pairs []*Pair
+	for _, p := range all {
+		if len(e.Pairs) > 0 && !containsFold(e.Pairs, p.Code) {
+			continue
+		}
+		for _, code := range []string{"*", p.Code} {
+			f, ok := e.Fees[code]
+			if !ok {
+				continue
+			}
+			if f.Taker != nil {
+				p.TakerFee = *f.Taker
+			}
+			if f.Maker != nil {
+				p.MakerFee = *f.Maker
+			}
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientFor returns the client configured on exc, or client. If client's
+// transport is a Scheduler's, the configured client is sent through the
+// same scheduler, so it stays within the exchange's rate limit.
+func clientFor(exc Exchange, client http.Client) http.Client {
+
+	e, ok := exc.(interface{ endpoint() *Endpoint })
+	if !ok || e.endpoint().Client == nil {
+		return client
+	}
+	own := *e.endpoint().Client
+	lt, ok := client.Transport.(*limitedTransport)
+	if _, limited := own.Transport.(*limitedTransport); ok && !limited {
+		upstream := own.Transport
+		if upstream == nil {
+			upstream = http.DefaultTransport
+		}
+		own.Transport = lt.scheduler.Transport(upstream)
+	}
+	return own
+}
+
+// Constructor creates an exchange with the given options.
+type Constructor func(o Options) Exchange
+
+var (
+	registryMu   sync.RWMutex
+	constructors = map[string]Constructor{}
+)
+
+// Register makes an exchange available to New under its Meta().Slug. It
+// panics if the slug is already registered.
+func Register(c Constructor) {
+
+	slug := c(Options{}).Meta().Slug
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := constructors[slug]; ok {
+		panic("exchange: Register called twice for " + slug)
+	}
+	constructors[slug] = c
+}
+
+// Slugs returns the slugs of all registered exchanges, sorted.
+func Slugs() []string {
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var slugs []string
+	for slug := range constructors {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+// New creates the exchange registered under slug.
+func New(slug string, o Options) (Exchange, error) {
+
+	registryMu.RLock()
+	c, ok := constructors[slug]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown exchange %q", slug)
+	}
+	return c(o), nil
+}
+
+// All creates every registered exchange with default options.
+func All() []Exchange {
+
+	var all []Exchange
+	for _, slug := range Slugs() {
+		e, _ := New(slug, Options{})
+		all = append(all, e)
+	}
+	return all
+}
+
+// FindPair returns the pair of e with the given code, compared
+// case-insensitively, or nil.
+func FindPair(e Exchange, code string) *Pair {
+
+	for _, p := range e.Meta().Pairs {
+		if strings.EqualFold(p.Code, code) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Lookup resolves a reference of the form slug:code, such as
+// kraken:XXBTZEUR, to a new exchange with default options and its pair.
+func Lookup(ref string) (Exchange, *Pair, error) {
+
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("%q must be exchange:pair", ref)
+	}
+	e, err := New(parts[0], Options{})
+	if err != nil {
+		return nil, nil, err
+	}
+	p := FindPair(e, parts[1])
+	if p == nil {
+		return nil, nil, fmt.Errorf("%s has no pair %q", parts[0], parts[1])
+	}
+	return e, p, nil
+}
+
+// Listing is a pair on an exchange.
+type Listing struct {
+	Exchange Exchange
+	Pair     *Pair
+}
+
+// Listings returns every registered exchange trading a and b against each
+// other, whichever of the two is the base asset.
+func Listings(a, b *Asset) []*Listing {
+
+	var listings []*Listing
+	for _, e := range All() {
+		for _, p := range e.Meta().Pairs {
+			if (p.Base == a && p.Quote == b) || (p.Base == b && p.Quote == a) {
+				listings = append(listings, &Listing{Exchange: e, Pair: p})
+			}
+		}
+	}
+	return listings
+}
@@ -0,0 +1,87 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy retries temporary failures with jittered exponential backoff.
+// The n-th retry waits a random duration up to Base * 2^(n-1), capped at
+// Max, or longer if the exchange sent a Retry-After.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first.
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+	// MaxRetryAfter caps the wait a Retry-After can ask for, or
+	// DefaultMaxRetryAfter if zero, so that one header can't stall a fetch.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultMaxRetryAfter is the longest a Retry-After is honoured by default.
+const DefaultMaxRetryAfter = time.Minute
+
+// DefaultRetryPolicy tries three times, starting at half a second.
+var DefaultRetryPolicy = &RetryPolicy{Attempts: 3, Base: 500 * time.Millisecond, Max: 10 * time.Second}
+
+// Retryable reports whether err is worth retrying: transport failures,
+// rate limiting, maintenance and server errors are; API and parse errors
+// are not.
+func Retryable(err error) bool {
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Temporary()
+	}
+	return false
+}
+
+// Backoff returns how long to wait before retry number n, counting from 1,
+// after err.
+func (p *RetryPolicy) Backoff(n int, err error) time.Duration {
+
+	d := p.Max
+	if n < 32 && p.Base<<uint(n-1) < p.Max {
+		d = p.Base << uint(n-1)
+	}
+	if d > 0 {
+		d = time.Duration(rand.Int63n(int64(d) + 1))
+	}
+
+	var e *Error
+	if errors.As(err, &e) && e.RetryAfter > d {
+		d = e.RetryAfter
+		max := p.MaxRetryAfter
+		if max <= 0 {
+			max = DefaultMaxRetryAfter
+		}
+		if d > max {
+			d = max
+		}
+	}
+	return d
+}
+
+// Do calls fn until it succeeds, fails with an error that is not Retryable,
+// the attempts run out, or ctx is done while waiting to retry. The last
+// error is returned. A nil policy calls fn once.
+func (p *RetryPolicy) Do(ctx context.Context, fn func() error) error {
+
+	err := fn()
+	if p == nil {
+		return err
+	}
+	for n := 1; n < p.Attempts && Retryable(err); n++ {
+		t := time.NewTimer(p.Backoff(n, err))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		err = fn()
+	}
+	return err
+}
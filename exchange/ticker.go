@@ -0,0 +1,111 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Ticker is the top of book and recent activity of a pair.
+type Ticker struct {
+	Pair     *Pair
+	Exchange Exchange
+	Time     time.Time
+	Bid      float64
+	Ask      float64
+	Last     float64
+	// Volume is the base asset traded over the last 24 hours.
+	Volume float64
+}
+
+// TickerBatcher is implemented by exchanges that can return the tickers of
+// several pairs in one request.
+type TickerBatcher interface {
+	GetTickersRequest(pairs []*Pair) (*http.Request, error)
+	// ParseTickersResponse returns a ticker for each of pairs, matched by
+	// pair code, in the same order.
+	ParseTickersResponse(body io.Reader, pairs []*Pair) ([]*Ticker, error)
+}
+
+// GetTickers fetches the tickers of pairs on an exchange, or of all its
+// pairs if none are given. Exchanges implementing TickerBatcher are asked in
+// one request; for others the order books are fetched and only the top of
+// book is filled in. The request and any retries are given up once ctx is
+// done.
+func (f *Fetcher) GetTickers(ctx context.Context, exc Exchange, pairs ...*Pair) ([]*Ticker, error) {
+
+	if len(pairs) == 0 {
+		pairs = exc.Meta().Pairs
+	}
+
+	b, ok := exc.(TickerBatcher)
+	if !ok {
+		return f.tickersFromBooks(ctx, exc, pairs)
+	}
+
+	var tickers []*Ticker
+	err := f.policy(exc).Do(ctx, func() error {
+		req, err := b.GetTickersRequest(pairs)
+		if err != nil {
+			return err
+		}
+		resp, err := f.do(exc, req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		tickers, err = b.ParseTickersResponse(resp.Body, pairs)
+		return classify(err, ParseError, exc.Meta().Slug)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tickers {
+		t.Exchange = exc
+	}
+	return tickers, nil
+}
+
+func (f *Fetcher) tickersFromBooks(ctx context.Context, exc Exchange, pairs []*Pair) ([]*Ticker, error) {
+
+	tickers := make([]*Ticker, len(pairs))
+	for i, p := range pairs {
+		ob, err := f.GetOrderBookContext(ctx, exc, p)
+		if err != nil {
+			return nil, err
+		}
+		t := &Ticker{Pair: p, Exchange: exc, Time: time.Now()}
+		if len(ob.Bids) > 0 {
+			t.Bid = ob.Bids[0][0]
+		}
+		if len(ob.Asks) > 0 {
+			t.Ask = ob.Asks[0][0]
+		}
+		tickers[i] = t
+	}
+	return tickers, nil
+}
+
+// matchPairs orders the values found under each pair code, failing if any
+// pair is missing. Codes are compared case-insensitively.
+func matchPairs(pairs []*Pair, found map[string]*Ticker) ([]*Ticker, error) {
+
+	upper := map[string]*Ticker{}
+	for code, t := range found {
+		upper[strings.ToUpper(code)] = t
+	}
+
+	tickers := make([]*Ticker, len(pairs))
+	for i, p := range pairs {
+		t, ok := upper[strings.ToUpper(p.Code)]
+		if !ok {
+			return nil, fmt.Errorf("No ticker for %s in response", p.Code)
+		}
+		t.Pair = p
+		tickers[i] = t
+	}
+	return tickers, nil
+}
@@ -0,0 +1,81 @@
+package exchange
+
+import (
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jacoduplessis/crypto/credentials"
+)
+
+// Balance is the amount of an asset held in an account.
+type Balance struct {
+	Asset     *Asset
+	Available float64
+	Reserved  float64
+}
+
+// OrderRequest is a market order. Volume is in the base asset, or in the
+// quote asset when Quote is set, as with Trade.
+type OrderRequest struct {
+	Pair   *Pair
+	Type   OrderType
+	Volume float64
+	Quote  bool
+}
+
+// Order is a placed order. Live exchanges only report the ID when the order
+// is accepted; the fill fields are set by exchanges that fill immediately,
+// such as Paper.
+type Order struct {
+	ID       string
+	Pair     *Pair
+	Type     OrderType
+	Time     time.Time
+	Filled   bool
+	Volume   float64
+	Value    float64
+	Fee      float64
+	FeeAsset *Asset
+}
+
+// Trader is an exchange that can trade on an account.
+type Trader interface {
+	Exchange
+	GetBalances(client http.Client) ([]*Balance, error)
+	PlaceOrder(client http.Client, o *OrderRequest) (*Order, error)
+}
+
+// credentialsOf returns the credentials of p if it is set, or else the
+// static key and secret.
+func credentialsOf(key, secret credentials.Secret, p credentials.Provider) (credentials.Credentials, error) {
+
+	if p != nil {
+		return p.Credentials()
+	}
+	return credentials.Credentials{Key: key, Secret: secret}, nil
+}
+
+// doPrivate sends an authenticated request with the client configured on
+// exc, or client, and checks the status code, returning the body on
+// success. Failures are returned as *Error but never retried, as placing an
+// order twice is worse than not placing it.
+func doPrivate(exc Exchange, client http.Client, req *http.Request) ([]byte, error) {
+
+	slug := exc.Meta().Slug
+	client = clientFor(exc, client)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, classify(transportError(err), TransportError, slug)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, classify(transportError(err), TransportError, slug)
+	}
+	if err := statusError(resp, b); err != nil {
+		return nil, classify(err, StatusError, slug)
+	}
+	return b, nil
+}
@@ -0,0 +1,237 @@
+// Package fixture records HTTP responses to files and replays them, so
+// parsers can be tested against saved responses without network access.
+//
+// A Transport in record mode passes requests upstream and saves each
+// response body in its directory, with an index.json describing the
+// requests. In replay mode it answers from those files and fails requests
+// it has no fixture for. Entries may also be written by hand; only those
+// with a Recorded time came from the live service.
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry describes a recorded response.
+type Entry struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	// ContentType is the only header kept, as others may identify the
+	// account or session.
+	ContentType string `json:"content_type,omitempty"`
+	// File is the name of the body file, relative to the directory.
+	File string `json:"file"`
+	// Recorded is when the response was recorded, or nil if the entry was
+	// written by hand.
+	Recorded *time.Time `json:"recorded,omitempty"`
+}
+
+// MissingError is returned when replaying a request without a fixture.
+type MissingError struct {
+	Dir    string
+	Method string
+	URL    string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("No fixture in %s for %s %s, record it to add one", e.Dir, e.Method, e.URL)
+}
+
+// Transport records or replays responses in Dir.
+type Transport struct {
+	Dir string
+	// Record makes the transport send requests with Upstream, or
+	// http.DefaultTransport if nil, and save the responses.
+	Record   bool
+	Upstream http.RoundTripper
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// indexFile lists the entries of a directory.
+const indexFile = "index.json"
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries == nil {
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+	}
+	if t.Record {
+		return t.record(r)
+	}
+
+	e, ok := t.entries[key(r.Method, r.URL)]
+	if !ok {
+		return nil, &MissingError{t.Dir, r.Method, r.URL.String()}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(t.Dir, e.File))
+	if err != nil {
+		return nil, err
+	}
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       r,
+	}
+	if e.ContentType != "" {
+		resp.Header.Set("Content-Type", e.ContentType)
+	}
+	return resp, nil
+}
+
+// Entries returns the recorded entries, sorted by URL.
+func (t *Transport) Entries() ([]*Entry, error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries == nil {
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+	}
+	return t.sorted(), nil
+}
+
+func (t *Transport) sorted() []*Entry {
+
+	var entries []*Entry
+	for _, e := range t.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].URL != entries[j].URL {
+			return entries[i].URL < entries[j].URL
+		}
+		return entries[i].Method < entries[j].Method
+	})
+	return entries
+}
+
+func (t *Transport) load() error {
+
+	t.entries = map[string]*Entry{}
+	b, err := ioutil.ReadFile(filepath.Join(t.Dir, indexFile))
+	if os.IsNotExist(err) && t.Record {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Join(t.Dir, indexFile), err)
+	}
+	for _, e := range entries {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Join(t.Dir, indexFile), err)
+		}
+		t.entries[key(e.Method, u)] = e
+	}
+	return nil
+}
+
+func (t *Transport) record(r *http.Request) (*http.Response, error) {
+
+	upstream := t.Upstream
+	if upstream == nil {
+		upstream = http.DefaultTransport
+	}
+	resp, err := upstream.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	e := &Entry{
+		Method:      r.Method,
+		URL:         r.URL.String(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	e.Recorded = &now
+	e.File = fileName(r, e.ContentType)
+
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(t.Dir, e.File), b, 0644); err != nil {
+		return nil, err
+	}
+	t.entries[key(r.Method, r.URL)] = e
+	index, err := json.MarshalIndent(t.sorted(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(t.Dir, indexFile), append(index, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// key identifies a request regardless of the order of its query.
+func key(method string, u *url.URL) string {
+	return method + " " + u.Scheme + "://" + u.Host + u.EscapedPath() + "?" + u.Query().Encode()
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// fileName names the body file after the request, such as
+// get-0-public-Depth-pair-XXBTZEUR.json.
+func fileName(r *http.Request, contentType string) string {
+
+	name := strings.Trim(r.URL.Path, "/")
+	if q := r.URL.Query().Encode(); q != "" {
+		name += "-" + q
+	}
+	name = strings.Trim(unsafeChars.ReplaceAllString(strings.ToLower(r.Method)+"-"+name, "-"), "-")
+
+	ext := ".txt"
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch {
+		case strings.HasSuffix(mt, "json"):
+			ext = ".json"
+		case strings.HasSuffix(mt, "html"):
+			ext = ".html"
+		case strings.HasSuffix(mt, "xml"):
+			ext = ".xml"
+		}
+	}
+	if strings.HasSuffix(name, ext) {
+		return name
+	}
+	return name + ext
+}
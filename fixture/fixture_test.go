@@ -0,0 +1,89 @@
+package fixture
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte(`{"pair":"` + r.URL.Query().Get("pair") + `"}`))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	get := func(tr http.RoundTripper, url string) (string, error) {
+		resp, err := (&http.Client{Transport: tr}).Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q", ct)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	rec := &Transport{Dir: dir, Record: true}
+	for _, u := range []string{ts.URL + "/0/public/Depth?pair=XXBTZEUR&count=10", ts.URL + "/tickers"} {
+		if _, err := get(rec, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "get-0-public-Depth-count-10-pair-XXBTZEUR.json")); err != nil {
+		t.Error(err)
+	}
+
+	// a new transport reads the index, and the query may be in any order
+	play := &Transport{Dir: dir}
+	body, err := get(play, ts.URL+"/0/public/Depth?count=10&pair=XXBTZEUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"pair":"XXBTZEUR"}` {
+		t.Errorf("replayed %s", body)
+	}
+	if calls != 2 {
+		t.Errorf("%d calls upstream, want 2", calls)
+	}
+
+	entries, err := play.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].URL != ts.URL+"/tickers" || entries[1].Status != 200 {
+		t.Errorf("entries %+v", entries)
+	}
+	for _, e := range entries {
+		if e.Recorded == nil || time.Since(*e.Recorded) > time.Minute {
+			t.Errorf("%s recorded at %v", e.URL, e.Recorded)
+		}
+	}
+
+	_, err = get(play, ts.URL+"/0/public/Depth?pair=XXRPZEUR")
+	var missing *MissingError
+	if !errors.As(err, &missing) {
+		t.Errorf("got %v, want a *MissingError", err)
+	}
+
+	if _, err := get(&Transport{Dir: filepath.Join(dir, "none")}, ts.URL+"/tickers"); err == nil {
+		t.Error("replayed without an index")
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// Collector records fetch and market metrics. It implements
+// exchange.Observer, and its Challenge method fits scraper.Transport's
+// Challenged hook.
+type Collector struct {
+	fetchSeconds  Histogram
+	fetchErrors   Counter
+	parseFailures Counter
+	challenges    Counter
+	depth         Gauge
+	bestBid       Gauge
+	bestAsk       Gauge
+	spread        Gauge
+}
+
+// NewCollector creates the metrics in r.
+func NewCollector(r Registry) *Collector {
+
+	return &Collector{
+		fetchSeconds:  r.Histogram("crypto_fetch_duration_seconds", "Order book fetch latency.", DefaultBuckets, "exchange", "pair"),
+		fetchErrors:   r.Counter("crypto_fetch_errors_total", "Failed order book fetches.", "exchange", "pair"),
+		parseFailures: r.Counter("crypto_parse_failures_total", "Order book responses that could not be parsed.", "exchange", "pair"),
+		challenges:    r.Counter("crypto_cloudflare_challenges_total", "Cloudflare challenges met by the scraper transport.", "host"),
+		depth:         r.Gauge("crypto_book_depth", "Number of levels in the latest order book.", "exchange", "pair", "side"),
+		bestBid:       r.Gauge("crypto_best_bid", "Best bid price of the latest order book.", "exchange", "pair"),
+		bestAsk:       r.Gauge("crypto_best_ask", "Best ask price of the latest order book.", "exchange", "pair"),
+		spread:        r.Gauge("crypto_spread", "Best ask less best bid of the latest order book.", "exchange", "pair"),
+	}
+}
+
+func (c *Collector) Fetched(exc exchange.Exchange, pair *exchange.Pair, d time.Duration, err error) {
+
+	slug := exc.Meta().Slug
+	c.fetchSeconds.Observe(d.Seconds(), slug, pair.Code)
+	if err != nil {
+		c.fetchErrors.Add(1, slug, pair.Code)
+	}
+}
+
+func (c *Collector) ParseFailed(exc exchange.Exchange, pair *exchange.Pair, err error) {
+	c.parseFailures.Add(1, exc.Meta().Slug, pair.Code)
+}
+
+// Book records the depth and top of book. Price gauges are left untouched
+// when a side is empty.
+func (c *Collector) Book(ob *exchange.OrderBook) {
+
+	slug, code := ob.Exchange.Meta().Slug, ob.Pair.Code
+	c.depth.Set(float64(len(ob.Bids)), slug, code, "bid")
+	c.depth.Set(float64(len(ob.Asks)), slug, code, "ask")
+
+	if len(ob.Bids) > 0 {
+		c.bestBid.Set(ob.Bids[0][0], slug, code)
+	}
+	if len(ob.Asks) > 0 {
+		c.bestAsk.Set(ob.Asks[0][0], slug, code)
+	}
+	if len(ob.Bids) > 0 && len(ob.Asks) > 0 {
+		c.spread.Set(ob.Asks[0][0]-ob.Bids[0][0], slug, code)
+	}
+}
+
+// Challenge counts a Cloudflare challenge for host.
+func (c *Collector) Challenge(host string) {
+	c.challenges.Add(1, host)
+}
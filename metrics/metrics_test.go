@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+func TestCollector(t *testing.T) {
+
+	r := NewTextRegistry()
+	c := NewCollector(r)
+
+	luno := &exchange.Luno{}
+	pair := luno.Meta().Pairs[0]
+
+	c.Fetched(luno, pair, 300*time.Millisecond, nil)
+	c.Fetched(luno, pair, 2*time.Second, errors.New("timeout"))
+	c.ParseFailed(luno, pair, errors.New("bad json"))
+	c.Challenge("www.altcointrader.co.za")
+	c.Book(&exchange.OrderBook{
+		Exchange: luno,
+		Pair:     pair,
+		Bids:     [][2]float64{{99, 1}, {98, 1}},
+		Asks:     [][2]float64{{101, 1}},
+	})
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE crypto_fetch_duration_seconds histogram\n",
+		`crypto_fetch_duration_seconds_bucket{exchange="luno",pair="XBTZAR",le="0.5"} 1` + "\n",
+		`crypto_fetch_duration_seconds_bucket{exchange="luno",pair="XBTZAR",le="+Inf"} 2` + "\n",
+		`crypto_fetch_duration_seconds_sum{exchange="luno",pair="XBTZAR"} 2.3` + "\n",
+		`crypto_fetch_errors_total{exchange="luno",pair="XBTZAR"} 1` + "\n",
+		`crypto_parse_failures_total{exchange="luno",pair="XBTZAR"} 1` + "\n",
+		`crypto_cloudflare_challenges_total{host="www.altcointrader.co.za"} 1` + "\n",
+		`crypto_book_depth{exchange="luno",pair="XBTZAR",side="bid"} 2` + "\n",
+		`crypto_spread{exchange="luno",pair="XBTZAR"} 2` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Missing %q in output:\n%s", want, out)
+		}
+	}
+}
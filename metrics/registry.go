@@ -0,0 +1,249 @@
+// Package metrics collects fetch, parser and market metrics and exposes
+// them in the Prometheus text format.
+//
+// Metrics are created through a Registry passed in by the caller, so there
+// is no global state. TextRegistry is a small self-contained implementation;
+// other metrics libraries can be plugged in by implementing Registry.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry creates labelled metrics.
+type Registry interface {
+	Counter(name, help string, labels ...string) Counter
+	Gauge(name, help string, labels ...string) Gauge
+	Histogram(name, help string, buckets []float64, labels ...string) Histogram
+}
+
+// Counter is a monotonically increasing value per label set.
+type Counter interface {
+	Add(v float64, labelValues ...string)
+}
+
+// Gauge is a value per label set that can go up and down.
+type Gauge interface {
+	Set(v float64, labelValues ...string)
+}
+
+// Histogram counts observations in buckets per label set.
+type Histogram interface {
+	Observe(v float64, labelValues ...string)
+}
+
+// DefaultBuckets suit request latencies in seconds.
+var DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// TextRegistry keeps metrics in memory and writes them in the Prometheus
+// text exposition format. It implements http.Handler.
+type TextRegistry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewTextRegistry returns an empty registry.
+func NewTextRegistry() *TextRegistry {
+	return &TextRegistry{families: map[string]*family{}}
+}
+
+type family struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func (r *TextRegistry) family(name, help, kind string, buckets []float64, labels []string) *family {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind {
+			panic(fmt.Sprintf("metrics: %s registered as %s and %s", name, f.kind, kind))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) get(labelValues []string) *series {
+
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	k := strings.Join(labelValues, "\xff")
+	s, ok := f.series[k]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[k] = s
+	}
+	return s
+}
+
+func (f *family) Add(v float64, labelValues ...string) {
+	f.mu.Lock()
+	f.get(labelValues).value += v
+	f.mu.Unlock()
+}
+
+func (f *family) Set(v float64, labelValues ...string) {
+	f.mu.Lock()
+	f.get(labelValues).value = v
+	f.mu.Unlock()
+}
+
+func (f *family) Observe(v float64, labelValues ...string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.get(labelValues)
+	for i, b := range f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (r *TextRegistry) Counter(name, help string, labels ...string) Counter {
+	return r.family(name, help, "counter", nil, labels)
+}
+
+func (r *TextRegistry) Gauge(name, help string, labels ...string) Gauge {
+	return r.family(name, help, "gauge", nil, labels)
+}
+
+func (r *TextRegistry) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return r.family(name, help, "histogram", buckets, labels)
+}
+
+// WriteText writes every metric in the Prometheus text format, sorted by
+// name and labels.
+func (r *TextRegistry) WriteText(w io.Writer) error {
+
+	r.mu.Lock()
+	var families []*family
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	for _, f := range families {
+		if err := f.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *family) write(w io.Writer) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		for i, b := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labels, s.labelValues, ""), formatFloat(s.sum))
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labels, s.labelValues, ""), s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP writes the metrics for a Prometheus scrape.
+func (r *TextRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+func labelString(names, values []string, le string) string {
+
+	var pairs []string
+	for i, n := range names {
+		pairs = append(pairs, n+`="`+escapeLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,64 @@
+package mock
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// iceResponse is ICE's envelope, whose errors flag is set on failure.
+type iceResponse struct {
+	Errors   bool        `json:"errors"`
+	Response interface{} `json:"response"`
+}
+
+type iceLevel struct {
+	Price  float64 `json:"price"`
+	Amount float64 `json:"amount"`
+	Value  float64 `json:"value"`
+}
+
+func iceLevels(levels [][2]float64) []iceLevel {
+
+	out := []iceLevel{}
+	for _, l := range levels {
+		out = append(out, iceLevel{l[0], l[1], l[0] * l[1]})
+	}
+	return out
+}
+
+// ice serves the public endpoints; the exchange package doesn't trade on
+// ICE.
+func (s *Server) ice(w http.ResponseWriter, r *http.Request, endpoint string) {
+
+	switch endpoint {
+	case "orderbook/info":
+		b, ok := s.serveBook(r.URL.Query().Get("pair_id"))
+		if !ok {
+			writeJSON(w, http.StatusOK, iceResponse{Errors: true, Response: map[string]string{"message": "Pair not found"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, iceResponse{Response: map[string]interface{}{
+			"entities": map[string]interface{}{"bids": iceLevels(b.Bids), "asks": iceLevels(b.Asks)},
+		}})
+
+	case "pair/list":
+		s.mu.Lock()
+		var entities []map[string]interface{}
+		for _, p := range s.pairs {
+			id, err := strconv.Atoi(p.Code)
+			if err != nil {
+				continue
+			}
+			entities = append(entities, map[string]interface{}{
+				"pair_id":   id,
+				"pair_name": strings.ToLower(p.Base + "/" + p.Quote),
+			})
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, iceResponse{Response: map[string]interface{}{"entities": entities}})
+
+	default:
+		writeJSON(w, http.StatusNotFound, iceResponse{Errors: true, Response: map[string]string{"message": "Not found"}})
+	}
+}
@@ -0,0 +1,218 @@
+package mock
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// krakenResponse is Kraken's envelope: errors are reported in the array
+// with a 200.
+type krakenResponse struct {
+	Error  []string    `json:"error"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+func krakenLevels(levels [][2]float64, now time.Time) [][]interface{} {
+
+	out := [][]interface{}{}
+	for _, l := range levels {
+		out = append(out, []interface{}{formatFloat(l[0]), formatFloat(l[1]), now.Unix()})
+	}
+	return out
+}
+
+func (s *Server) kraken(w http.ResponseWriter, r *http.Request, endpoint string) {
+
+	ok := func(result interface{}) {
+		writeJSON(w, http.StatusOK, krakenResponse{Error: []string{}, Result: result})
+	}
+	fail := func(codes ...string) {
+		writeJSON(w, http.StatusOK, krakenResponse{Error: codes})
+	}
+
+	switch endpoint {
+	case "public/Depth":
+		code := r.URL.Query().Get("pair")
+		b, found := s.serveBook(code)
+		if !found {
+			fail("EQuery:Unknown asset pair")
+			return
+		}
+		now := time.Now()
+		ok(map[string]interface{}{
+			code: map[string]interface{}{"bids": krakenLevels(b.Bids, now), "asks": krakenLevels(b.Asks, now)},
+		})
+
+	case "public/Ticker":
+		var codes []string
+		if q := r.URL.Query().Get("pair"); q != "" {
+			codes = strings.Split(q, ",")
+		}
+		all := map[string]*ticker{}
+		for _, t := range s.tickers() {
+			all[t.Code] = t
+		}
+		result := map[string]interface{}{}
+		for code, t := range all {
+			if len(codes) > 0 && !contains(codes, code) {
+				continue
+			}
+			result[code] = map[string]interface{}{
+				"a": []string{formatFloat(t.Ask), "1", "1.000"},
+				"b": []string{formatFloat(t.Bid), "1", "1.000"},
+				"c": []string{formatFloat(t.Last), "0"},
+				"v": []string{formatFloat(t.Volume), formatFloat(t.Volume)},
+			}
+		}
+		for _, code := range codes {
+			if _, found := all[code]; !found {
+				fail("EQuery:Unknown asset pair")
+				return
+			}
+		}
+		ok(result)
+
+	case "public/AssetPairs":
+		s.mu.Lock()
+		result := map[string]interface{}{}
+		for _, p := range s.pairs {
+			result[p.Code] = map[string]interface{}{
+				"altname":       p.Code,
+				"aclass_base":   "currency",
+				"base":          p.Base,
+				"aclass_quote":  "currency",
+				"quote":         p.Quote,
+				"lot":           "unit",
+				"pair_decimals": 5,
+				"lot_decimals":  8,
+				"fees":          [][2]float64{{0, 0.26}},
+				"fees_maker":    [][2]float64{{0, 0.16}},
+				"ordermin":      "0.0001",
+			}
+		}
+		s.mu.Unlock()
+		ok(result)
+
+	case "private/Balance", "private/AddOrder":
+		form, code := s.krakenAuthorize(r)
+		if code != "" {
+			fail(code)
+			return
+		}
+		if endpoint == "private/Balance" {
+			s.mu.Lock()
+			result := map[string]string{}
+			for asset, amount := range s.balances {
+				result[asset] = strconv.FormatFloat(amount, 'f', 10, 64)
+			}
+			s.mu.Unlock()
+			ok(result)
+			return
+		}
+
+		if form.Get("ordertype") != "market" {
+			fail("EGeneral:Invalid arguments:ordertype")
+			return
+		}
+		t := form.Get("type")
+		if t != "buy" && t != "sell" {
+			fail("EGeneral:Invalid arguments:type")
+			return
+		}
+		volume, err := strconv.ParseFloat(form.Get("volume"), 64)
+		if err != nil {
+			fail("EGeneral:Invalid arguments:volume")
+			return
+		}
+		// viqc gives the volume in the quote asset
+		quote := contains(strings.Split(form.Get("oflags"), ","), "viqc")
+		o, err := s.fill(form.Get("pair"), t == "buy", volume, quote)
+		switch err {
+		case nil:
+			ok(map[string]interface{}{
+				"descr": map[string]string{"order": fmt.Sprintf("%s %s %s @ market", t, form.Get("volume"), o.Pair)},
+				"txid":  []string{o.ID},
+			})
+		case errUnknownPair:
+			fail("EQuery:Unknown asset pair")
+		case errInsufficient:
+			fail("EOrder:Insufficient funds")
+		case errNoLiquidity:
+			fail("EOrder:Insufficient liquidity")
+		default:
+			fail("EGeneral:Invalid arguments:volume")
+		}
+
+	default:
+		writeJSON(w, http.StatusNotFound, krakenResponse{Error: []string{"EGeneral:Unknown method"}})
+	}
+}
+
+// krakenAuthorize reads the form of a private request and checks its key,
+// signature and nonce, returning the error code if they fail.
+func (s *Server) krakenAuthorize(r *http.Request) (url.Values, string) {
+
+	if r.Method != "POST" {
+		return nil, "EGeneral:Invalid arguments"
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, "EGeneral:Invalid arguments"
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, "EGeneral:Invalid arguments"
+	}
+	nonce, err := strconv.ParseInt(form.Get("nonce"), 10, 64)
+	if err != nil {
+		return nil, "EAPI:Invalid nonce"
+	}
+	key, sign := r.Header.Get("API-Key"), r.Header.Get("API-Sign")
+	if key == "" || sign == "" {
+		return nil, "EAPI:Invalid key"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key != "" {
+		if key != s.key {
+			return nil, "EAPI:Invalid key"
+		}
+		secret, err := base64.StdEncoding.DecodeString(s.secret)
+		if err != nil {
+			return nil, "EAPI:Invalid key"
+		}
+		sha := sha256.Sum256([]byte(form.Get("nonce") + string(body)))
+		mac := hmac.New(sha512.New, secret)
+		mac.Write(append([]byte(r.URL.Path), sha[:]...))
+		got, err := base64.StdEncoding.DecodeString(sign)
+		if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+			return nil, "EAPI:Invalid signature"
+		}
+	}
+	if nonce <= s.nonce {
+		return nil, "EAPI:Invalid nonce"
+	}
+	s.nonce = nonce
+	return form, ""
+}
+
+func contains(list []string, s string) bool {
+
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package mock
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// lunoError is the body of Luno's error responses.
+type lunoError struct {
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code"`
+}
+
+type lunoLevel struct {
+	Price  string `json:"price"`
+	Volume string `json:"volume"`
+}
+
+func lunoLevels(levels [][2]float64) []lunoLevel {
+
+	out := []lunoLevel{}
+	for _, l := range levels {
+		out = append(out, lunoLevel{formatFloat(l[0]), formatFloat(l[1])})
+	}
+	return out
+}
+
+func (s *Server) luno(w http.ResponseWriter, r *http.Request, endpoint string) {
+
+	switch endpoint {
+	case "orderbook", "orderbook_top":
+		b, ok := s.serveBook(r.URL.Query().Get("pair"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, lunoError{"Market not found", "ErrMarketNotFound"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"timestamp": millis(time.Now()),
+			"bids":      lunoLevels(b.Bids),
+			"asks":      lunoLevels(b.Asks),
+		})
+
+	case "tickers":
+		var tickers []map[string]interface{}
+		for _, t := range s.tickers() {
+			tickers = append(tickers, map[string]interface{}{
+				"pair":                   t.Code,
+				"timestamp":              millis(time.Now()),
+				"bid":                    formatFloat(t.Bid),
+				"ask":                    formatFloat(t.Ask),
+				"last_trade":             formatFloat(t.Last),
+				"rolling_24_hour_volume": formatFloat(t.Volume),
+				"status":                 "ACTIVE",
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tickers": tickers})
+
+	case "markets":
+		// every market has the same trading rules
+		s.mu.Lock()
+		var markets []map[string]interface{}
+		for _, p := range s.pairs {
+			markets = append(markets, map[string]interface{}{
+				"market_id":        p.Code,
+				"trading_status":   "ACTIVE",
+				"base_currency":    p.Base,
+				"counter_currency": p.Quote,
+				"min_volume":       "0.0005",
+				"max_volume":       "100",
+				"volume_scale":     4,
+				"min_price":        "0.0001",
+				"max_price":        "10000000",
+				"price_scale":      4,
+			})
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"markets": markets})
+
+	case "balance":
+		if !s.lunoAuthorized(w, r) {
+			return
+		}
+		s.mu.Lock()
+		var balances []map[string]string
+		for asset, amount := range s.balances {
+			balances = append(balances, map[string]string{
+				"account_id":  "1",
+				"asset":       asset,
+				"balance":     formatFloat(amount),
+				"reserved":    "0.00",
+				"unconfirmed": "0.00",
+			})
+		}
+		s.mu.Unlock()
+		sort.Slice(balances, func(i, j int) bool { return balances[i]["asset"] < balances[j]["asset"] })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"balance": balances})
+
+	case "marketorder":
+		if r.Method != "POST" {
+			writeJSON(w, http.StatusMethodNotAllowed, lunoError{"Method not allowed", "ErrMethodNotAllowed"})
+			return
+		}
+		if !s.lunoAuthorized(w, r) {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeJSON(w, http.StatusBadRequest, lunoError{err.Error(), "ErrInvalidArguments"})
+			return
+		}
+		// buys are in the quote asset and sells in the base asset
+		var buy bool
+		var field string
+		switch r.PostForm.Get("type") {
+		case "BUY":
+			buy, field = true, "counter_volume"
+		case "SELL":
+			field = "base_volume"
+		default:
+			writeJSON(w, http.StatusBadRequest, lunoError{"Invalid order type", "ErrInvalidArguments"})
+			return
+		}
+		volume, err := strconv.ParseFloat(r.PostForm.Get(field), 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, lunoError{"Invalid " + field, "ErrInvalidArguments"})
+			return
+		}
+		o, err := s.fill(r.PostForm.Get("pair"), buy, volume, buy)
+		switch err {
+		case nil:
+			writeJSON(w, http.StatusOK, map[string]string{"order_id": o.ID})
+		case errUnknownPair:
+			writeJSON(w, http.StatusNotFound, lunoError{"Market not found", "ErrMarketNotFound"})
+		case errInsufficient:
+			writeJSON(w, http.StatusBadRequest, lunoError{"Insufficient balance", "ErrInsufficientBalance"})
+		case errNoLiquidity:
+			writeJSON(w, http.StatusBadRequest, lunoError{"Insufficient liquidity", "ErrInsufficientLiquidity"})
+		default:
+			writeJSON(w, http.StatusBadRequest, lunoError{err.Error(), "ErrInvalidArguments"})
+		}
+
+	default:
+		writeJSON(w, http.StatusNotFound, lunoError{"Not found", "ErrNotFound"})
+	}
+}
+
+// lunoAuthorized checks the basic auth of a private request, answering it
+// if it fails.
+func (s *Server) lunoAuthorized(w http.ResponseWriter, r *http.Request) bool {
+
+	key, secret, ok := r.BasicAuth()
+	s.mu.Lock()
+	if ok && s.key != "" {
+		ok = key == s.key && secret == s.secret
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, lunoError{"Unauthorized", "ErrUnauthorised"})
+	}
+	return ok
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
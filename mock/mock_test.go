@@ -0,0 +1,255 @@
+package mock
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacoduplessis/crypto/credentials"
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+var xbtzar = &Book{
+	Bids: [][2]float64{{100, 1}, {99, 2}, {98, 5}},
+	Asks: [][2]float64{{101, 1}, {102, 2}, {103, 5}},
+}
+
+func newExchange(t *testing.T, s *Server, o exchange.Options) exchange.Exchange {
+
+	o.BaseURL = s.API()
+	e, err := exchange.New(string(s.Dialect), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func pairOf(t *testing.T, e exchange.Exchange, code string) *exchange.Pair {
+
+	for _, p := range e.Meta().Pairs {
+		if p.Code == code {
+			return p
+		}
+	}
+	t.Fatalf("No pair %s on %s", code, e.Meta().Slug)
+	return nil
+}
+
+func TestLuno(t *testing.T) {
+
+	s := NewServer(Luno)
+	defer s.Close()
+	s.AddPair("XBTZAR", "XBT", "ZAR")
+	s.AddPair("ETHXBT", "ETH", "XBT")
+	s.SetBook("XBTZAR", xbtzar)
+	s.SetBook("ETHXBT", &Book{Bids: [][2]float64{{0.03, 10}}, Asks: [][2]float64{{0.031, 10}}})
+	s.SetCredentials("key", "secret")
+	s.SetBalance("ZAR", 1000)
+
+	ln := newExchange(t, s, exchange.Options{APIKey: "key", APISecret: "secret"})
+	pair := pairOf(t, ln, "XBTZAR")
+	f := &exchange.Fetcher{}
+
+	ob, err := f.GetOrderBook(ln, pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ob.Bids, xbtzar.Bids) || !reflect.DeepEqual(ob.Asks, xbtzar.Asks) {
+		t.Errorf("Served %v %v", ob.Bids, ob.Asks)
+	}
+
+	tickers, err := f.GetTickers(context.Background(), ln, pair, pairOf(t, ln, "ETHXBT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tickers[0].Bid != 100 || tickers[0].Ask != 101 || tickers[1].Ask != 0.031 {
+		t.Errorf("Tickers %+v %+v", tickers[0], tickers[1])
+	}
+	markets, err := f.GetMarkets(ln)
+	if err != nil || len(markets) != 2 {
+		t.Errorf("Markets %v, %v", markets, err)
+	}
+
+	// buys are in rand: 101 for the first bitcoin and 102 a bitcoin after
+	tr := ln.(exchange.Trader)
+	o, err := tr.PlaceOrder(http.Client{}, &exchange.OrderRequest{Pair: pair, Type: exchange.BUY, Volume: 305, Quote: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := s.Orders()
+	if len(orders) != 1 || orders[0].ID != o.ID || orders[0].Volume != 3 || orders[0].Value != 305 {
+		t.Errorf("Filled %+v", orders[0])
+	}
+	if s.Balance("ZAR") != 695 || s.Balance("XBT") != 3 {
+		t.Errorf("Balances ZAR %v XBT %v", s.Balance("ZAR"), s.Balance("XBT"))
+	}
+	if b := s.Book("XBTZAR"); !reflect.DeepEqual(b.Asks, [][2]float64{{103, 5}}) {
+		t.Errorf("Asks left %v", b.Asks)
+	}
+
+	balances, err := tr.GetBalances(http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 2 {
+		t.Errorf("Balances %v", balances)
+	}
+
+	_, err = tr.PlaceOrder(http.Client{}, &exchange.OrderRequest{Pair: pair, Type: exchange.SELL, Volume: 4})
+	if e, ok := err.(*exchange.Error); !ok || e.StatusCode != 400 || !strings.Contains(e.Error(), "ErrInsufficientBalance") {
+		t.Errorf("Expected insufficient balance, got %v", err)
+	}
+
+	wrong := newExchange(t, s, exchange.Options{APIKey: "key", APISecret: "guess"}).(exchange.Trader)
+	if _, err := wrong.GetBalances(http.Client{}); exchange.KindOf(err) != exchange.StatusError {
+		t.Errorf("Expected a 401, got %v", err)
+	}
+}
+
+func TestKraken(t *testing.T) {
+
+	secret := base64.StdEncoding.EncodeToString([]byte("kraken secret"))
+	s := NewServer(Kraken)
+	defer s.Close()
+	s.AddPair("XXBTZEUR", "XXBT", "ZEUR")
+	s.AddPair("XXRPZEUR", "XXRP", "ZEUR")
+	s.SetBook("XXBTZEUR", xbtzar)
+	s.SetCredentials("key", secret)
+	s.SetBalance("XXBT", 2)
+
+	kr := newExchange(t, s, exchange.Options{APIKey: "key", APISecret: credentials.Secret(secret)})
+	pair := pairOf(t, kr, "XXBTZEUR")
+	f := &exchange.Fetcher{}
+
+	ob, err := f.GetOrderBook(kr, pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ob.Bids, xbtzar.Bids) || !reflect.DeepEqual(ob.Asks, xbtzar.Asks) {
+		t.Errorf("Served %v %v", ob.Bids, ob.Asks)
+	}
+	tickers, err := f.GetTickers(context.Background(), kr, pair)
+	if err != nil || tickers[0].Bid != 100 || tickers[0].Ask != 101 {
+		t.Errorf("Tickers %v, %v", tickers, err)
+	}
+	markets, err := f.GetMarkets(kr)
+	if err != nil || len(markets) != 2 || markets[0].Pair.TakerFee != 0.0026 {
+		t.Errorf("Markets %v, %v", markets, err)
+	}
+
+	// signed requests with increasing nonces are accepted
+	tr := kr.(exchange.Trader)
+	if _, err := tr.PlaceOrder(http.Client{}, &exchange.OrderRequest{Pair: pair, Type: exchange.SELL, Volume: 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Balance("XXBT") != 0.5 || s.Balance("ZEUR") != 149.5 {
+		t.Errorf("Balances XXBT %v ZEUR %v", s.Balance("XXBT"), s.Balance("ZEUR"))
+	}
+	balances, err := tr.GetBalances(http.Client{})
+	if err != nil || len(balances) != 2 {
+		t.Errorf("Balances %v, %v", balances, err)
+	}
+	_, err = tr.PlaceOrder(http.Client{}, &exchange.OrderRequest{Pair: pair, Type: exchange.BUY, Volume: 1000, Quote: true})
+	if exchange.KindOf(err) != exchange.APIError || !strings.Contains(err.Error(), "EOrder:Insufficient funds") {
+		t.Errorf("Expected insufficient funds, got %v", err)
+	}
+
+	wrong := newExchange(t, s, exchange.Options{APIKey: "key", APISecret: credentials.Secret(base64.StdEncoding.EncodeToString([]byte("guess")))})
+	if _, err := wrong.(exchange.Trader).GetBalances(http.Client{}); err == nil || !strings.Contains(err.Error(), "EAPI:Invalid signature") {
+		t.Errorf("Expected an invalid signature, got %v", err)
+	}
+
+	// error arrays come with a 200
+	s.Fail("public/Depth", KrakenErrors("EService:Unavailable"))
+	if _, err := f.GetOrderBook(kr, pair); exchange.KindOf(err) != exchange.Maintenance {
+		t.Errorf("Expected maintenance, got %v", err)
+	}
+	s.SetRateLimit(1, 0)
+	f.GetOrderBook(kr, pair)
+	if _, err := f.GetOrderBook(kr, pair); exchange.KindOf(err) != exchange.RateLimited {
+		t.Errorf("Expected rate limiting, got %v", err)
+	}
+}
+
+func TestICE(t *testing.T) {
+
+	s := NewServer(ICE)
+	defer s.Close()
+	s.AddPair("3", "btc", "zar")
+	s.SetBook("3", xbtzar)
+
+	ice := newExchange(t, s, exchange.Options{})
+	f := &exchange.Fetcher{}
+	ob, err := f.GetOrderBook(ice, pairOf(t, ice, "3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ob.Bids, xbtzar.Bids) || !reflect.DeepEqual(ob.Asks, xbtzar.Asks) {
+		t.Errorf("Served %v %v", ob.Bids, ob.Asks)
+	}
+	markets, err := f.GetMarkets(ice)
+	if err != nil || len(markets) != 1 || markets[0].Pair.Base != exchange.Bitcoin {
+		t.Errorf("Markets %v, %v", markets, err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+
+	s := NewServer(Luno)
+	defer s.Close()
+	s.AddPair("XBTZAR", "XBT", "ZAR")
+	first := &Book{Bids: [][2]float64{{90, 1}}, Asks: [][2]float64{{91, 1}}}
+	s.SetBook("XBTZAR", first, xbtzar)
+
+	ln := newExchange(t, s, exchange.Options{})
+	pair := pairOf(t, ln, "XBTZAR")
+	f := &exchange.Fetcher{Retry: &exchange.RetryPolicy{Attempts: 3, Base: time.Millisecond}}
+
+	// server errors are retried, and the script moves on only when served
+	s.Fail("orderbook", Unavailable, BadGateway)
+	ob, err := f.GetOrderBook(ln, pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Requests("orderbook") != 3 || ob.Bids[0][0] != 90 {
+		t.Errorf("Expected the first book on the third try, got %v after %d", ob.Bids, s.Requests("orderbook"))
+	}
+	for i := 0; i < 2; i++ {
+		if ob, err := f.GetOrderBook(ln, pair); err != nil || ob.Bids[0][0] != 100 {
+			t.Errorf("Expected the last book to repeat, got %v, %v", ob, err)
+		}
+	}
+
+	s.Fail("", Malformed)
+	if _, err := f.GetOrderBook(ln, pair); exchange.KindOf(err) != exchange.ParseError || s.Requests("") != 6 {
+		t.Errorf("Expected a single parse error, got %v after %d", err, s.Requests(""))
+	}
+
+	s.Fail("orderbook", TooManyRequests)
+	_, err = (&exchange.Fetcher{}).GetOrderBook(ln, pair)
+	if e, ok := err.(*exchange.Error); !ok || e.Kind != exchange.RateLimited || e.RetryAfter != time.Second {
+		t.Errorf("Expected rate limiting for a second, got %#v", err)
+	}
+
+	s.SetLatency(50 * time.Millisecond)
+	_, err = (&exchange.Fetcher{Client: http.Client{Timeout: 10 * time.Millisecond}}).GetOrderBook(ln, pair)
+	if exchange.KindOf(err) != exchange.TransportError {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	s.SetLatency(0)
+
+	// the scheduler keeps within the server's limit
+	s.SetRateLimit(2, 50)
+	sched := exchange.NewScheduler(ln)
+	sched.SetLimit("luno", exchange.RateLimit{Burst: 2, Rate: 40})
+	f = &exchange.Fetcher{Client: http.Client{Transport: sched.Transport(http.DefaultTransport)}}
+	for i := 0; i < 6; i++ {
+		if _, err := f.GetOrderBook(ln, pair); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+// Package mock is an in-process exchange for integration tests. A Server
+// speaks the REST API of Luno, Kraken or ICE, serving order books set by
+// the test, matching market orders against them and settling balances, and
+// failing, delaying or rate limiting requests on cue.
+//
+// Point an exchange at it with its base URL:
+//
+//	s := mock.NewServer(mock.Kraken)
+//	defer s.Close()
+//	s.AddPair("XXBTZEUR", "XXBT", "ZEUR")
+//	s.SetBook("XXBTZEUR", &mock.Book{Bids: [][2]float64{{100, 1}}, Asks: [][2]float64{{101, 1}}})
+//	kr, _ := exchange.New("kraken", exchange.Options{BaseURL: s.API()})
+//
+// The package doesn't import exchange, so exchange's own tests can use it.
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dialect is the API a Server imitates.
+type Dialect string
+
+const (
+	Luno   Dialect = "luno"
+	Kraken Dialect = "kraken"
+	ICE    Dialect = "ice"
+)
+
+// roots are the paths of each API on its host.
+var roots = map[Dialect]string{Luno: "/api/1/", Kraken: "/0/", ICE: "/api/v1/"}
+
+// outside are the endpoints of each API served outside its root, by path.
+var outside = map[Dialect]map[string]string{Luno: {"/api/exchange/1/markets": "markets"}}
+
+// Book is an order book of [price, volume] levels, bids descending and
+// asks ascending, as in exchange.OrderBook.
+type Book struct {
+	Bids [][2]float64
+	Asks [][2]float64
+}
+
+func (b *Book) clone() *Book {
+	return &Book{Bids: append([][2]float64(nil), b.Bids...), Asks: append([][2]float64(nil), b.Asks...)}
+}
+
+// Pair is a market on the server, with codes as the exchange writes them:
+// XBTZAR with XBT and ZAR on Luno, XXBTZEUR with XXBT and ZEUR on Kraken,
+// and 3 with btc and zar on ICE.
+type Pair struct {
+	Code  string
+	Base  string
+	Quote string
+}
+
+// Fault is a failure answering a request in place of the server.
+type Fault struct {
+	// Status is the HTTP status, 200 if zero.
+	Status int
+	Header http.Header
+	// Body is sent as is, for instance to send malformed JSON.
+	Body string
+	// Errors, if set, are sent as a Kraken error array.
+	Errors []string
+}
+
+var (
+	// TooManyRequests asks the client to retry after a second.
+	TooManyRequests = Fault{Status: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}}
+	Unavailable     = Fault{Status: http.StatusServiceUnavailable, Body: "Service Unavailable"}
+	BadGateway      = Fault{Status: http.StatusBadGateway, Body: "Bad Gateway"}
+	// Malformed is a response cut off mid-way.
+	Malformed = Fault{Body: `{"bids": [{"price": "1`}
+)
+
+// KrakenErrors is a response with Kraken's error array, which Kraken sends
+// with a 200, such as EAPI:Rate limit exceeded or EService:Unavailable.
+func KrakenErrors(codes ...string) Fault {
+	return Fault{Errors: codes}
+}
+
+// Order is a market order the server filled.
+type Order struct {
+	ID   string
+	Pair string
+	// Type is "buy" or "sell".
+	Type string
+	// Volume is the amount of the base asset and Value that of the quote
+	// asset.
+	Volume float64
+	Value  float64
+	Time   time.Time
+}
+
+// Server is a fake exchange listening on a local port.
+type Server struct {
+	Dialect Dialect
+	// URL is the root of the server, such as http://127.0.0.1:50123.
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	pairs    []*Pair
+	books    map[string][]*Book
+	balances map[string]float64
+	faults   map[string][]Fault
+	latency  time.Duration
+	limit    *bucket
+	key      string
+	secret   string
+	nonce    int64
+	requests map[string]int
+	orders   []*Order
+	last     map[string]float64
+	volume   map[string]float64
+}
+
+// NewServer starts a server speaking d, with no pairs.
+func NewServer(d Dialect) *Server {
+
+	if _, ok := roots[d]; !ok {
+		panic("mock: unknown dialect " + string(d))
+	}
+	s := &Server{
+		Dialect:  d,
+		books:    map[string][]*Book{},
+		balances: map[string]float64{},
+		faults:   map[string][]Fault{},
+		requests: map[string]int{},
+		last:     map[string]float64{},
+		volume:   map[string]float64{},
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// API returns the URL to use as the exchange's base URL.
+func (s *Server) API() string {
+	return s.URL + roots[s.Dialect]
+}
+
+// AddPair lists a market. Its book is empty until set.
+func (s *Server) AddPair(code, base, quote string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pairs = append(s.pairs, &Pair{Code: code, Base: base, Quote: quote})
+	if _, ok := s.books[code]; !ok {
+		s.books[code] = []*Book{{}}
+	}
+}
+
+// SetBook scripts the books of a pair. Each request for the book is served
+// the next one, and the last is served from then on. Orders fill against
+// the book that would be served next, and change it.
+func (s *Server) SetBook(code string, books ...*Book) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.books[code] = nil
+	for _, b := range books {
+		s.books[code] = append(s.books[code], b.clone())
+	}
+	if len(s.books[code]) == 0 {
+		s.books[code] = []*Book{{}}
+	}
+}
+
+// Book returns a copy of the book of a pair that would be served next, or
+// nil for an unknown pair.
+func (s *Server) Book(code string) *Book {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pair(code) == nil {
+		return nil
+	}
+	return s.books[code][0].clone()
+}
+
+// SetBalance sets the account's balance of an asset.
+func (s *Server) SetBalance(asset string, amount float64) {
+	s.mu.Lock()
+	s.balances[asset] = amount
+	s.mu.Unlock()
+}
+
+// Balance returns the account's balance of an asset.
+func (s *Server) Balance(asset string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.balances[asset]
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// SetCredentials makes private endpoints check the API key and secret:
+// Luno's basic auth, or Kraken's key, signature and nonce. Without them any
+// credentials are accepted.
+func (s *Server) SetCredentials(key, secret string) {
+	s.mu.Lock()
+	s.key, s.secret = key, secret
+	s.mu.Unlock()
+}
+
+// SetRateLimit allows burst requests at once, refilling at rate requests
+// per second. Requests over the limit are answered the way the exchange
+// does: a 429, or Kraken's EAPI:Rate limit exceeded.
+func (s *Server) SetRateLimit(burst, rate float64) {
+	s.mu.Lock()
+	s.limit = &bucket{burst: burst, rate: rate, tokens: burst}
+	s.mu.Unlock()
+}
+
+// Fail answers the next requests to an endpoint with faults, one each.
+// Endpoints are paths below the API root, such as orderbook or
+// public/Depth; an empty endpoint matches every request.
+func (s *Server) Fail(endpoint string, faults ...Fault) {
+	s.mu.Lock()
+	s.faults[endpoint] = append(s.faults[endpoint], faults...)
+	s.mu.Unlock()
+}
+
+// Requests returns the number of requests made to an endpoint, or to all
+// endpoints if it is empty.
+func (s *Server) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[endpoint]
+}
+
+// Orders returns the orders filled so far.
+func (s *Server) Orders() []*Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Order(nil), s.orders...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	root := roots[s.Dialect]
+	endpoint, ok := outside[s.Dialect][r.URL.Path]
+	if !ok {
+		if !strings.HasPrefix(r.URL.Path, root) {
+			http.NotFound(w, r)
+			return
+		}
+		endpoint = strings.TrimPrefix(r.URL.Path, root)
+	}
+
+	s.mu.Lock()
+	s.requests[""]++
+	s.requests[endpoint]++
+	latency := s.latency
+	fault, faulted := s.nextFault(endpoint)
+	limited := !faulted && s.limit != nil && !s.limit.take(time.Now())
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case faulted:
+		writeFault(w, fault)
+	case limited && s.Dialect == Kraken:
+		writeJSON(w, http.StatusOK, krakenResponse{Error: []string{"EAPI:Rate limit exceeded"}})
+	case limited:
+		writeFault(w, TooManyRequests)
+	case s.Dialect == Luno:
+		s.luno(w, r, endpoint)
+	case s.Dialect == Kraken:
+		s.kraken(w, r, endpoint)
+	case s.Dialect == ICE:
+		s.ice(w, r, endpoint)
+	}
+}
+
+// nextFault takes the fault for a request to endpoint, if one is queued.
+func (s *Server) nextFault(endpoint string) (Fault, bool) {
+
+	for _, e := range []string{endpoint, ""} {
+		if q := s.faults[e]; len(q) > 0 {
+			s.faults[e] = q[1:]
+			return q[0], true
+		}
+	}
+	return Fault{}, false
+}
+
+func writeFault(w http.ResponseWriter, f Fault) {
+
+	for k, v := range f.Header {
+		w.Header()[k] = v
+	}
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if f.Errors != nil {
+		writeJSON(w, status, krakenResponse{Error: f.Errors})
+		return
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(f.Body))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) pair(code string) *Pair {
+
+	for _, p := range s.pairs {
+		if p.Code == code {
+			return p
+		}
+	}
+	return nil
+}
+
+// serveBook returns the book of a pair and moves its script on.
+func (s *Server) serveBook(code string) (*Book, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pair(code) == nil {
+		return nil, false
+	}
+	q := s.books[code]
+	b := q[0].clone()
+	if len(q) > 1 {
+		s.books[code] = q[1:]
+	}
+	return b, true
+}
+
+// ticker is the top of a pair's book and its trading.
+type ticker struct {
+	*Pair
+	Bid, Ask, Last, Volume float64
+}
+
+func (s *Server) tickers() []*ticker {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tickers []*ticker
+	for _, p := range s.pairs {
+		t := &ticker{Pair: p, Last: s.last[p.Code], Volume: s.volume[p.Code]}
+		b := s.books[p.Code][0]
+		if len(b.Bids) > 0 {
+			t.Bid = b.Bids[0][0]
+		}
+		if len(b.Asks) > 0 {
+			t.Ask = b.Asks[0][0]
+		}
+		tickers = append(tickers, t)
+	}
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Code < tickers[j].Code })
+	return tickers
+}
+
+var (
+	errUnknownPair  = errors.New("unknown pair")
+	errNoLiquidity  = errors.New("no liquidity")
+	errInsufficient = errors.New("insufficient funds")
+	errVolume       = errors.New("invalid volume")
+)
+
+// fill matches a market order against the book of a pair, taking levels
+// from the top until volume, in the base asset or in the quote asset if
+// quote is set, is filled or the book runs out, and settles the balances.
+// Nothing changes if the account can't pay.
+func (s *Server) fill(code string, buy bool, volume float64, quote bool) (*Order, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.pair(code)
+	if p == nil {
+		return nil, errUnknownPair
+	}
+	if volume <= 0 || math.IsNaN(volume) || math.IsInf(volume, 0) {
+		return nil, errVolume
+	}
+
+	b := s.books[code][0]
+	levels := b.Bids
+	if buy {
+		levels = b.Asks
+	}
+	levels = append([][2]float64(nil), levels...)
+
+	var base, value float64
+	remaining := volume
+	for len(levels) > 0 && remaining > 0 {
+		price, available := levels[0][0], levels[0][1]
+		take := math.Min(available, remaining)
+		if quote {
+			take = math.Min(available, remaining/price)
+		}
+		base += take
+		value += take * price
+		if quote {
+			remaining -= take * price
+		} else {
+			remaining -= take
+		}
+		if take < available {
+			levels[0][1] = available - take
+			break
+		}
+		levels = levels[1:]
+	}
+	if base == 0 {
+		return nil, errNoLiquidity
+	}
+
+	if buy {
+		if s.balances[p.Quote] < value {
+			return nil, errInsufficient
+		}
+		s.balances[p.Quote] -= value
+		s.balances[p.Base] += base
+		b.Asks = levels
+	} else {
+		if s.balances[p.Base] < base {
+			return nil, errInsufficient
+		}
+		s.balances[p.Base] -= base
+		s.balances[p.Quote] += value
+		b.Bids = levels
+	}
+
+	o := &Order{Pair: code, Type: "sell", Volume: base, Value: value, Time: time.Now()}
+	if buy {
+		o.Type = "buy"
+	}
+	switch s.Dialect {
+	case Kraken:
+		o.ID = fmt.Sprintf("OMOCK-%05d-%s", len(s.orders)+1, strings.ToUpper(o.Type))
+	default:
+		o.ID = fmt.Sprintf("BXMOCK%08d", len(s.orders)+1)
+	}
+	s.orders = append(s.orders, o)
+	s.last[code] = value / base
+	s.volume[code] += base
+	return o, nil
+}
+
+// bucket is a token bucket of requests.
+type bucket struct {
+	burst, rate, tokens float64
+	last                time.Time
+}
+
+// take spends a token, reporting whether there was one.
+func (b *bucket) take(now time.Time) bool {
+
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
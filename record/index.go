@@ -0,0 +1,113 @@
+package record
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dayLayout  = "2006-01-02"
+	dataSuffix = ".rec.gz"
+	idxSuffix  = ".idx"
+	entrySize  = 8 + 8 + 8 + 4 + 8
+)
+
+// IndexEntry describes one batch in a data file: where its gzip member
+// starts, how long it is, the sequence numbers it holds and when it was
+// recorded.
+type IndexEntry struct {
+	Offset   int64
+	Length   int64
+	FirstSeq uint64
+	Count    uint32
+	Time     time.Time
+}
+
+// End returns the offset just past the batch.
+func (e IndexEntry) End() int64 {
+	return e.Offset + e.Length
+}
+
+func (e IndexEntry) marshal() []byte {
+
+	b := make([]byte, entrySize)
+	binary.BigEndian.PutUint64(b[0:], uint64(e.Offset))
+	binary.BigEndian.PutUint64(b[8:], uint64(e.Length))
+	binary.BigEndian.PutUint64(b[16:], e.FirstSeq)
+	binary.BigEndian.PutUint32(b[24:], e.Count)
+	binary.BigEndian.PutUint64(b[28:], uint64(e.Time.UnixNano()))
+	return b
+}
+
+func unmarshalEntry(b []byte) IndexEntry {
+
+	return IndexEntry{
+		Offset:   int64(binary.BigEndian.Uint64(b[0:])),
+		Length:   int64(binary.BigEndian.Uint64(b[8:])),
+		FirstSeq: binary.BigEndian.Uint64(b[16:]),
+		Count:    binary.BigEndian.Uint32(b[24:]),
+		Time:     time.Unix(0, int64(binary.BigEndian.Uint64(b[28:]))).UTC(),
+	}
+}
+
+// ReadIndex reads all complete entries from an index file. A trailing
+// partial entry, left by a crash mid-write, is ignored.
+func ReadIndex(path string) ([]IndexEntry, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []IndexEntry
+	b := make([]byte, entrySize)
+	for {
+		_, err := io.ReadFull(f, b)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, unmarshalEntry(b))
+	}
+}
+
+// Days lists the days, oldest first, for which dir holds an index file.
+func Days(dir string) ([]time.Time, error) {
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+idxSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var days []time.Time
+	for _, m := range matches {
+		d, err := time.Parse(dayLayout, strings.TrimSuffix(filepath.Base(m), idxSuffix))
+		if err != nil {
+			continue
+		}
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+func dataPath(dir string, day time.Time) string {
+	return filepath.Join(dir, day.Format(dayLayout)+dataSuffix)
+}
+
+func idxPath(dir string, day time.Time) string {
+	return filepath.Join(dir, day.Format(dayLayout)+idxSuffix)
+}
+
+func dayOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,84 @@
+package record
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"time"
+)
+
+// Range calls fn for every snapshot in dir recorded in [from, to), in
+// recording order. The index is used to skip days and batches outside the
+// range, so only matching batches are decompressed. Returning an error from
+// fn stops the iteration and Range returns that error.
+func Range(dir string, from, to time.Time, fn func(*Snapshot) error) error {
+
+	days, err := Days(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		if !day.Before(to) || !day.Add(24*time.Hour).After(from) {
+			continue
+		}
+		if err := rangeDay(dir, day, from, to, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rangeDay(dir string, day, from, to time.Time, fn func(*Snapshot) error) error {
+
+	entries, err := ReadIndex(idxPath(dir, day))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(dataPath(dir, day))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, e := range entries {
+		if e.Time.Before(from) || !e.Time.Before(to) {
+			continue
+		}
+		snapshots, err := ReadBatch(f, e)
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadBatch decodes the batch described by e from a data file.
+func ReadBatch(f io.ReaderAt, e IndexEntry) ([]*Snapshot, error) {
+
+	gz, err := gzip.NewReader(io.NewSectionReader(f, e.Offset, e.Length))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	r := bufio.NewReader(gz)
+	snapshots := make([]*Snapshot, 0, e.Count)
+	for {
+		s, err := readSnapshot(r)
+		if err == io.EOF {
+			return snapshots, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+}
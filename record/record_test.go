@@ -0,0 +1,191 @@
+package record
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+func TestWriterRestart(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	day1 := time.Date(2018, 3, 1, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+
+	snap := func() []*Snapshot {
+		return []*Snapshot{
+			{Exchange: "luno", Pair: "XBTZAR", Bids: [][2]float64{{100, 1}}, Asks: [][2]float64{{101, 2}}},
+			{Exchange: "kraken", Pair: "XXBTZEUR", Bids: [][2]float64{{7, 1}, {6, 3}}},
+		}
+	}
+
+	w, err := OpenWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteSnapshots(day1, snap()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteSnapshots(day2, snap()); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// simulate a crash after the data was written but before the index
+	f, err := os.OpenFile(dataPath(dir, dayOf(day2)), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("partial batch"))
+	f.Close()
+
+	w, err = OpenWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.NextSeq() != 4 {
+		t.Fatalf("Expected next seq 4 after restart, got %d", w.NextSeq())
+	}
+	if err := w.WriteSnapshots(day2.Add(time.Minute), snap()); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var seqs []uint64
+	err = Range(dir, day1, day2.Add(time.Hour), func(s *Snapshot) error {
+		seqs = append(seqs, s.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seqs, []uint64{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("Unexpected sequence numbers %v", seqs)
+	}
+
+	var got []*Snapshot
+	Range(dir, day2, day2.Add(time.Second), func(s *Snapshot) error {
+		got = append(got, s)
+		return nil
+	})
+	if len(got) != 2 || got[1].Pair != "XXBTZEUR" || !reflect.DeepEqual(got[1].Bids, [][2]float64{{7, 1}, {6, 3}}) || !got[1].Time.Equal(day2) {
+		t.Errorf("Unexpected snapshots in range: %+v", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRecorder(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := OpenWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Luno answers and Kraken is down, which leaves it out of the round
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		status, body := 200, `{"bids": [{"price": "100", "volume": "1"}], "asks": [{"price": "101", "volume": "2"}]}`
+		if strings.Contains(r.URL.Host, "kraken") {
+			status, body = 503, "Service Unavailable"
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+	var exchanges []exchange.Exchange
+	for _, slug := range []string{"luno", "kraken"} {
+		e, err := exchange.New(slug, exchange.Options{Pairs: []string{"XBTZAR", "XXBTZEUR"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exchanges = append(exchanges, e)
+	}
+
+	// the zero Fetcher falls back to one using Client
+	r := &Recorder{Client: client, Exchanges: exchanges, Writer: w}
+	start := time.Now()
+	if err := r.Record(); err != nil {
+		t.Fatal(err)
+	}
+	var got []*Snapshot
+	Range(dir, start.Add(-time.Second), time.Now().Add(time.Second), func(s *Snapshot) error {
+		got = append(got, s)
+		return nil
+	})
+	if len(got) != 1 || got[0].Exchange != "luno" || got[0].Pair != "XBTZAR" || !reflect.DeepEqual(got[0].Asks, [][2]float64{{101, 2}}) {
+		t.Errorf("Unexpected snapshots %+v", got)
+	}
+}
+
+func TestPlayer(t *testing.T) {
+
+	t0 := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+
+	p := NewPlayer([]*Snapshot{
+		{Time: t0, Exchange: "luno", Pair: "XBTZAR", Bids: [][2]float64{{90, 1}}, Asks: [][2]float64{{100, 20}}},
+		{Time: t1, Exchange: "luno", Pair: "XBTZAR", Bids: [][2]float64{{95, 1}}, Asks: [][2]float64{{125, 20}}},
+	}, &exchange.Luno{})
+
+	simulate := func() float64 {
+		books, err := exchange.GetOrderBooks(p.Client(), p.Exchanges()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 1 || books[0].Pair.Base != exchange.Bitcoin {
+			t.Fatalf("Expected the Luno XBTZAR book, got %v", books)
+		}
+		ob := books[0]
+		r := &exchange.Route{Legs: []*exchange.RouteLeg{{Pair: ob.Pair, OrderBook: ob.Prepare(), Exchange: ob.Exchange}}}
+		res, err := r.Simulate(exchange.Rand, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Amount
+	}
+
+	if a := simulate(); a != 10 {
+		t.Errorf("Expected 10 bitcoin at start, got %f", a)
+	}
+	if !p.Step() || !p.Now().Equal(t1) {
+		t.Fatalf("Expected to step to %s", t1)
+	}
+	if a := simulate(); a != 8 {
+		t.Errorf("Expected 8 bitcoin after step, got %f", a)
+	}
+	if p.Step() {
+		t.Error("Expected no step past the last snapshot")
+	}
+
+	p.Seek(t0.Add(-time.Second))
+	if _, err := exchange.GetOrderBooks(p.Client(), p.Exchanges()...); err == nil {
+		t.Error("Expected an error before the first snapshot")
+	}
+
+	var steps []time.Time
+	p.Seek(t0)
+	p.Play(context.Background(), 0, func(t time.Time) { steps = append(steps, t) })
+	if !reflect.DeepEqual(steps, []time.Time{t1}) {
+		t.Errorf("Unexpected play steps %v", steps)
+	}
+}
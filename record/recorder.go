@@ -0,0 +1,84 @@
+package record
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// Recorder periodically fetches all pairs on a set of exchanges and writes
+// each round as one batch.
+type Recorder struct {
+	Client http.Client
+	// Fetcher, if set, fetches the books in place of a plain Fetcher using
+	// Client, to add retries, metrics or market discovery.
+	Fetcher   *exchange.Fetcher
+	Exchanges []exchange.Exchange
+	Interval  time.Duration
+	Writer    *Writer
+}
+
+// Run records until ctx is cancelled. A failing exchange is logged and left
+// out of that round rather than stopping the recorder; write errors are
+// returned.
+func (r *Recorder) Run(ctx context.Context) error {
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.record(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Record fetches and writes a single round.
+func (r *Recorder) Record() error {
+	return r.record(context.Background())
+}
+
+// record fetches and writes a round, abandoning fetches and their retries
+// once ctx is done.
+func (r *Recorder) record(ctx context.Context) error {
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		books []*exchange.OrderBook
+	)
+	f := r.Fetcher
+	if f == nil {
+		f = &exchange.Fetcher{Client: r.Client}
+	}
+
+	// exchanges are fetched separately so that one failure doesn't discard
+	// the books of the others
+	for _, e := range r.Exchanges {
+		wg.Add(1)
+		go func(e exchange.Exchange) {
+			defer wg.Done()
+			obs, err := f.GetOrderBooksContext(ctx, e)
+			if err != nil {
+				log.Printf("record: %s: %v", e.Meta().Slug, err)
+				return
+			}
+			mu.Lock()
+			books = append(books, obs...)
+			mu.Unlock()
+		}(e)
+	}
+	wg.Wait()
+
+	_, err := r.Writer.Write(time.Now(), books)
+	return err
+}
@@ -0,0 +1,312 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// Player serves recorded snapshots as they were at a simulated clock time.
+//
+// The exchanges returned by Exchanges behave like live ones: they build
+// requests with replay:// URLs which the Player answers when it is used as
+// the client transport, so exchange.GetOrderBooks(p.Client(), p.Exchanges()...)
+// returns the books as of Now.
+type Player struct {
+	mu        sync.RWMutex
+	now       time.Time
+	times     []time.Time
+	books     map[string][]*Snapshot
+	exchanges []exchange.Exchange
+}
+
+// Load reads the snapshots recorded in dir in [from, to) into a Player.
+func Load(dir string, from, to time.Time, exchanges ...exchange.Exchange) (*Player, error) {
+
+	var snapshots []*Snapshot
+	err := Range(dir, from, to, func(s *Snapshot) error {
+		snapshots = append(snapshots, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewPlayer(snapshots, exchanges...), nil
+}
+
+// NewPlayer creates a Player from snapshots. The exchanges the snapshots
+// were recorded from provide names and pair assets; snapshots of other
+// exchanges are replayed with bare pairs. The clock starts at the first
+// snapshot.
+func NewPlayer(snapshots []*Snapshot, exchanges ...exchange.Exchange) *Player {
+
+	p := &Player{books: map[string][]*Snapshot{}}
+
+	seen := map[time.Time]bool{}
+	recorded := map[string][]string{}
+	var slugs []string
+
+	for _, s := range snapshots {
+		k := key(s.Exchange, s.Pair)
+		if _, ok := p.books[k]; !ok {
+			if _, ok := recorded[s.Exchange]; !ok {
+				slugs = append(slugs, s.Exchange)
+			}
+			recorded[s.Exchange] = append(recorded[s.Exchange], s.Pair)
+		}
+		p.books[k] = append(p.books[k], s)
+		if !seen[s.Time] {
+			seen[s.Time] = true
+			p.times = append(p.times, s.Time)
+		}
+	}
+
+	for _, b := range p.books {
+		sort.SliceStable(b, func(i, j int) bool { return b[i].Time.Before(b[j].Time) })
+	}
+	sort.Slice(p.times, func(i, j int) bool { return p.times[i].Before(p.times[j]) })
+	if len(p.times) > 0 {
+		p.now = p.times[0]
+	}
+
+	originals := map[string]exchange.Exchange{}
+	for _, e := range exchanges {
+		originals[e.Meta().Slug] = e
+	}
+
+	for _, slug := range slugs {
+		p.exchanges = append(p.exchanges, newReplayExchange(slug, recorded[slug], originals[slug]))
+	}
+
+	return p
+}
+
+func key(slug, pair string) string {
+	return slug + "/" + pair
+}
+
+// Exchanges returns one replaying exchange per recorded exchange slug.
+func (p *Player) Exchanges() []exchange.Exchange {
+	return p.exchanges
+}
+
+// Client returns an HTTP client that answers requests from the replaying
+// exchanges.
+func (p *Player) Client() http.Client {
+	return http.Client{Transport: p}
+}
+
+// Start returns the time of the first recorded batch.
+func (p *Player) Start() time.Time {
+	if len(p.times) == 0 {
+		return time.Time{}
+	}
+	return p.times[0]
+}
+
+// End returns the time of the last recorded batch.
+func (p *Player) End() time.Time {
+	if len(p.times) == 0 {
+		return time.Time{}
+	}
+	return p.times[len(p.times)-1]
+}
+
+// Now returns the simulated clock time.
+func (p *Player) Now() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.now
+}
+
+// Seek sets the simulated clock.
+func (p *Player) Seek(t time.Time) {
+	p.mu.Lock()
+	p.now = t
+	p.mu.Unlock()
+}
+
+// Step advances the clock to the next recorded batch. It returns false once
+// the clock is at or past the last batch.
+func (p *Player) Step() bool {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next, ok := p.next(p.now)
+	if ok {
+		p.now = next
+	}
+	return ok
+}
+
+func (p *Player) next(t time.Time) (time.Time, bool) {
+
+	i := sort.Search(len(p.times), func(i int) bool { return p.times[i].After(t) })
+	if i == len(p.times) {
+		return time.Time{}, false
+	}
+	return p.times[i], true
+}
+
+// Play advances the clock through the remaining batches, waiting between
+// them for the recorded interval divided by speed. A speed of zero or less
+// replays without waiting. fn, if not nil, is called after every step.
+func (p *Player) Play(ctx context.Context, speed float64, fn func(time.Time)) error {
+
+	for {
+		now := p.Now()
+		p.mu.RLock()
+		next, ok := p.next(now)
+		p.mu.RUnlock()
+		if !ok {
+			return nil
+		}
+
+		if speed > 0 {
+			timer := time.NewTimer(time.Duration(float64(next.Sub(now)) / speed))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		p.Seek(next)
+		if fn != nil {
+			fn(next)
+		}
+	}
+}
+
+// Snapshot returns the latest snapshot of a pair recorded at or before the
+// clock time, or nil if there is none.
+func (p *Player) Snapshot(slug, pair string) *Snapshot {
+
+	now := p.Now()
+	b := p.books[key(slug, pair)]
+	i := sort.Search(len(b), func(i int) bool { return b[i].Time.After(now) })
+	if i == 0 {
+		return nil
+	}
+	return b[i-1]
+}
+
+// OrderBooks returns the latest book of every replayed pair as of the clock
+// time, skipping pairs not yet recorded. Pair and Exchange are set as they
+// would be by exchange.GetOrderBooks.
+func (p *Player) OrderBooks() []*exchange.OrderBook {
+
+	var books []*exchange.OrderBook
+	for _, e := range p.exchanges {
+		m := e.Meta()
+		for _, pair := range m.Pairs {
+			s := p.Snapshot(m.Slug, pair.Code)
+			if s == nil {
+				continue
+			}
+			ob := s.OrderBook()
+			ob.Pair = pair
+			ob.Exchange = e
+			books = append(books, ob)
+		}
+	}
+	return books
+}
+
+// RoundTrip answers order book requests made by the replaying exchanges.
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if req.URL.Scheme != "replay" {
+		return nil, fmt.Errorf("record: cannot replay %s", req.URL)
+	}
+	slug := req.URL.Host
+	pair := req.URL.Query().Get("pair")
+
+	s := p.Snapshot(slug, pair)
+	if s == nil {
+		return nil, fmt.Errorf("record: no snapshot of %s %s at %s", slug, pair, p.Now().Format(time.RFC3339))
+	}
+
+	b, err := json.Marshal(replayBook{Bids: s.Bids, Asks: s.Asks})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
+
+type replayBook struct {
+	Bids [][2]float64
+	Asks [][2]float64
+}
+
+// ReplayExchange is an exchange whose requests are answered by a Player.
+type ReplayExchange struct {
+	meta *exchange.Meta
+}
+
+func newReplayExchange(slug string, codes []string, original exchange.Exchange) *ReplayExchange {
+
+	meta := &exchange.Meta{Name: slug, Slug: slug}
+	known := map[string]*exchange.Pair{}
+	if original != nil {
+		m := original.Meta()
+		meta.Name = m.Name
+		meta.URL = m.URL
+		for _, pair := range m.Pairs {
+			known[pair.Code] = pair
+		}
+	}
+	for _, code := range codes {
+		pair, ok := known[code]
+		if !ok {
+			pair = &exchange.Pair{Code: code}
+		}
+		meta.Pairs = append(meta.Pairs, pair)
+	}
+	meta.API = "replay://" + slug + "/"
+
+	return &ReplayExchange{meta: meta}
+}
+
+func (re *ReplayExchange) Meta() *exchange.Meta {
+	return re.meta
+}
+
+func (re *ReplayExchange) GetOrderBookRequest(pairCode string) (*http.Request, error) {
+
+	u := exchange.Build(re, "orderbook", map[string]string{"pair": pairCode})
+	return http.NewRequest("GET", u, nil)
+}
+
+func (re *ReplayExchange) ParseOrderBookResponse(body io.Reader) (*exchange.OrderBook, error) {
+
+	var d replayBook
+	if err := json.NewDecoder(body).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &exchange.OrderBook{Bids: d.Bids, Asks: d.Asks}, nil
+}
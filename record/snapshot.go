@@ -0,0 +1,161 @@
+// Package record stores order book snapshots on disk and reads them back.
+//
+// Snapshots are grouped into batches, one batch per fetch. Each batch is
+// written as a separate gzip member appended to a daily data file
+// (2006-01-02.rec.gz), and a fixed-size entry describing the member is
+// appended to the matching index file (2006-01-02.idx). The index is
+// enough to find a time range without decompressing the data.
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// Snapshot is a single recorded order book.
+type Snapshot struct {
+	Seq      uint64
+	Time     time.Time
+	Exchange string
+	Pair     string
+	Bids     [][2]float64
+	Asks     [][2]float64
+}
+
+// NewSnapshot converts an order book fetched with exchange.GetOrderBooks
+// into a snapshot. The sequence number is assigned by the Writer.
+func NewSnapshot(t time.Time, ob *exchange.OrderBook) *Snapshot {
+
+	s := &Snapshot{Time: t, Bids: ob.Bids, Asks: ob.Asks}
+	if ob.Exchange != nil {
+		s.Exchange = ob.Exchange.Meta().Slug
+	}
+	if ob.Pair != nil {
+		s.Pair = ob.Pair.Code
+	}
+	return s
+}
+
+// OrderBook returns the snapshot levels as an order book. Pair and Exchange
+// are left for the caller to fill in.
+func (s *Snapshot) OrderBook() *exchange.OrderBook {
+	return &exchange.OrderBook{Bids: s.Bids, Asks: s.Asks}
+}
+
+var errLongString = errors.New("record: string too long")
+
+func writeSnapshot(w *bufio.Writer, s *Snapshot) error {
+
+	var buf [binary.MaxVarintLen64]byte
+
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		w.Write(buf[:n])
+	}
+	putString := func(v string) {
+		putUvarint(uint64(len(v)))
+		w.WriteString(v)
+	}
+	putLevels := func(levels [][2]float64) {
+		putUvarint(uint64(len(levels)))
+		for _, l := range levels {
+			binary.BigEndian.PutUint64(buf[:8], math.Float64bits(l[0]))
+			w.Write(buf[:8])
+			binary.BigEndian.PutUint64(buf[:8], math.Float64bits(l[1]))
+			w.Write(buf[:8])
+		}
+	}
+
+	if len(s.Exchange) > 255 || len(s.Pair) > 255 {
+		return errLongString
+	}
+
+	putUvarint(s.Seq)
+	n := binary.PutVarint(buf[:], s.Time.UnixNano())
+	w.Write(buf[:n])
+	putString(s.Exchange)
+	putString(s.Pair)
+	putLevels(s.Bids)
+	putLevels(s.Asks)
+
+	return nil
+}
+
+func readSnapshot(r *bufio.Reader) (*Snapshot, error) {
+
+	s := &Snapshot{}
+
+	seq, err := binary.ReadUvarint(r)
+	if err != nil {
+		// a clean EOF before the first byte marks the end of a member
+		return nil, err
+	}
+	s.Seq = seq
+
+	nanos, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	s.Time = time.Unix(0, nanos).UTC()
+
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", unexpected(err)
+		}
+		if n > 255 {
+			return "", errLongString
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", unexpected(err)
+		}
+		return string(b), nil
+	}
+	readLevels := func() ([][2]float64, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		var buf [16]byte
+		var levels [][2]float64
+		for i := uint64(0); i < n; i++ {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return nil, unexpected(err)
+			}
+			levels = append(levels, [2]float64{
+				math.Float64frombits(binary.BigEndian.Uint64(buf[:8])),
+				math.Float64frombits(binary.BigEndian.Uint64(buf[8:])),
+			})
+		}
+		return levels, nil
+	}
+
+	if s.Exchange, err = readString(); err != nil {
+		return nil, err
+	}
+	if s.Pair, err = readString(); err != nil {
+		return nil, err
+	}
+	if s.Bids, err = readLevels(); err != nil {
+		return nil, err
+	}
+	if s.Asks, err = readLevels(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
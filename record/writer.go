@@ -0,0 +1,225 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// Writer appends batches of snapshots to the daily files in a directory.
+//
+// A batch becomes visible once its index entry is written. When a Writer is
+// opened on an existing directory, any data written after the last index
+// entry is discarded and sequence numbers continue from the last indexed
+// batch, so a crash never produces gaps or duplicates.
+type Writer struct {
+	mu     sync.Mutex
+	dir    string
+	seq    uint64
+	day    time.Time
+	data   *os.File
+	idx    *os.File
+	offset int64
+}
+
+// OpenWriter opens dir for recording, creating it if needed.
+func OpenWriter(dir string) (*Writer, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &Writer{dir: dir}
+
+	days, err := Days(dir)
+	if err != nil {
+		return nil, err
+	}
+	// walk back in case the newest index is still empty
+	for i := len(days) - 1; i >= 0; i-- {
+		entries, err := ReadIndex(idxPath(dir, days[i]))
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			w.seq = last.FirstSeq + uint64(last.Count)
+			break
+		}
+	}
+
+	return w, nil
+}
+
+// NextSeq returns the sequence number the next snapshot will get.
+func (w *Writer) NextSeq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// Write records books as a single batch taken at t. Books are stored in the
+// order given and numbered consecutively.
+func (w *Writer) Write(t time.Time, books []*exchange.OrderBook) ([]*Snapshot, error) {
+
+	snapshots := make([]*Snapshot, len(books))
+	for i, ob := range books {
+		snapshots[i] = NewSnapshot(t, ob)
+	}
+	return snapshots, w.WriteSnapshots(t, snapshots)
+}
+
+// WriteSnapshots records snapshots as a single batch taken at t, assigning
+// their sequence numbers. Snapshots without a time are stamped with t.
+func (w *Writer) WriteSnapshots(t time.Time, snapshots []*Snapshot) error {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	if err := w.rotate(dayOf(t)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	bw := bufio.NewWriter(gz)
+	for i, s := range snapshots {
+		s.Seq = w.seq + uint64(i)
+		if s.Time.IsZero() {
+			s.Time = t
+		}
+		if err := writeSnapshot(bw, s); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	// on a failed write the files are closed, so the next batch reopens
+	// them and trims whatever partial data made it to disk
+	if _, err := w.data.Write(buf.Bytes()); err != nil {
+		w.close()
+		return err
+	}
+	if err := w.data.Sync(); err != nil {
+		w.close()
+		return err
+	}
+
+	entry := IndexEntry{
+		Offset:   w.offset,
+		Length:   int64(buf.Len()),
+		FirstSeq: w.seq,
+		Count:    uint32(len(snapshots)),
+		Time:     t,
+	}
+	if _, err := w.idx.Write(entry.marshal()); err != nil {
+		w.close()
+		return err
+	}
+	if err := w.idx.Sync(); err != nil {
+		w.close()
+		return err
+	}
+
+	w.offset = entry.End()
+	w.seq += uint64(len(snapshots))
+	return nil
+}
+
+// rotate switches to the files for day, trimming anything past the last
+// complete index entry.
+func (w *Writer) rotate(day time.Time) error {
+
+	if w.data != nil && day.Equal(w.day) {
+		return nil
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	ip := idxPath(w.dir, day)
+	entries, err := ReadIndex(ip)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var end int64
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		end = last.End()
+		if next := last.FirstSeq + uint64(last.Count); next > w.seq {
+			w.seq = next
+		}
+	}
+
+	idx, err := os.OpenFile(ip, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := idx.Truncate(int64(len(entries) * entrySize)); err != nil {
+		idx.Close()
+		return err
+	}
+	if _, err := idx.Seek(0, io.SeekEnd); err != nil {
+		idx.Close()
+		return err
+	}
+
+	data, err := os.OpenFile(dataPath(w.dir, day), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	if err := data.Truncate(end); err != nil {
+		idx.Close()
+		data.Close()
+		return err
+	}
+	if _, err := data.Seek(end, io.SeekStart); err != nil {
+		idx.Close()
+		data.Close()
+		return err
+	}
+
+	w.day = day
+	w.data = data
+	w.idx = idx
+	w.offset = end
+	return nil
+}
+
+func (w *Writer) close() error {
+
+	if w.data == nil {
+		return nil
+	}
+	err := w.data.Close()
+	if e := w.idx.Close(); err == nil {
+		err = e
+	}
+	w.data = nil
+	w.idx = nil
+	return err
+}
+
+// Close closes the current day's files.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close()
+}
@@ -0,0 +1,161 @@
+package scraper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Challenge is an anti-bot page served instead of the one requested.
+type Challenge struct {
+	Host string
+	// Kind is what the page looks like: "jschl", "captcha", "managed" or
+	// "blocked".
+	Kind string
+	// Request is the request that was challenged, as sent.
+	Request  *http.Request
+	Response *http.Response
+	Body     []byte
+}
+
+// Solver answers one type of challenge. Detection is separate from solving
+// so the transport can pick a solver, or report a challenge none handle.
+type Solver interface {
+	// Name identifies the solver in logs and errors.
+	Name() string
+	// Detect reports whether the solver can answer c.
+	Detect(c *Challenge) bool
+	// Solve answers c with client, whose jar keeps the clearance, and
+	// returns the response to the challenged request.
+	Solve(client *http.Client, c *Challenge) (*http.Response, error)
+}
+
+var (
+	solversMu sync.RWMutex
+	solvers   []Solver
+)
+
+// RegisterSolver makes a solver available to every transport without its
+// own Solvers. It panics if the name is taken.
+func RegisterSolver(s Solver) {
+
+	solversMu.Lock()
+	defer solversMu.Unlock()
+
+	for _, other := range solvers {
+		if other.Name() == s.Name() {
+			panic("scraper: solver " + s.Name() + " registered twice")
+		}
+	}
+	solvers = append(solvers, s)
+}
+
+// Solvers returns the registered solvers in the order they were registered.
+func Solvers() []Solver {
+
+	solversMu.RLock()
+	defer solversMu.RUnlock()
+	return append([]Solver(nil), solvers...)
+}
+
+// ErrUnsupported is the error of a ChallengeError no solver detected.
+var ErrUnsupported = errors.New("No solver for this challenge")
+
+// ErrNotAccepted is the error of a ChallengeError whose answer was met by
+// another challenge.
+var ErrNotAccepted = errors.New("Answer was not accepted")
+
+// ChallengeError reports a challenge that couldn't be solved, instead of
+// passing its page on as if it were the response.
+type ChallengeError struct {
+	Host       string
+	Kind       string
+	StatusCode int
+	// Solver is the name of the solver that failed, empty if there was
+	// none.
+	Solver string
+	Err    error
+}
+
+func (e *ChallengeError) Error() string {
+
+	msg := fmt.Sprintf("Cloudflare %s challenge for %s (HTTP %d)", e.Kind, e.Host, e.StatusCode)
+	if e.Solver != "" {
+		msg += " with " + e.Solver
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *ChallengeError) Unwrap() error {
+	return e.Err
+}
+
+// maxChallengeBody bounds how much of a suspected challenge page is read.
+const maxChallengeBody = 1 << 20
+
+// detect returns the challenge resp is, or nil if it is a normal response.
+// Cloudflare serves challenges with a 403, 429 or 503 and a Server header
+// of "cloudflare" (formerly "cloudflare-nginx"); as origin errors look the
+// same, the page must also have challenge markup. The body of a normal
+// response is left readable.
+func detect(r *http.Request, resp *http.Response) (*Challenge, error) {
+
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return nil, nil
+	}
+	if !strings.HasPrefix(strings.ToLower(resp.Header.Get("Server")), "cloudflare") {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxChallengeBody))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	kind := challengeKind(b)
+	if kind == "" {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+		return nil, nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if resp.Request != nil {
+		// as sent, with the user agent and cookies
+		r = resp.Request
+	}
+	return &Challenge{Host: r.URL.Hostname(), Kind: kind, Request: r, Response: resp, Body: b}, nil
+}
+
+// challengeKind recognises challenge pages by their markup.
+func challengeKind(body []byte) string {
+
+	has := func(markers ...string) bool {
+		for _, m := range markers {
+			if bytes.Contains(body, []byte(m)) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("jschl_vc", "jschl-answer"):
+		return "jschl"
+	case has("cf_captcha_kind", "cf-captcha-container", "g-recaptcha", "h-captcha"):
+		return "captcha"
+	case has("cf_chl_opt", "/cdn-cgi/challenge-platform/", "cf-browser-verification"):
+		return "managed"
+	case has("cf-error-details", "cf-wrapper"):
+		return "blocked"
+	}
+	return ""
+}
@@ -3,67 +3,222 @@ package scraper
 // from github.com/cardigann/go-cloudflare-scraper
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
-
-	"github.com/robertkrimen/otto"
 )
 
 const userAgent = `Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Ubuntu Chromium/64.0.3282.119 Chrome/64.0.3282.119 Safari/537.36`
 
+// challengeDelay is how long Cloudflare requires clients to wait before
+// answering a challenge.
+var challengeDelay = 4 * time.Second
+
+// Transport solves Cloudflare's anti-bot challenges. Clearance cookies are
+// kept in its jar and sent with every request, so a host is only challenged
+// again once they expire, and concurrent requests meeting a challenge wait
+// for a single solve, giving up if their context is done first.
 type Transport struct {
 	upstream http.RoundTripper
 	cookies  http.CookieJar
+
+	// Challenged, if set, is called with the host name whenever a
+	// Cloudflare challenge is met.
+	Challenged func(host string)
+	// Solvers are tried in order to find one that detects a challenge. If
+	// nil, the registered solvers are used.
+	Solvers []Solver
+
+	mu      sync.Mutex
+	solving map[string]*solve
+	solved  map[string]time.Time
 }
 
+// solve is a challenge being solved for a host.
+type solve struct {
+	done chan struct{}
+	err  error
+}
+
+// NewTransport returns a transport keeping cookies in memory.
 func NewTransport(upstream http.RoundTripper) *Transport {
-	jar, _ := cookiejar.New(nil)
-	return &Transport{upstream, jar}
+	jar, _ := NewJar("")
+	return NewTransportWithJar(upstream, jar)
 }
 
-func (t Transport) RoundTrip(r *http.Request) (*http.Response, error) {
-	if r.Header.Get("User-Agent") == "" {
-		r.Header.Set("User-Agent", userAgent)
-	}
+// NewTransportWithJar returns a transport keeping cookies in jar, such as a
+// Jar persisting clearance to disk.
+func NewTransportWithJar(upstream http.RoundTripper, jar http.CookieJar) *Transport {
+	return &Transport{upstream: upstream, cookies: jar, solving: map[string]*solve{}, solved: map[string]time.Time{}}
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
 
-	resp, err := t.upstream.RoundTrip(r)
+	start := time.Now()
+	resp, err := t.send(r)
 	if err != nil {
 		return nil, err
 	}
+	c, err := detect(r, resp)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return resp, nil
+	}
 
-	// Check if Cloudflare anti-bot is on
-	if resp.StatusCode == 503 && resp.Header.Get("Server") == "cloudflare-nginx" {
-		log.Printf("Solving challenge for %s", resp.Request.URL.Hostname())
-		resp, err := t.solveChallenge(resp)
+	host := c.Host
+	t.mu.Lock()
+	if s, ok := t.solving[host]; ok {
+		// another request is solving it
+		t.mu.Unlock()
+		select {
+		case <-s.done:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+		if s.err != nil {
+			return nil, s.err
+		}
+		return t.retry(r)
+	}
+	if t.solved[host].After(start) {
+		// solved while this request was in flight
+		t.mu.Unlock()
+		return t.retry(r)
+	}
+	s := &solve{done: make(chan struct{})}
+	t.solving[host] = s
+	t.mu.Unlock()
 
-		return resp, err
+	resp, err = t.solve(c)
+
+	t.mu.Lock()
+	s.err = err
+	delete(t.solving, host)
+	if err == nil {
+		t.solved[host] = time.Now()
 	}
+	t.mu.Unlock()
+	close(s.done)
 
 	return resp, err
 }
 
-var jschlRegexp = regexp.MustCompile(`name="jschl_vc" value="(\w+)"`)
-var passRegexp = regexp.MustCompile(`name="pass" value="(.+?)"`)
+// solve answers c with the first solver that detects it.
+func (t *Transport) solve(c *Challenge) (*http.Response, error) {
 
-func (t Transport) solveChallenge(resp *http.Response) (*http.Response, error) {
-	time.Sleep(time.Second * 4) // Cloudflare requires a delay before solving the challenge
+	if t.Challenged != nil {
+		t.Challenged(c.Host)
+	}
+	solvers := t.Solvers
+	if solvers == nil {
+		solvers = Solvers()
+	}
+	var solver Solver
+	for _, s := range solvers {
+		if s.Detect(c) {
+			solver = s
+			break
+		}
+	}
+	if solver == nil {
+		return nil, &ChallengeError{Host: c.Host, Kind: c.Kind, StatusCode: c.Response.StatusCode, Err: ErrUnsupported}
+	}
 
-	b, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	log.Printf("Solving %s challenge for %s", c.Kind, c.Host)
+	client := &http.Client{Transport: t.upstream, Jar: t.cookies}
+	resp, err := solver.Solve(client, c)
+	if err == nil {
+		var again *Challenge
+		if again, err = detect(c.Request, resp); again != nil {
+			err = ErrNotAccepted
+		}
+	}
+	if err != nil {
+		return nil, &ChallengeError{Host: c.Host, Kind: c.Kind, StatusCode: c.Response.StatusCode, Solver: solver.Name(), Err: err}
+	}
+	return resp, nil
+}
+
+// send sends a copy of r with the user agent and the jar's cookies set,
+// and stores the cookies of the response.
+func (t *Transport) send(r *http.Request) (*http.Response, error) {
+
+	req := r.Clone(r.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	for _, c := range t.cookies.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+	resp, err := t.upstream.RoundTrip(req)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if rc := resp.Cookies(); len(rc) > 0 {
+		t.cookies.SetCookies(req.URL, rc)
+	}
+	return resp, nil
+}
 
+// retry sends r again after a challenge was solved.
+func (t *Transport) retry(r *http.Request) (*http.Response, error) {
+
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.GetBody == nil {
+			return nil, errors.New("Cannot retry request with a body after a challenge")
+		}
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r = r.Clone(r.Context())
+		r.Body = body
+	}
+	resp, err := t.send(r)
+	if err != nil {
+		return nil, err
+	}
+	c, err := detect(r, resp)
+	if c != nil {
+		err = &ChallengeError{Host: c.Host, Kind: c.Kind, StatusCode: resp.StatusCode, Err: ErrNotAccepted}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+var jschlRegexp = regexp.MustCompile(`name="jschl_vc" value="(\w+)"`)
+var passRegexp = regexp.MustCompile(`name="pass" value="(.+?)"`)
+
+// JSChallenge solves Cloudflare's original "I'm Under Attack" page, which
+// asks the browser to compute an arithmetic puzzle in JavaScript.
+type JSChallenge struct{}
+
+func init() {
+	RegisterSolver(JSChallenge{})
+}
+
+func (JSChallenge) Name() string {
+	return "jschl"
+}
+
+func (JSChallenge) Detect(c *Challenge) bool {
+	return c.Kind == "jschl" && jsRegexp.Match(c.Body)
+}
+
+func (JSChallenge) Solve(client *http.Client, c *Challenge) (*http.Response, error) {
+	time.Sleep(challengeDelay)
+
+	b := c.Body
 	var params = make(url.Values)
 
 	if m := jschlRegexp.FindStringSubmatch(string(b)); len(m) > 0 {
@@ -75,49 +230,31 @@ func (t Transport) solveChallenge(resp *http.Response) (*http.Response, error) {
 	}
 
 	chkURL, _ := url.Parse("/cdn-cgi/l/chk_jschl")
-	u := resp.Request.URL.ResolveReference(chkURL)
+	u := c.Request.URL.ResolveReference(chkURL)
 
-	js, err := t.extractJS(string(b))
+	js, err := extractJS(string(b))
 	if err != nil {
 		return nil, err
 	}
+	log.Printf("Evaluating challenge script for %s: %s", c.Host, js)
 
-	answer, err := t.evaluateJS(js)
+	answer, err := evaluateJS(js)
 	if err != nil {
 		return nil, err
 	}
 
-	params.Set("jschl_answer", strconv.Itoa(int(answer)+len(resp.Request.URL.Host)))
+	params.Set("jschl_answer", strconv.Itoa(int(answer)+len(c.Request.URL.Host)))
 
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s?%s", u.String(), params.Encode()), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("User-Agent", resp.Request.Header.Get("User-Agent"))
-	req.Header.Set("Referer", resp.Request.URL.String())
+	req.Header.Set("User-Agent", c.Request.Header.Get("User-Agent"))
+	req.Header.Set("Referer", c.Request.URL.String())
 
 	log.Printf("Requesting %s?%s", u.String(), params.Encode())
-	client := http.Client{
-		Transport: t.upstream,
-		Jar:       t.cookies,
-	}
-
-	resp, err = client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	return resp, nil
-}
-
-func (t Transport) evaluateJS(js string) (int64, error) {
-	vm := otto.New()
-	result, err := vm.Run(js)
-	if err != nil {
-		return 0, err
-	}
-	return result.ToInteger()
+	return client.Do(req)
 }
 
 var jsRegexp = regexp.MustCompile(
@@ -128,7 +265,7 @@ var jsReplace1Regexp = regexp.MustCompile(`a\.value = (parseInt\(.+?\)).+`)
 var jsReplace2Regexp = regexp.MustCompile(`\s{3,}[a-z](?: = |\.).+`)
 var jsReplace3Regexp = regexp.MustCompile(`[\n\\']`)
 
-func (t Transport) extractJS(body string) (string, error) {
+func extractJS(body string) (string, error) {
 	matches := jsRegexp.FindStringSubmatch(body)
 	if len(matches) == 0 {
 		return "", errors.New("No matching javascript found")
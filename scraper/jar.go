@@ -0,0 +1,166 @@
+package scraper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Jar is a cookie jar that keeps cookies with an expiry, such as Cloudflare
+// clearance, in a file per host so they outlive the process. Session
+// cookies are kept in memory only.
+type Jar struct {
+	dir string
+
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	cookies map[string]map[string]*storedCookie // host, name
+}
+
+type storedCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Expires  time.Time `json:"expires"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+}
+
+// NewJar returns a jar persisting cookies in dir, loading those saved
+// before that haven't expired. An empty dir keeps everything in memory.
+func NewJar(dir string) (*Jar, error) {
+
+	jar, _ := cookiejar.New(nil)
+	j := &Jar{dir: dir, jar: jar, cookies: map[string]map[string]*storedCookie{}}
+	if dir == "" {
+		return j, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := j.load(file); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+func (j *Jar) load(file string) error {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var stored []*storedCookie
+	if err := json.Unmarshal(b, &stored); err != nil {
+		// a corrupt file only costs a new challenge
+		return nil
+	}
+
+	host := strings.TrimSuffix(filepath.Base(file), ".json")
+	u := &url.URL{Scheme: "https", Host: host, Path: "/"}
+	now := time.Now()
+	var cookies []*http.Cookie
+	for _, c := range stored {
+		if !c.Expires.After(now) {
+			continue
+		}
+		if j.cookies[host] == nil {
+			j.cookies[host] = map[string]*storedCookie{}
+		}
+		j.cookies[host][c.Name] = c
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value, Domain: c.Domain, Path: c.Path,
+			Expires: c.Expires, Secure: c.Secure, HttpOnly: c.HttpOnly})
+	}
+	j.jar.SetCookies(u, cookies)
+	return nil
+}
+
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SetCookies stores cookies, saving the host's file if any of them expire.
+// Failing to save is not fatal, as the cookies are still used in memory.
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+
+	j.jar.SetCookies(u, cookies)
+
+	host := u.Hostname()
+	now := time.Now()
+	changed := false
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, c := range cookies {
+		expires := c.Expires
+		if c.MaxAge > 0 {
+			expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		if c.MaxAge < 0 || (!expires.IsZero() && !expires.After(now)) {
+			if _, ok := j.cookies[host][c.Name]; ok {
+				delete(j.cookies[host], c.Name)
+				changed = true
+			}
+			continue
+		}
+		if expires.IsZero() {
+			continue
+		}
+		if j.cookies[host] == nil {
+			j.cookies[host] = map[string]*storedCookie{}
+		}
+		j.cookies[host][c.Name] = &storedCookie{c.Name, c.Value, c.Domain, c.Path, expires, c.Secure, c.HttpOnly}
+		changed = true
+	}
+	if changed && j.dir != "" {
+		j.save(host)
+	}
+}
+
+// Expires returns when the named cookie of a host expires, or the zero time
+// if the jar isn't keeping it.
+func (j *Jar) Expires(host, name string) time.Time {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if c, ok := j.cookies[host][name]; ok && c.Expires.After(time.Now()) {
+		return c.Expires
+	}
+	return time.Time{}
+}
+
+func (j *Jar) save(host string) error {
+
+	var stored []*storedCookie
+	for _, c := range j.cookies[host] {
+		stored = append(stored, c)
+	}
+	sort.Slice(stored, func(a, b int) bool { return stored[a].Name < stored[b].Name })
+	b, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(j.dir, host+".json")
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,104 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// jsTimeout bounds the evaluation of a challenge script. Cloudflare's
+// puzzles take microseconds; anything longer is hostile or broken.
+var jsTimeout = time.Second
+
+// maxScript bounds the length of a challenge script. Since only scripts
+// of the puzzle's shape are run, see checkScript, this also bounds the
+// memory evaluation uses.
+const maxScript = 16 << 10
+
+// jsExpr matches the puzzle's arithmetic: operators, parentheses and empty
+// arrays. Without names, literals or indexing, no property such as
+// constructor can be reached and nothing can be called or repeated, so
+// every value is a number, a boolean or a string in proportion to the
+// script.
+const jsExpr = `(?:[-+*/!()]|\[\])+`
+
+// jsHeader matches the start of a puzzle, naming the object and key that
+// hold the answer.
+var jsHeader = regexp.MustCompile(`^var s,t,o,p,b,r,e,a,k,i,n,g,f, (\w+)=\{"(\w+)":`)
+
+// ErrScriptShape is returned for scripts that aren't an arithmetic puzzle.
+var ErrScriptShape = errors.New("Challenge script is not an arithmetic puzzle")
+
+// checkScript accepts only the jschl puzzle: an object with one key set to
+// an expression, assignments to that key and a parseInt of it.
+func checkScript(js string) error {
+
+	m := jsHeader.FindStringSubmatch(js)
+	if m == nil {
+		return ErrScriptShape
+	}
+	v := regexp.QuoteMeta(m[1]) + `\.` + regexp.QuoteMeta(m[2])
+	puzzle := regexp.MustCompile(`^` + regexp.QuoteMeta(m[0]) + jsExpr + `\};[\s;]*(?:` + v + `[-+*/]=` + jsExpr + `;)*parseInt\(` + v + `, 10\)$`)
+	if !puzzle.MatchString(js) {
+		return ErrScriptShape
+	}
+	return nil
+}
+
+// ErrScriptTimeout is returned for scripts interrupted at the deadline.
+var ErrScriptTimeout = errors.New("Challenge script timed out")
+
+// jsBlocked are the globals removed before evaluation, in case a script
+// gets past checkScript. Challenge scripts only need arithmetic on
+// numbers, strings and arrays, so ways of compiling more code, and sources
+// of slow or non-deterministic behaviour, are left out.
+var jsBlocked = []string{"eval", "Function", "Date", "RegExp", "JSON", "console",
+	"decodeURI", "decodeURIComponent", "encodeURI", "encodeURIComponent", "escape", "unescape"}
+
+// evaluateJS checks that a challenge script is a puzzle, runs it in a
+// fresh VM with the blocked globals removed, interrupting it after
+// jsTimeout, and returns its value as an integer.
+func evaluateJS(js string) (answer int64, err error) {
+
+	if len(js) > maxScript {
+		return 0, fmt.Errorf("Challenge script is %d bytes, more than %d", len(js), maxScript)
+	}
+	if err := checkScript(js); err != nil {
+		return 0, err
+	}
+
+	vm := otto.New()
+	for _, name := range jsBlocked {
+		if err := vm.Set(name, otto.UndefinedValue()); err != nil {
+			return 0, err
+		}
+	}
+
+	vm.Interrupt = make(chan func(), 1)
+	timer := time.AfterFunc(jsTimeout, func() {
+		vm.Interrupt <- func() {
+			panic(ErrScriptTimeout)
+		}
+	})
+	defer timer.Stop()
+	defer func() {
+		if r := recover(); r != nil {
+			if r != ErrScriptTimeout {
+				panic(r)
+			}
+			err = ErrScriptTimeout
+		}
+	}()
+
+	result, err := vm.Run(js)
+	if err != nil {
+		return 0, err
+	}
+	if !result.IsNumber() {
+		return 0, fmt.Errorf("Challenge script returned %s, not a number", result.Class())
+	}
+	return result.ToInteger()
+}
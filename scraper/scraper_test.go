@@ -0,0 +1,446 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocale(t *testing.T) {
+
+	za := Locale{Decimal: ",", Thousands: " ", Prefixes: []string{"R"}}
+	us := Locale{Thousands: ",", Prefixes: []string{"$", "US$"}}
+	for _, c := range []struct {
+		l    Locale
+		s    string
+		want float64
+	}{
+		{za, "R 1 234,56", 1234.56},
+		{za, "R1 234,5", 1234.5},
+		{za, " 18,0213 ", 18.0213},
+		{us, "$1,234.56", 1234.56},
+		{us, "0.125", 0.125},
+		{Locale{}, "-3.5", -3.5},
+	} {
+		got, err := c.l.ParseNumber(c.s)
+		if err != nil || got != c.want {
+			t.Errorf("ParseNumber(%q) = %v, %v, want %v", c.s, got, err, c.want)
+		}
+	}
+	if _, err := us.ParseNumber("n/a"); err == nil {
+		t.Error("Expected an invalid number to fail")
+	}
+}
+
+func TestSpec(t *testing.T) {
+
+	page := `<table>
+		<tr><th>Code</th><th>Rate</th><th>Cash</th></tr>
+		<tr><td>USD</td><td>14.10</td><td>-</td></tr>
+		<tr><td>EUR</td><td>15.87</td><td>15.42</td></tr>
+		<tr><td colspan="3">Indicative rates</td></tr>
+	</table>`
+	s := &Spec{
+		Name: "rates",
+		Rows: "tr",
+		Fields: []Field{
+			{Name: "code", Selector: "td:nth-of-type(1)", Match: regexp.MustCompile(`^[A-Z]{3}$`)},
+			{Name: "rate", Selector: "td:nth-of-type(2)", Number: true},
+			{Name: "cash", Selector: "td:nth-of-type(3)", Number: true, Optional: true},
+		},
+	}
+	rows, err := s.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Text["code"] != "USD" || rows[0].Float("rate") != 14.1 || rows[1].Float("cash") != 15.42 {
+		t.Errorf("Unexpected rows %+v", rows)
+	}
+	if _, ok := rows[0].Numbers["cash"]; ok || rows[1].Index != 2 {
+		t.Errorf("Unexpected rows %+v %+v", rows[0], rows[1])
+	}
+
+	s.MaxRows = 1
+	if _, err := s.Parse(strings.NewReader(page)); err == nil {
+		t.Error("Expected too many rows to fail")
+	} else if e, ok := err.(*CountError); !ok || e.Count != 2 {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	s.MaxRows = 0
+	s.Rows = "tr.missing"
+	if rows, err := s.Parse(strings.NewReader(page)); err != nil || len(rows) != 0 {
+		t.Errorf("Expected no rows without a minimum, got %v %v", rows, err)
+	}
+	s.MinRows = 1
+	if _, err := s.Parse(strings.NewReader(page)); err == nil {
+		t.Error("Expected no rows to fail")
+	}
+
+	s.Rows = "tr"
+	s.Fields[1].Positive = true
+	if _, err := s.Parse(strings.NewReader(strings.Replace(page, "15.87", "0", 1))); err == nil {
+		t.Error("Expected a zero rate to fail")
+	} else if e, ok := err.(*FieldError); !ok || e.Field != "rate" {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	s.Rows = "tr"
+	page = strings.Replace(page, "15.87", "15,87", 1)
+	if _, err := s.Parse(strings.NewReader(page)); err == nil {
+		t.Error("Expected a bad number to fail")
+	} else if e, ok := err.(*FieldError); !ok || e.Row != 2 || e.Field != "rate" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+const challengePage = `<html><body>
+<script type="text/javascript">
+  setTimeout(function(){
+    var s,t,o,p,b,r,e,a,k,i,n,g,f, abc={"x":+((!+[]+!![]+[]))};
+        t = document.createElement('div');
+        a = document.getElementById('jschl-answer');
+        a.value = parseInt(abc.x, 10) + t.length; '; 121'
+  }, 4000);
+</script>
+<form id="challenge-form" action="/cdn-cgi/l/chk_jschl" method="get">
+  <input type="hidden" name="jschl_vc" value="abc123"/>
+  <input type="hidden" name="pass" value="1500000000.1-xyz"/>
+  <input type="hidden" id="jschl-answer" name="jschl_answer"/>
+</form>
+</body></html>`
+
+// cloudflare returns an upstream that challenges requests without
+// clearance, counting the challenges answered.
+func cloudflare(t *testing.T, solves *int32) http.RoundTripper {
+
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("page")), Request: r}
+		if r.URL.Path == "/cdn-cgi/l/chk_jschl" {
+			atomic.AddInt32(solves, 1)
+			// 2 from the script plus the length of example.com
+			if r.URL.Query().Get("jschl_answer") != "13" || r.URL.Query().Get("jschl_vc") != "abc123" {
+				t.Errorf("Unexpected answer %s", r.URL.RawQuery)
+			}
+			resp.Header.Set("Set-Cookie", "cf_clearance=ok; Path=/; Max-Age=3600")
+			return resp, nil
+		}
+		if c, err := r.Cookie("cf_clearance"); err == nil && c.Value == "ok" {
+			return resp, nil
+		}
+		resp.StatusCode = 503
+		resp.Header.Set("Server", "cloudflare-nginx")
+		resp.Body = ioutil.NopCloser(strings.NewReader(challengePage))
+		return resp, nil
+	})
+}
+
+func TestTransport(t *testing.T) {
+
+	challengeDelay = 20 * time.Millisecond
+	defer func() { challengeDelay = 4 * time.Second }()
+
+	dir, err := ioutil.TempDir("", "jar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jar, err := NewJar(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var solves, challenged int32
+	tr := NewTransportWithJar(cloudflare(t, &solves), jar)
+	tr.Challenged = func(host string) { atomic.AddInt32(&challenged, 1) }
+	client := http.Client{Transport: tr}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("https://example.com/book")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			b, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != 200 || string(b) != "page" {
+				t.Errorf("Unexpected response %d %q", resp.StatusCode, b)
+			}
+		}()
+	}
+	wg.Wait()
+	if solves != 1 || challenged != 1 {
+		t.Errorf("Expected a single solve, got %d solves and %d challenges", solves, challenged)
+	}
+
+	exp := jar.Expires("example.com", "cf_clearance")
+	if d := time.Until(exp); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Unexpected clearance expiry %v", exp)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "example.com.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected cookie file to be private, got %v", fi.Mode().Perm())
+	}
+
+	// a new process reuses the clearance
+	jar, err = NewJar(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = http.Client{Transport: NewTransportWithJar(cloudflare(t, &solves), jar)}
+	resp, err := client.Get("https://example.com/book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || solves != 1 {
+		t.Errorf("Expected saved clearance to be used, got %d after %d solves", resp.StatusCode, solves)
+	}
+
+	// expired cookies are not loaded
+	b, _ := json.Marshal([]*storedCookie{{Name: "cf_clearance", Value: "ok", Expires: time.Now().Add(-time.Minute)}})
+	if err := ioutil.WriteFile(filepath.Join(dir, "example.com.json"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	jar, err = NewJar(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jar.Cookies(&url.URL{Scheme: "https", Host: "example.com", Path: "/"})) != 0 {
+		t.Error("Expected expired clearance to be dropped")
+	}
+}
+
+// managedSolver answers managed challenges by fetching a clearance URL.
+type managedSolver struct{}
+
+func (managedSolver) Name() string             { return "managed" }
+func (managedSolver) Detect(c *Challenge) bool { return c.Kind == "managed" }
+func (managedSolver) Solve(client *http.Client, c *Challenge) (*http.Response, error) {
+	resp, err := client.Get("https://" + c.Host + "/clear")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return client.Get(c.Request.URL.String())
+}
+
+// slowSolver is a managedSolver that waits to be released.
+type slowSolver struct {
+	managedSolver
+	started, release chan struct{}
+}
+
+func (s slowSolver) Solve(client *http.Client, c *Challenge) (*http.Response, error) {
+	close(s.started)
+	<-s.release
+	return s.managedSolver.Solve(client, c)
+}
+
+func TestSolvers(t *testing.T) {
+
+	managed := `<html><script>window._cf_chl_opt={cType: 'managed'};</script></html>`
+	var cleared bool
+	upstream := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("page")), Request: r}
+		switch {
+		case r.URL.Path == "/clear" && cleared:
+			resp.Header.Set("Set-Cookie", "cf_clearance=ok; Path=/; Max-Age=60")
+		case r.URL.Path == "/down":
+			resp.StatusCode = 503
+			resp.Header.Set("Server", "cloudflare")
+			resp.Body = ioutil.NopCloser(strings.NewReader("origin is down"))
+		default:
+			if c, err := r.Cookie("cf_clearance"); err == nil && c.Value == "ok" {
+				return resp, nil
+			}
+			resp.StatusCode = 403
+			resp.Header.Set("Server", "cloudflare")
+			resp.Body = ioutil.NopCloser(strings.NewReader(managed))
+		}
+		return resp, nil
+	})
+
+	// an origin error behind Cloudflare is passed on
+	client := http.Client{Transport: NewTransport(upstream)}
+	resp, err := client.Get("https://example.com/down")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 503 || string(b) != "origin is down" {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, b)
+	}
+
+	// no registered solver handles managed challenges
+	_, err = client.Get("https://example.com/book")
+	var ce *ChallengeError
+	if !errors.As(err, &ce) || ce.Kind != "managed" || ce.StatusCode != 403 || !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected an unsupported challenge, got %v", err)
+	}
+
+	tr := NewTransport(upstream)
+	tr.Solvers = []Solver{JSChallenge{}, managedSolver{}}
+	client = http.Client{Transport: tr}
+	_, err = client.Get("https://example.com/book")
+	if !errors.As(err, &ce) || ce.Solver != "managed" || !errors.Is(err, ErrNotAccepted) {
+		t.Errorf("Expected the answer to be refused, got %v", err)
+	}
+
+	cleared = true
+	resp, err = client.Get("https://example.com/book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected the solved page, got %d", resp.StatusCode)
+	}
+
+	// a request waiting on another's solve gives up when its context is done
+	slow := slowSolver{started: make(chan struct{}), release: make(chan struct{})}
+	tr = NewTransport(upstream)
+	tr.Solvers = []Solver{slow}
+	client = http.Client{Transport: tr}
+	solved := make(chan error)
+	go func() {
+		resp, err := client.Get("https://example.com/book")
+		if err == nil {
+			resp.Body.Close()
+		}
+		solved <- err
+	}()
+	<-slow.started
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", "https://example.com/book", nil)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := client.Do(req.WithContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the waiter to be cancelled, got %v", err)
+	}
+	close(slow.release)
+	if err := <-solved; err != nil {
+		t.Errorf("Expected the solve to finish, got %v", err)
+	}
+
+	for body, kind := range map[string]string{
+		challengePage: "jschl",
+		managed:       "managed",
+		`<div class="cf-captcha-container"><div class="g-recaptcha"></div></div>`: "captcha",
+		`<div id="cf-wrapper"><div id="cf-error-details">Error 1020</div></div>`:  "blocked",
+		`{"error":"maintenance"}`: "",
+	} {
+		if got := challengeKind([]byte(body)); got != kind {
+			t.Errorf("challengeKind(%.20q) = %q, want %q", body, got, kind)
+		}
+	}
+}
+
+// TestChallengeCorpus checks the challenge pages in testdata/challenges,
+// recorded from Cloudflare or made hostile, are recognised and that their
+// scripts evaluate, or fail safely.
+func TestChallengeCorpus(t *testing.T) {
+
+	jsTimeout = 50 * time.Millisecond
+	defer func() { jsTimeout = time.Second }()
+
+	for _, c := range []struct {
+		file    string
+		kind    string
+		answer  int64
+		failure error // nil to expect the answer, ErrScriptShape or errAny
+	}{
+		{"jschl.html", "jschl", 423, nil},
+		{"jschl-2.html", "jschl", 966, nil},
+		{"loop.html", "jschl", 0, ErrScriptShape},
+		{"eval.html", "jschl", 0, errAny},
+		{"managed.html", "managed", 0, nil},
+		{"captcha.html", "captcha", 0, nil},
+		{"blocked.html", "blocked", 0, nil},
+	} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "challenges", c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := challengeKind(b); kind != c.kind {
+			t.Errorf("%s: kind %q, want %q", c.file, kind, c.kind)
+		}
+		ch := &Challenge{Kind: c.kind, Body: b}
+		if detected := (JSChallenge{}).Detect(ch); detected != (c.kind == "jschl") {
+			t.Errorf("%s: detected by the jschl solver: %v", c.file, detected)
+		}
+		if c.kind != "jschl" {
+			continue
+		}
+
+		js, err := extractJS(string(b))
+		if err != nil {
+			t.Errorf("%s: %v", c.file, err)
+			continue
+		}
+		start := time.Now()
+		answer, err := evaluateJS(js)
+		switch {
+		case c.failure == nil && (err != nil || answer != c.answer):
+			t.Errorf("%s: got %d, %v, want %d", c.file, answer, err, c.answer)
+		case c.failure == ErrScriptShape && err != ErrScriptShape:
+			t.Errorf("%s: expected the script to be refused, got %d, %v", c.file, answer, err)
+		case c.failure == errAny && err == nil:
+			t.Errorf("%s: expected an error, got %d", c.file, answer)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: evaluation took %s", c.file, d)
+		}
+	}
+
+	if _, err := evaluateJS(strings.Repeat(" ", maxScript+1) + "1"); err == nil {
+		t.Error("Expected an oversized script to be refused")
+	}
+	// only the puzzle's arithmetic is run, so scripts can't allocate at
+	// will or reach Function through a constructor
+	puzzle := `var s,t,o,p,b,r,e,a,k,i,n,g,f, x={"y":+((!+[]+!![]+[])+(+[]))};        ;x.y+=!+[]+!![];%sparseInt(x.y, 10)`
+	if answer, err := evaluateJS(fmt.Sprintf(puzzle, "")); err != nil || answer != 22 {
+		t.Errorf("Expected 22, got %d, %v", answer, err)
+	}
+	for _, js := range []string{
+		`"not a number"`,
+		`new Array(1e8).join("xxxx").length`,
+		fmt.Sprintf(puzzle, `x.y+=(function(){}).constructor("return 1")();`),
+		fmt.Sprintf(puzzle, `x.y+=[]["filter"]["constructor"]("return 1")();`),
+		fmt.Sprintf(puzzle, `x.y+=[][(![]+[])[+[]]];`),
+		fmt.Sprintf(puzzle, `x.y+=z;`),
+		fmt.Sprintf(puzzle, `w.y+=!![];`),
+		strings.Replace(fmt.Sprintf(puzzle, ""), "parseInt(x.y, 10)", "parseInt(x.y, 10);for(;;){}", 1),
+	} {
+		if _, err := evaluateJS(js); err != ErrScriptShape {
+			t.Errorf("Expected %s to be refused, got %v", js, err)
+		}
+	}
+}
+
+var errAny = errors.New("any error")
@@ -0,0 +1,207 @@
+package scraper
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Locale describes how a page prints numbers.
+type Locale struct {
+	// Decimal is the decimal separator, "." if empty.
+	Decimal string
+	// Thousands is the digit group separator, if any. A space also matches
+	// non-breaking and thin spaces.
+	Thousands string
+	// Prefixes, such as "R" or "$", are stripped before parsing.
+	Prefixes []string
+}
+
+// ParseNumber parses s as printed in the locale, such as "R 1 234,56".
+func (l Locale) ParseNumber(s string) (float64, error) {
+
+	n := strings.TrimSpace(s)
+	for _, p := range l.Prefixes {
+		if strings.HasPrefix(n, p) {
+			n = strings.TrimSpace(n[len(p):])
+			break
+		}
+	}
+	if l.Thousands != "" {
+		n = strings.Replace(n, l.Thousands, "", -1)
+		if l.Thousands == " " {
+			n = strings.NewReplacer("\u00a0", "", "\u2009", "", "\u202f", "").Replace(n)
+		}
+	}
+	if l.Decimal != "" && l.Decimal != "." {
+		n = strings.Replace(n, l.Decimal, ".", 1)
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %q", s)
+	}
+	return v, nil
+}
+
+// Field maps part of a row to a named value.
+type Field struct {
+	Name string
+	// Selector finds the field within the row, the row itself if empty.
+	// The text of the first match is used.
+	Selector string
+	// Attr reads an attribute instead of the text.
+	Attr string
+	// Number parses the value with the spec's locale.
+	Number bool
+	// Positive numbers must be above zero, as prices and rates are.
+	Positive bool
+	// Match, if set, must match the value, otherwise the row is not a
+	// data row and is skipped, such as a heading or a footnote.
+	Match *regexp.Regexp
+	// Optional fields that are empty or don't parse are left out of the
+	// row instead of failing the scrape.
+	Optional bool
+}
+
+// Spec declares how to read rows of values from an HTML page, so that
+// layout changes fail loudly instead of yielding empty or wrong data.
+type Spec struct {
+	// Name identifies the spec in errors.
+	Name string
+	// Rows selects each row.
+	Rows   string
+	Fields []Field
+	Locale Locale
+	// MinRows and MaxRows bound the number of data rows expected; a count
+	// outside them usually means the layout changed. A zero MaxRows means
+	// no upper bound.
+	MinRows int
+	MaxRows int
+}
+
+// Row holds the values of one data row.
+type Row struct {
+	// Index is the position of the row among those matched by Rows.
+	Index   int
+	Text    map[string]string
+	Numbers map[string]float64
+}
+
+// Float returns a number field, or zero if it was optional and missing.
+func (r *Row) Float(name string) float64 {
+	return r.Numbers[name]
+}
+
+// FieldError reports a required field that was missing or didn't parse.
+type FieldError struct {
+	Spec  string
+	Row   int
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: row %d: %s: %v", e.Spec, e.Row, e.Field, e.Err)
+}
+
+// CountError reports an unexpected number of data rows.
+type CountError struct {
+	Spec     string
+	Count    int
+	Min, Max int
+}
+
+func (e *CountError) Error() string {
+	if e.Max > 0 {
+		return fmt.Sprintf("%s: matched %d rows, expected %d to %d", e.Spec, e.Count, e.Min, e.Max)
+	}
+	return fmt.Sprintf("%s: matched %d rows, expected at least %d", e.Spec, e.Count, e.Min)
+}
+
+// Parse reads a page and scrapes it.
+func (s *Spec) Parse(body io.Reader) ([]*Row, error) {
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return s.Scrape(doc.Selection)
+}
+
+// Scrape reads the rows within sel. It fails on the first required field
+// that is missing or doesn't parse, and with a *CountError if the number of
+// data rows is out of bounds.
+func (s *Spec) Scrape(sel *goquery.Selection) ([]*Row, error) {
+
+	var (
+		rows []*Row
+		err  error
+	)
+	sel.Find(s.Rows).EachWithBreak(func(i int, row *goquery.Selection) bool {
+		var r *Row
+		r, err = s.row(i, row)
+		if r != nil {
+			rows = append(rows, r)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) < s.MinRows || (s.MaxRows > 0 && len(rows) > s.MaxRows) {
+		return nil, &CountError{Spec: s.Name, Count: len(rows), Min: s.MinRows, Max: s.MaxRows}
+	}
+	return rows, nil
+}
+
+// row reads a row, returning nil for rows that aren't data rows.
+func (s *Spec) row(i int, row *goquery.Selection) (*Row, error) {
+
+	texts := make([]string, len(s.Fields))
+	for j, f := range s.Fields {
+		el := row
+		if f.Selector != "" {
+			el = row.Find(f.Selector).First()
+		}
+		if f.Attr != "" {
+			texts[j], _ = el.Attr(f.Attr)
+		} else {
+			texts[j] = el.Text()
+		}
+		texts[j] = strings.TrimSpace(texts[j])
+		if f.Match != nil && !f.Match.MatchString(texts[j]) {
+			return nil, nil
+		}
+	}
+
+	r := &Row{Index: i, Text: map[string]string{}, Numbers: map[string]float64{}}
+	for j, f := range s.Fields {
+		text := texts[j]
+		if text == "" {
+			if f.Optional {
+				continue
+			}
+			return nil, &FieldError{s.Name, i, f.Name, fmt.Errorf("Missing")}
+		}
+		if f.Number {
+			v, err := s.Locale.ParseNumber(text)
+			if err == nil && f.Positive && !(v > 0) {
+				err = fmt.Errorf("%v is not positive", v)
+			}
+			if err != nil {
+				if f.Optional {
+					continue
+				}
+				return nil, &FieldError{s.Name, i, f.Name, err}
+			}
+			r.Numbers[f.Name] = v
+		}
+		r.Text[f.Name] = text
+	}
+	return r, nil
+}
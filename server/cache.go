@@ -0,0 +1,227 @@
+// Package server shares a refreshed cache of order books over HTTP.
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// Book is a cached order book and the time it was fetched. Seq increases
+// with every update of the same pair.
+type Book struct {
+	*exchange.OrderBook
+	Updated time.Time
+	Seq     uint64
+}
+
+// Status describes the freshness of an exchange in the cache.
+type Status struct {
+	Exchange    string     `json:"exchange"`
+	LastAttempt time.Time  `json:"last_attempt"`
+	LastSuccess time.Time  `json:"last_success"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	Books       int        `json:"books"`
+}
+
+// Cache keeps the books of every pair on a set of exchanges up to date.
+type Cache struct {
+	Fetcher   *exchange.Fetcher
+	Exchanges []exchange.Exchange
+	Interval  time.Duration
+	// Intervals overrides Interval per exchange slug.
+	Intervals map[string]time.Duration
+
+	mu       sync.RWMutex
+	books    map[string]*Book
+	statuses map[string]*Status
+
+	subMu       sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+// Run refreshes each exchange at its interval until ctx is cancelled.
+func (c *Cache) Run(ctx context.Context) {
+
+	var wg sync.WaitGroup
+	for _, e := range c.Exchanges {
+		wg.Add(1)
+		go func(e exchange.Exchange) {
+			defer wg.Done()
+			c.run(ctx, e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (c *Cache) run(ctx context.Context, e exchange.Exchange) {
+
+	ticker := time.NewTicker(c.interval(e.Meta().Slug))
+	defer ticker.Stop()
+
+	for {
+		c.refresh(ctx, e)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// interval returns the refresh interval of an exchange.
+func (c *Cache) interval(slug string) time.Duration {
+
+	if d, ok := c.Intervals[slug]; ok {
+		return d
+	}
+	return c.Interval
+}
+
+// Refresh fetches every pair once. Pairs that fail keep their previous
+// book, and the error is recorded in the exchange status.
+func (c *Cache) Refresh() {
+
+	var wg sync.WaitGroup
+	for _, e := range c.Exchanges {
+		wg.Add(1)
+		go func(e exchange.Exchange) {
+			defer wg.Done()
+			c.refresh(context.Background(), e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// refresh fetches every pair of one exchange, abandoning fetches and their
+// retries once ctx is done. The pairs of a BookBatcher are fetched together
+// and fail together.
+func (c *Cache) refresh(ctx context.Context, e exchange.Exchange) {
+
+	pairs := c.Fetcher.Pairs(e)
+	if _, ok := e.(exchange.BookBatcher); ok {
+		started := time.Now()
+		obs, err := c.Fetcher.GetOrderBookBatch(ctx, e, pairs)
+		for i, p := range pairs {
+			var ob *exchange.OrderBook
+			if err == nil {
+				ob = obs[i]
+			}
+			c.update(e.Meta().Slug, p.Code, started, ob, err)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range pairs {
+		wg.Add(1)
+		go func(p *exchange.Pair) {
+			defer wg.Done()
+			started := time.Now()
+			ob, err := c.Fetcher.GetOrderBookContext(ctx, e, p)
+			c.update(e.Meta().Slug, p.Code, started, ob, err)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func (c *Cache) update(slug, pair string, t time.Time, ob *exchange.OrderBook, err error) {
+
+	prev, next := c.store(slug, pair, t, ob, err)
+	if next != nil {
+		c.publish(prev, next)
+	}
+}
+
+// store records a fetch and returns the replaced and new book, if any.
+func (c *Cache) store(slug, pair string, t time.Time, ob *exchange.OrderBook, err error) (*Book, *Book) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.books == nil {
+		c.books = map[string]*Book{}
+		c.statuses = map[string]*Status{}
+	}
+	s, ok := c.statuses[slug]
+	if !ok {
+		s = &Status{Exchange: slug}
+		c.statuses[slug] = s
+	}
+	if t.After(s.LastAttempt) {
+		s.LastAttempt = t
+	}
+
+	if err != nil {
+		s.LastError = err.Error()
+		s.LastErrorAt = &t
+		return nil, nil
+	}
+	k := key(slug, pair)
+	prev, ok := c.books[k]
+	next := &Book{OrderBook: ob, Updated: t, Seq: 1}
+	if ok {
+		next.Seq = prev.Seq + 1
+	} else {
+		s.Books++
+	}
+	c.books[k] = next
+	if t.After(s.LastSuccess) {
+		s.LastSuccess = t
+	}
+	return prev, next
+}
+
+func key(slug, pair string) string {
+	return slug + ":" + pair
+}
+
+// Book returns the cached book of a pair, or nil.
+func (c *Cache) Book(slug, pair string) *Book {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.books[key(slug, pair)]
+}
+
+// Books returns every cached book, ordered by exchange and pair.
+func (c *Cache) Books() []*Book {
+
+	c.mu.RLock()
+	var books []*Book
+	for _, b := range c.books {
+		books = append(books, b)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(books, func(i, j int) bool {
+		si, sj := books[i].Exchange.Meta().Slug, books[j].Exchange.Meta().Slug
+		if si != sj {
+			return si < sj
+		}
+		return books[i].Pair.Code < books[j].Pair.Code
+	})
+	return books
+}
+
+// Statuses returns the status of every configured exchange, including those
+// not fetched yet.
+func (c *Cache) Statuses() []Status {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var statuses []Status
+	for _, e := range c.Exchanges {
+		slug := e.Meta().Slug
+		if s, ok := c.statuses[slug]; ok {
+			statuses = append(statuses, *s)
+		} else {
+			statuses = append(statuses, Status{Exchange: slug})
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+// Server serves the books in a Cache as JSON.
+//
+//	GET /books?exchange=luno&pair=XBTZAR     cached books, both filters optional
+//	GET /depth?exchange=luno&pair=XBTZAR     prepared book with cumulative columns
+//	GET /simulate?exchange=luno&pair=XBTZAR&amount=1&type=buy&quote=false
+//	GET /route?legs=kraken:XXBTZEUR,luno:XBTZAR&asset=eur&amount=1000
+//	GET /health                              per exchange freshness and errors
+//	GET /stream?topics=luno:XBTZAR,kraken:*  server-sent events of book changes
+//	GET /ws?topics=luno:*                    the same messages over a WebSocket
+//	GET /metrics                             metrics, when configured
+//
+// Streaming clients get a snapshot of every matching book followed by
+// incremental updates; see Message.
+type Server struct {
+	Cache *Cache
+
+	// MaxAge is how old an exchange's newest book may be before /health
+	// reports it as stale. Zero means three of the exchange's cache
+	// intervals.
+	MaxAge time.Duration
+
+	// StreamBuffer is how many messages are queued per streaming client
+	// before it is considered behind and falls back to snapshots. Zero
+	// means 64.
+	StreamBuffer int
+
+	// Metrics, if set, is served on /metrics.
+	Metrics http.Handler
+
+	// Scheduler, if set, is asked for each exchange's remaining request
+	// budget, which /health then reports.
+	Scheduler *exchange.Scheduler
+
+	// Origins are the origins, such as https://dash.example.com, whose pages
+	// may open /ws; "*" allows any. Pages served from the server's own host
+	// and clients that send no Origin, which browsers always do, are
+	// allowed too.
+	Origins []string
+}
+
+// Handler returns the HTTP handler for all endpoints.
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/books", s.books)
+	mux.HandleFunc("/depth", s.depth)
+	mux.HandleFunc("/simulate", s.simulate)
+	mux.HandleFunc("/route", s.route)
+	mux.HandleFunc("/health", s.health)
+	mux.HandleFunc("/stream", s.stream)
+	mux.Handle("/ws", websocket.Server{Handler: s.ws, Handshake: s.checkOrigin})
+	if s.Metrics != nil {
+		mux.Handle("/metrics", s.Metrics)
+	}
+	return mux
+}
+
+// checkOrigin refuses the WebSocket handshake of a page from an origin not
+// allowed by Origins.
+func (s *Server) checkOrigin(config *websocket.Config, r *http.Request) error {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range s.Origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Origin %q is not allowed", origin)
+}
+
+type pairJSON struct {
+	Code     string  `json:"code"`
+	Base     string  `json:"base"`
+	Quote    string  `json:"quote"`
+	TakerFee float64 `json:"taker_fee"`
+	MakerFee float64 `json:"maker_fee"`
+}
+
+func newPairJSON(p *exchange.Pair) pairJSON {
+
+	pj := pairJSON{Code: p.Code, TakerFee: p.TakerFee, MakerFee: p.MakerFee}
+	if p.Base != nil {
+		pj.Base = p.Base.Code
+	}
+	if p.Quote != nil {
+		pj.Quote = p.Quote.Code
+	}
+	return pj
+}
+
+type bookJSON struct {
+	Exchange string       `json:"exchange"`
+	Pair     pairJSON     `json:"pair"`
+	Updated  time.Time    `json:"updated"`
+	Bids     [][2]float64 `json:"bids"`
+	Asks     [][2]float64 `json:"asks"`
+}
+
+func newBookJSON(b *Book) *bookJSON {
+
+	bj := &bookJSON{
+		Exchange: b.Exchange.Meta().Slug,
+		Pair:     newPairJSON(b.Pair),
+		Updated:  b.Updated,
+		Bids:     b.Bids,
+		Asks:     b.Asks,
+	}
+	if bj.Bids == nil {
+		bj.Bids = [][2]float64{}
+	}
+	if bj.Asks == nil {
+		bj.Asks = [][2]float64{}
+	}
+	return bj
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) books(w http.ResponseWriter, r *http.Request) {
+
+	slug := r.FormValue("exchange")
+	pair := r.FormValue("pair")
+
+	books := []*bookJSON{}
+	for _, b := range s.Cache.Books() {
+		if slug != "" && b.Exchange.Meta().Slug != slug {
+			continue
+		}
+		if pair != "" && !strings.EqualFold(b.Pair.Code, pair) {
+			continue
+		}
+		books = append(books, newBookJSON(b))
+	}
+	writeJSON(w, http.StatusOK, books)
+}
+
+// book looks up the book named by the exchange and pair parameters.
+func (s *Server) book(r *http.Request) (*Book, error) {
+
+	slug := r.FormValue("exchange")
+	pair := r.FormValue("pair")
+	if slug == "" || pair == "" {
+		return nil, fmt.Errorf("exchange and pair are required")
+	}
+	return s.find(slug, pair)
+}
+
+// find returns the cached book of a pair, matching the code case
+// insensitively.
+func (s *Server) find(slug, pair string) (*Book, error) {
+
+	for _, b := range s.Cache.Books() {
+		if b.Exchange.Meta().Slug == slug && strings.EqualFold(b.Pair.Code, pair) {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("no book for %s on %s", pair, slug)
+}
+
+func (s *Server) depth(w http.ResponseWriter, r *http.Request) {
+
+	b, err := s.book(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	p := b.Prepare()
+	if p.Bids == nil {
+		p.Bids = [][5]float64{}
+	}
+	if p.Asks == nil {
+		p.Asks = [][5]float64{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Exchange string       `json:"exchange"`
+		Pair     pairJSON     `json:"pair"`
+		Updated  time.Time    `json:"updated"`
+		Columns  []string     `json:"columns"`
+		Bids     [][5]float64 `json:"bids"`
+		Asks     [][5]float64 `json:"asks"`
+	}{
+		Exchange: b.Exchange.Meta().Slug,
+		Pair:     newPairJSON(b.Pair),
+		Updated:  b.Updated,
+		Columns:  []string{"price", "volume", "value", "cum_volume", "cum_value"},
+		Bids:     p.Bids,
+		Asks:     p.Asks,
+	})
+}
+
+func parseOrderType(s string) (exchange.OrderType, error) {
+	switch strings.ToLower(s) {
+	case "buy", "":
+		return exchange.BUY, nil
+	case "sell":
+		return exchange.SELL, nil
+	}
+	return exchange.BUY, fmt.Errorf("type must be buy or sell")
+}
+
+type tradeJSON struct {
+	Exchange  string  `json:"exchange"`
+	Pair      string  `json:"pair"`
+	Type      string  `json:"type"`
+	Amount    float64 `json:"amount"`
+	Quote     bool    `json:"quote"`
+	Gross     float64 `json:"gross"`
+	Fee       float64 `json:"fee"`
+	Nett      float64 `json:"nett"`
+	GrossUnit float64 `json:"gross_unit"`
+	NettUnit  float64 `json:"nett_unit"`
+	Asset     string  `json:"asset"`
+}
+
+func (s *Server) simulate(w http.ResponseWriter, r *http.Request) {
+
+	b, err := s.book(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+	if err != nil || amount <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("amount must be a positive number"))
+		return
+	}
+	ot, err := parseOrderType(r.FormValue("type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	quote := r.FormValue("quote") == "true" || r.FormValue("quote") == "1"
+
+	t := &exchange.Trade{OrderBook: b.Prepare(), Amount: amount, Type: ot, Quote: quote, Pair: b.Pair}
+	res, err := t.Simulate()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tj := &tradeJSON{
+		Exchange:  b.Exchange.Meta().Slug,
+		Pair:      b.Pair.Code,
+		Type:      strings.ToLower(r.FormValue("type")),
+		Amount:    amount,
+		Quote:     quote,
+		Gross:     res.Gross,
+		Fee:       res.Fee,
+		Nett:      res.Nett,
+		GrossUnit: res.GrossUnit,
+		NettUnit:  res.NettUnit,
+	}
+	if tj.Type == "" {
+		tj.Type = "buy"
+	}
+	if res.Asset != nil {
+		tj.Asset = res.Asset.Code
+	}
+	writeJSON(w, http.StatusOK, tj)
+}
+
+type requestJSON struct {
+	Exchange string  `json:"exchange"`
+	Pair     string  `json:"pair"`
+	Type     string  `json:"type"`
+	Volume   float64 `json:"volume"`
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+
+	asset := exchange.AssetByCode(r.FormValue("asset"))
+	if asset == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown asset %q", r.FormValue("asset")))
+		return
+	}
+	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+	if err != nil || amount <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("amount must be a positive number"))
+		return
+	}
+
+	route := &exchange.Route{}
+	for _, leg := range strings.Split(r.FormValue("legs"), ",") {
+		parts := strings.SplitN(leg, ":", 2)
+		if len(parts) != 2 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("leg %q must be exchange:pair", leg))
+			return
+		}
+		found, err := s.find(parts[0], parts[1])
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		route.Legs = append(route.Legs, &exchange.RouteLeg{Pair: found.Pair, OrderBook: found.Prepare(), Exchange: found.Exchange})
+	}
+
+	res, err := route.Simulate(asset, amount)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	requests := []*requestJSON{}
+	for _, req := range res.Requests {
+		t := "sell"
+		if req.Type == exchange.BUY {
+			t = "buy"
+		}
+		requests = append(requests, &requestJSON{Exchange: req.Exchange.Meta().Slug, Pair: req.Pair.Code, Type: t, Volume: req.Volume})
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Amount      float64        `json:"amount"`
+		Asset       string         `json:"asset"`
+		Description string         `json:"description"`
+		Requests    []*requestJSON `json:"requests"`
+	}{res.Amount, res.Asset.Code, strings.TrimSpace(res.Description), requests})
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+
+	type statusJSON struct {
+		Status
+		Age    string   `json:"age,omitempty"`
+		Fresh  bool     `json:"fresh"`
+		Budget *float64 `json:"budget,omitempty"`
+	}
+
+	ok := true
+	statuses := []*statusJSON{}
+	for _, st := range s.Cache.Statuses() {
+		sj := &statusJSON{Status: st}
+		maxAge := s.MaxAge
+		if maxAge == 0 {
+			maxAge = 3 * s.Cache.interval(st.Exchange)
+		}
+		if !st.LastSuccess.IsZero() {
+			age := time.Since(st.LastSuccess)
+			sj.Age = age.Round(time.Millisecond).String()
+			sj.Fresh = age <= maxAge
+		}
+		if s.Scheduler != nil {
+			if remaining, limited := s.Scheduler.Remaining(st.Exchange); limited {
+				sj.Budget = &remaining
+			}
+		}
+		ok = ok && sj.Fresh
+		statuses = append(statuses, sj)
+	}
+
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, struct {
+		OK        bool          `json:"ok"`
+		Exchanges []*statusJSON `json:"exchanges"`
+	}{ok, statuses})
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/jacoduplessis/crypto/exchange"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestServer(t *testing.T) {
+
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Query().Get("pair") != "XBTZAR" {
+			return nil, errors.New("unavailable")
+		}
+		body := `{"bids": [{"price": "90", "volume": "1"}], "asks": [{"price": "100", "volume": "10"}]}`
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+
+	cache := &Cache{Fetcher: &exchange.Fetcher{Client: client}, Exchanges: []exchange.Exchange{&exchange.Luno{}}, Interval: time.Minute}
+	cache.Refresh()
+
+	ts := httptest.NewServer((&Server{Cache: cache}).Handler())
+	defer ts.Close()
+
+	get := func(path string, want int, v interface{}) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var books []*bookJSON
+	get("/books?exchange=luno", 200, &books)
+	if len(books) != 1 || books[0].Pair.Code != "XBTZAR" || books[0].Pair.Base != "xbt" {
+		t.Errorf("Unexpected books %+v", books)
+	}
+
+	var trade tradeJSON
+	get("/simulate?exchange=luno&pair=xbtzar&amount=500&quote=true", 200, &trade)
+	if trade.Gross != 5 || trade.Asset != "xbt" {
+		t.Errorf("Unexpected trade %+v", trade)
+	}
+
+	var route struct {
+		Amount   float64
+		Requests []*requestJSON
+	}
+	get("/route?legs=luno:XBTZAR&asset=zar&amount=500", 200, &route)
+	if route.Amount != 5 || len(route.Requests) != 1 || route.Requests[0].Exchange != "luno" {
+		t.Errorf("Unexpected route %+v", route)
+	}
+
+	var errResp map[string]string
+	get("/depth?exchange=luno&pair=ETHXBT", 404, &errResp)
+
+	var health struct {
+		OK        bool
+		Exchanges []*Status
+	}
+	get("/health", 200, &health)
+	if !health.OK || len(health.Exchanges) != 1 || !strings.Contains(health.Exchanges[0].LastError, "unavailable") {
+		t.Errorf("Unexpected health %+v", health.Exchanges[0])
+	}
+}
+
+func TestStream(t *testing.T) {
+
+	price := 100
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Query().Get("pair") != "XBTZAR" {
+			return nil, errors.New("unavailable")
+		}
+		body := fmt.Sprintf(`{"bids": [{"price": "90", "volume": "1"}], "asks": [{"price": "%d", "volume": "10"}]}`, price)
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+
+	cache := &Cache{Fetcher: &exchange.Fetcher{Client: client}, Exchanges: []exchange.Exchange{&exchange.Luno{}}, Interval: time.Minute}
+	cache.Refresh()
+
+	sub := cache.subscribe([]string{"luno:xbtzar"}, 1)
+	ctx := context.Background()
+
+	m, _ := cache.next(ctx, sub)
+	if m.Type != "snapshot" || m.Seq != 1 {
+		t.Fatalf("Expected initial snapshot, got %+v", m)
+	}
+
+	price = 101
+	cache.Refresh()
+	m, _ = cache.next(ctx, sub)
+	if m.Type != "update" || !reflect.DeepEqual(m.Asks, [][2]float64{{101, 10}, {100, 0}}) || len(m.Bids) != 0 {
+		t.Fatalf("Expected an update of the asks, got %+v", m)
+	}
+
+	// the second update overflows the queue of one
+	price = 102
+	cache.Refresh()
+	price = 103
+	cache.Refresh()
+
+	m, _ = cache.next(ctx, sub)
+	if m.Type != "snapshot" || m.Seq != 4 || m.Asks[0][0] != 103 {
+		t.Fatalf("Expected a snapshot after falling behind, got %+v", m)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if m, err := cache.next(short, sub); err == nil {
+		t.Errorf("Expected the queued update to be skipped, got %+v", m)
+	}
+
+	ts := httptest.NewServer((&Server{Cache: cache}).Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/stream?topics=luno:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var m Message
+		json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &m)
+		if m.Type != "snapshot" || m.Pair != "XBTZAR" || m.Seq != 4 {
+			t.Errorf("Unexpected first event %+v", m)
+		}
+		break
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+
+	client := http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Query().Get("pair") != "XBTZAR" {
+			return nil, errors.New("unavailable")
+		}
+		body := `{"bids": [{"price": "90", "volume": "1"}], "asks": [{"price": "100", "volume": "10"}]}`
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+
+	cache := &Cache{Fetcher: &exchange.Fetcher{Client: client}, Exchanges: []exchange.Exchange{&exchange.Luno{}}, Interval: time.Minute}
+	cache.Refresh()
+
+	ts := httptest.NewServer((&Server{Cache: cache, Origins: []string{"https://dash.example.com/"}}).Handler())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?topics=luno:xbtzar"
+
+	for _, c := range []struct {
+		origin string
+		ok     bool
+	}{
+		{ts.URL, true},
+		{"https://dash.example.com", true},
+		{"https://evil.example.com", false},
+	} {
+		config, err := websocket.NewConfig(url, c.origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := websocket.DialConfig(config)
+		if !c.ok {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: expected the handshake to be refused", c.origin)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.origin, err)
+			continue
+		}
+
+		conn.SetDeadline(time.Now().Add(time.Second))
+		var m Message
+		if err := websocket.JSON.Receive(conn, &m); err != nil {
+			t.Errorf("%s: %v", c.origin, err)
+		} else if m.Type != "snapshot" || m.Exchange != "luno" || m.Pair != "XBTZAR" || m.Asks[0][0] != 100 {
+			t.Errorf("%s: unexpected first message %+v", c.origin, m)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Message is sent to streaming clients. A snapshot carries the full book;
+// an update carries only the levels that changed since the previous
+// message for the same pair, with a volume of zero for removed levels.
+type Message struct {
+	Type     string       `json:"type"`
+	Exchange string       `json:"exchange"`
+	Pair     string       `json:"pair"`
+	Seq      uint64       `json:"seq"`
+	Updated  time.Time    `json:"updated"`
+	Bids     [][2]float64 `json:"bids"`
+	Asks     [][2]float64 `json:"asks"`
+}
+
+func snapshotMessage(b *Book) *Message {
+
+	m := &Message{
+		Type:     "snapshot",
+		Exchange: b.Exchange.Meta().Slug,
+		Pair:     b.Pair.Code,
+		Seq:      b.Seq,
+		Updated:  b.Updated,
+		Bids:     b.Bids,
+		Asks:     b.Asks,
+	}
+	if m.Bids == nil {
+		m.Bids = [][2]float64{}
+	}
+	if m.Asks == nil {
+		m.Asks = [][2]float64{}
+	}
+	return m
+}
+
+func updateMessage(prev, next *Book) *Message {
+
+	m := snapshotMessage(next)
+	m.Type = "update"
+	m.Bids = diff(prev.Bids, next.Bids)
+	m.Asks = diff(prev.Asks, next.Asks)
+	return m
+}
+
+// diff returns the levels of next whose volume differs from prev, plus the
+// prices only in prev with a volume of zero.
+func diff(prev, next [][2]float64) [][2]float64 {
+
+	old := map[float64]float64{}
+	for _, l := range prev {
+		old[l[0]] = l[1]
+	}
+
+	changes := [][2]float64{}
+	for _, l := range next {
+		if v, ok := old[l[0]]; !ok || v != l[1] {
+			changes = append(changes, l)
+		}
+		delete(old, l[0])
+	}
+	for _, l := range prev {
+		if _, ok := old[l[0]]; ok {
+			changes = append(changes, [2]float64{l[0], 0})
+		}
+	}
+	return changes
+}
+
+// subscriber is one streaming client. Updates are queued on ch; when the
+// queue is full the pair is marked stale instead, its updates are dropped,
+// and the client is sent a fresh snapshot once it catches up.
+type subscriber struct {
+	topics []string
+	ch     chan *Message
+	wake   chan struct{}
+	stale  map[string]bool
+	sent   map[string]uint64
+}
+
+// matches reports whether a pair is covered by the topics, which are
+// exchange:pair with * allowed for either part.
+func (s *subscriber) matches(slug, pair string) bool {
+
+	for _, t := range s.topics {
+		parts := strings.SplitN(t, ":", 2)
+		if parts[0] != "*" && parts[0] != slug {
+			continue
+		}
+		if len(parts) == 1 || parts[1] == "*" || strings.EqualFold(parts[1], pair) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe registers a subscriber. Every matching book already cached is
+// marked stale, so the client starts with snapshots.
+func (c *Cache) subscribe(topics []string, buffer int) *subscriber {
+
+	s := &subscriber{
+		topics: topics,
+		ch:     make(chan *Message, buffer),
+		wake:   make(chan struct{}, 1),
+		stale:  map[string]bool{},
+		sent:   map[string]uint64{},
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if c.subscribers == nil {
+		c.subscribers = map[*subscriber]bool{}
+	}
+	c.subscribers[s] = true
+	for _, b := range c.Books() {
+		if s.matches(b.Exchange.Meta().Slug, b.Pair.Code) {
+			s.stale[key(b.Exchange.Meta().Slug, b.Pair.Code)] = true
+		}
+	}
+	return s
+}
+
+func (c *Cache) unsubscribe(s *subscriber) {
+	c.subMu.Lock()
+	delete(c.subscribers, s)
+	c.subMu.Unlock()
+}
+
+func (c *Cache) publish(prev, next *Book) {
+
+	slug, pair := next.Exchange.Meta().Slug, next.Pair.Code
+	k := key(slug, pair)
+
+	var m *Message
+	if prev == nil {
+		m = snapshotMessage(next)
+	} else {
+		m = updateMessage(prev, next)
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	for s := range c.subscribers {
+		if !s.matches(slug, pair) || s.stale[k] {
+			continue
+		}
+		select {
+		case s.ch <- m:
+		default:
+			s.stale[k] = true
+			select {
+			case s.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// next returns the next message for s, waiting until one is available or
+// ctx is done. Stale pairs are answered with a snapshot first; queued
+// messages older than a snapshot already sent are skipped.
+func (c *Cache) next(ctx context.Context, s *subscriber) (*Message, error) {
+
+	for {
+		c.subMu.Lock()
+		var k string
+		for k = range s.stale {
+			delete(s.stale, k)
+			break
+		}
+		c.subMu.Unlock()
+
+		if k != "" {
+			c.mu.RLock()
+			b := c.books[k]
+			c.mu.RUnlock()
+			if b == nil {
+				continue
+			}
+			s.sent[k] = b.Seq
+			return snapshotMessage(b), nil
+		}
+
+		select {
+		case m := <-s.ch:
+			k := key(m.Exchange, m.Pair)
+			if m.Seq <= s.sent[k] {
+				continue
+			}
+			s.sent[k] = m.Seq
+			return m, nil
+		case <-s.wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Server) buffer() int {
+	if s.StreamBuffer > 0 {
+		return s.StreamBuffer
+	}
+	return 64
+}
+
+func topics(r *http.Request) []string {
+	t := r.FormValue("topics")
+	if t == "" {
+		return []string{"*"}
+	}
+	return strings.Split(t, ",")
+}
+
+// stream serves messages as server-sent events.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	sub := s.Cache.subscribe(topics(r), s.buffer())
+	defer s.Cache.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	for {
+		m, err := s.Cache.next(ctx, sub)
+		if err != nil {
+			return
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Type, b); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// ws serves messages over a WebSocket, one JSON message per frame. Anything
+// the client sends is ignored.
+func (s *Server) ws(conn *websocket.Conn) {
+
+	defer conn.Close()
+
+	sub := s.Cache.subscribe(topics(conn.Request()), s.buffer())
+	defer s.Cache.unsubscribe(sub)
+
+	ctx, cancel := context.WithCancel(conn.Request().Context())
+	defer cancel()
+
+	// reading is the only way to notice the client going away
+	go func() {
+		defer cancel()
+		var discard []byte
+		for {
+			if err := websocket.Message.Receive(conn, &discard); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		m, err := s.Cache.next(ctx, sub)
+		if err != nil {
+			return
+		}
+		if err := websocket.JSON.Send(conn, m); err != nil {
+			return
+		}
+	}
+}